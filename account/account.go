@@ -2,16 +2,97 @@ package account
 
 import (
 	"context"
+	"github.com/georgysavva/generic-wallet/money"
+	"github.com/pkg/errors"
+	"time"
 )
 
+const (
+	ActiveStatus = "active"
+	// FrozenStatus accounts can't send money, but they can receive it.
+	FrozenStatus = "frozen"
+	// SuspendedStatus accounts can neither send nor receive money.
+	SuspendedStatus = "suspended"
+	ClosedStatus    = "closed"
+)
+
+// StandardTier is the tier of new accounts.
+const StandardTier = "standard"
+
 type Account struct {
-	Id       string  `json:"id"`
-	Balance  float64 `json:"balance"`
-	Currency string  `json:"currency"`
+	Id      string       `json:"id"`
+	Balance money.Amount `json:"balance"`
+	// AvailableBalance is the balance without the money reserved by active holds, payments can't exceed it.
+	AvailableBalance money.Amount `json:"available_balance"`
+	Currency         string       `json:"currency"`
+	Status           string       `json:"status"`
+	// StatusReason explains the last status change, e.g. why the account has been frozen.
+	StatusReason string `json:"status_reason,omitempty"`
+	// OverdraftLimit is how far below zero the available balance may go, it's zero for most accounts.
+	OverdraftLimit money.Amount `json:"overdraft_limit"`
+	// SpendingLimits are set for the account specifically, the unset ones fall back to the currency defaults.
+	SpendingLimits SpendingLimits `json:"spending_limits"`
+	// Tier selects the fee rules applied to the payments of the account.
+	Tier string `json:"tier"`
+}
+
+const (
+	// DailyLimitWindow and MonthlyLimitWindow are the rolling windows the spent money is summed over.
+	DailyLimitWindow   = 24 * time.Hour
+	MonthlyLimitWindow = 30 * DailyLimitWindow
+)
+
+// SpendingLimits cap the money an account can send, limits that aren't set aren't enforced.
+type SpendingLimits struct {
+	PerTransaction *money.Amount `json:"per_transaction,omitempty"`
+	Daily          *money.Amount `json:"daily,omitempty"`
+	Monthly        *money.Amount `json:"monthly,omitempty"`
 }
 
+// WithDefaults returns the limits with the unset ones taken from defaults, defaults can be nil.
+func (l SpendingLimits) WithDefaults(defaults *SpendingLimits) SpendingLimits {
+	if defaults == nil {
+		return l
+	}
+	if l.PerTransaction == nil {
+		l.PerTransaction = defaults.PerTransaction
+	}
+	if l.Daily == nil {
+		l.Daily = defaults.Daily
+	}
+	if l.Monthly == nil {
+		l.Monthly = defaults.Monthly
+	}
+	return l
+}
+
+var AlreadyExistsErr = errors.New("account with the same id already exists")
+var NonZeroBalanceErr = errors.New("account with a non-zero balance can't be closed")
+var ClosedErr = errors.New("account is closed")
+
 type Repository interface {
 	GetAll(ctx context.Context, offset, limit *int) ([]*Account, error)
 	CountAll(ctx context.Context) (int, error)
+	// GetAllAfter returns up to limit accounts following the one with afterId, ordered by id.
+	GetAllAfter(ctx context.Context, afterId string, limit int) ([]*Account, error)
+	// GetAllBefore returns up to limit accounts preceding the one with beforeId, ordered by id.
+	GetAllBefore(ctx context.Context, beforeId string, limit int) ([]*Account, error)
 	Get(ctx context.Context, accountId string) (*Account, error)
+	// Create returns AlreadyExistsErr if the account id is taken.
+	Create(ctx context.Context, account *Account) error
+	// Close returns nil if the account doesn't exist and NonZeroBalanceErr if its balance isn't zero.
+	// Closing a closed account does nothing.
+	Close(ctx context.Context, accountId string) (*Account, error)
+	// SetStatus changes the status of the account and its reason.
+	// It returns nil if the account doesn't exist and ClosedErr if the account has been closed.
+	SetStatus(ctx context.Context, accountId, status, reason string) (*Account, error)
+	// SetOverdraftLimit changes the overdraft limit of the account,
+	// lowering it doesn't affect the money already spent on credit.
+	// It returns nil if the account doesn't exist and ClosedErr if the account has been closed.
+	SetOverdraftLimit(ctx context.Context, accountId string, limit money.Amount) (*Account, error)
+	// SetSpendingLimits replaces the spending limits of the account.
+	// It returns nil if the account doesn't exist and ClosedErr if the account has been closed.
+	SetSpendingLimits(ctx context.Context, accountId string, limits SpendingLimits) (*Account, error)
+	// SetTier returns nil if the account doesn't exist and ClosedErr if the account has been closed.
+	SetTier(ctx context.Context, accountId, tier string) (*Account, error)
 }
@@ -2,6 +2,8 @@ package config
 
 import (
 	"encoding/json"
+	"github.com/georgysavva/generic-wallet/account"
+	"github.com/georgysavva/generic-wallet/fee"
 	"io/ioutil"
 )
 
@@ -16,11 +18,140 @@ type Postgres struct {
 	RetriesNum int `yaml:"retries_num" json:"retries_num"`
 }
 
+const (
+	// FXRejectMode rejects payments between accounts with different currencies.
+	FXRejectMode = "reject"
+	// FXConvertMode converts payment amounts between currencies at the current exchange rate.
+	FXConvertMode = "convert"
+)
+
+type FX struct {
+	// Either FXRejectMode or FXConvertMode, FXRejectMode is used by default.
+	Mode string `yaml:"mode" json:"mode"`
+	// RatesFile is a path to a JSON file with static exchange rates, e.g. {"USD/EUR": 0.9}.
+	// It takes precedence over RatesURL.
+	RatesFile string `yaml:"rates_file" json:"rates_file"`
+	// RatesURL is the address of a rates service, see fx.HTTPProvider.
+	RatesURL string `yaml:"rates_url" json:"rates_url"`
+	// In milliseconds
+	Timeout int `yaml:"timeout" json:"timeout"`
+}
+
+type Scheduler struct {
+	// Interval between checks for due scheduled payments, in milliseconds.
+	Interval int `yaml:"interval" json:"interval"`
+	// BatchSize is the max number of scheduled payments a worker claims at once.
+	BatchSize int `yaml:"batch_size" json:"batch_size"`
+}
+
+type StandingOrders struct {
+	// Interval between checks for due standing orders, in milliseconds.
+	Interval int `yaml:"interval" json:"interval"`
+	// BatchSize is the max number of standing orders a worker claims at once.
+	BatchSize int `yaml:"batch_size" json:"batch_size"`
+	// RetryAttempts is the max number of attempts to pay an occurrence if the source account has a low balance.
+	RetryAttempts int `yaml:"retry_attempts" json:"retry_attempts"`
+	// Interval between the attempts, in milliseconds.
+	RetryInterval int `yaml:"retry_interval" json:"retry_interval"`
+}
+
+type APIKey struct {
+	Key string `yaml:"key" json:"key"`
+	// Principal is the id of the key owner.
+	Principal string `yaml:"principal" json:"principal"`
+	// Role of the owner, either "customer" or "operator", customer is the default.
+	Role string `yaml:"role" json:"role"`
+	// Accounts the owner can move money out of.
+	Accounts []string `yaml:"accounts" json:"accounts"`
+}
+
+type Auth struct {
+	// Disabled turns authentication off, anyone can move money out of any account then.
+	Disabled bool      `yaml:"disabled" json:"disabled"`
+	APIKeys  []*APIKey `yaml:"api_keys" json:"api_keys"`
+	// JWTKeys are HMAC secrets of JWTs signed with HS256 by key id.
+	JWTKeys map[string]string `yaml:"jwt_keys" json:"jwt_keys"`
+}
+
+type RateLimit struct {
+	// PerSecond is the average number of requests a client can make per second.
+	PerSecond float64 `yaml:"per_second" json:"per_second"`
+	// Burst is the max number of requests a client can make at once.
+	Burst int `yaml:"burst" json:"burst"`
+}
+
+// RateLimits are applied per client, missing limits don't limit the requests.
+type RateLimits struct {
+	// Reads limit requests getting data.
+	Reads *RateLimit `yaml:"reads" json:"reads"`
+	// Writes limit requests sending payments and changing data.
+	Writes *RateLimit `yaml:"writes" json:"writes"`
+	// AuthFailures limit requests failing authentication per IP address.
+	AuthFailures *RateLimit `yaml:"auth_failures" json:"auth_failures"`
+}
+
+const (
+	// StdoutExporter writes spans to stdout as JSON lines.
+	StdoutExporter = "stdout"
+	// HTTPExporter posts spans as JSON to a collector.
+	HTTPExporter = "http"
+)
+
+type Tracing struct {
+	// Either StdoutExporter or HTTPExporter, spans aren't exported if it's empty.
+	Exporter     string `yaml:"exporter" json:"exporter"`
+	CollectorURL string `yaml:"collector_url" json:"collector_url"`
+	// In milliseconds
+	Timeout int `yaml:"timeout" json:"timeout"`
+}
+
+const (
+	// LogPublisher writes outbox events to the log.
+	LogPublisher = "log"
+	// HTTPPublisher posts outbox events as JSON to a webhook.
+	HTTPPublisher = "http"
+	// FilePublisher appends outbox events to a file as JSON lines.
+	FilePublisher = "file"
+)
+
+type Outbox struct {
+	// One of LogPublisher, HTTPPublisher or FilePublisher, events are written to the log if it's empty.
+	Publisher string `yaml:"publisher" json:"publisher"`
+	URL       string `yaml:"url" json:"url"`
+	File      string `yaml:"file" json:"file"`
+	// In milliseconds
+	Timeout int `yaml:"timeout" json:"timeout"`
+	// Interval between checks for pending events, in milliseconds.
+	Interval int `yaml:"interval" json:"interval"`
+	// BatchSize is the max number of events a relay claims at once.
+	BatchSize int `yaml:"batch_size" json:"batch_size"`
+	// RetryInterval is the delay before the first retry of a failed delivery, in milliseconds.
+	// It doubles with every next retry.
+	RetryInterval int `yaml:"retry_interval" json:"retry_interval"`
+	// MaxAttempts is the number of failed deliveries after which an event is moved to the dead letters.
+	MaxAttempts int `yaml:"max_attempts" json:"max_attempts"`
+}
+
 type Config struct {
 	Port int `yaml:"port" json:"port"`
+	// MetricsPort is the internal port serving /metrics, the metrics aren't served if it's not set.
+	MetricsPort int `yaml:"metrics_port" json:"metrics_port"`
 	// In milliseconds
-	ShutDownTimeout int       `yaml:"shutdown_timeout" json:"shutdown_timeout"`
-	Postgres        *Postgres `yaml:"postgres" json:"postgres"`
+	ShutDownTimeout int `yaml:"shutdown_timeout" json:"shutdown_timeout"`
+	// ShutDownDelay is the time between failing readiness and stopping the server, in milliseconds.
+	ShutDownDelay  int             `yaml:"shutdown_delay" json:"shutdown_delay"`
+	Postgres       *Postgres       `yaml:"postgres" json:"postgres"`
+	FX             *FX             `yaml:"fx" json:"fx"`
+	Scheduler      *Scheduler      `yaml:"scheduler" json:"scheduler"`
+	StandingOrders *StandingOrders `yaml:"standing_orders" json:"standing_orders"`
+	// SpendingLimits are the default limits of accounts by currency, e.g. {"USD": {"daily": 1000}}.
+	SpendingLimits map[string]*account.SpendingLimits `yaml:"spending_limits" json:"spending_limits"`
+	// Fees are the rules of the fees charged on payments, the first rule matching a payment applies.
+	Fees       fee.Schedule `yaml:"fees" json:"fees"`
+	Auth       *Auth        `yaml:"auth" json:"auth"`
+	RateLimits *RateLimits  `yaml:"rate_limits" json:"rate_limits"`
+	Tracing    *Tracing     `yaml:"tracing" json:"tracing"`
+	Outbox     *Outbox      `yaml:"outbox" json:"outbox"`
 }
 
 func Parse(filePath string) (*Config, error) {
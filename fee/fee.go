@@ -0,0 +1,74 @@
+package fee
+
+import (
+	"github.com/georgysavva/generic-wallet/money"
+	"github.com/pkg/errors"
+)
+
+// Rule defines the fee charged on transfers sent from accounts with the currency and the tier.
+// Empty Currency or Tier matches any.
+// The fee is Flat plus Rate of the amount, bounded by Min and Max if they are set,
+// it's charged in the currency of the source account on top of the amount.
+type Rule struct {
+	Currency string       `json:"currency"`
+	Tier     string       `json:"tier"`
+	Flat     money.Amount `json:"flat"`
+	// Rate is a fraction of the amount, e.g. 0.015 for 1.5%.
+	Rate money.Amount  `json:"rate"`
+	Min  *money.Amount `json:"min,omitempty"`
+	Max  *money.Amount `json:"max,omitempty"`
+}
+
+func (r *Rule) matches(currency, tier string) bool {
+	return (r.Currency == "" || r.Currency == currency) && (r.Tier == "" || r.Tier == tier)
+}
+
+// Fee returns the fee for the amount rounded half away from zero to the given number of decimal places.
+func (r *Rule) Fee(amount money.Amount, decimals int) (money.Amount, error) {
+	variable, err := amount.Convert(r.Rate, decimals)
+	if err != nil {
+		return money.Amount{}, err
+	}
+	fee := r.Flat.Add(variable)
+	if r.Min != nil && fee.Cmp(*r.Min) < 0 {
+		fee = *r.Min
+	}
+	if r.Max != nil && fee.Cmp(*r.Max) > 0 {
+		fee = *r.Max
+	}
+	return fee, nil
+}
+
+// Schedule is an ordered list of rules, the first rule matching a transfer applies.
+// Transfers matching no rule are free.
+type Schedule []*Rule
+
+// Fee returns the fee for the amount sent from an account with the currency and the tier.
+func (s Schedule) Fee(currency, tier string, amount money.Amount) (money.Amount, error) {
+	for _, rule := range s {
+		if !rule.matches(currency, tier) {
+			continue
+		}
+		decimals, ok := money.Precision(currency)
+		if !ok {
+			return money.Amount{}, errors.Errorf("currency %s isn't supported", currency)
+		}
+		return rule.Fee(amount, decimals)
+	}
+	return money.Amount{}, nil
+}
+
+// Validate returns an error if any rule has a negative amount or its min exceeds its max.
+func (s Schedule) Validate() error {
+	for i, rule := range s {
+		negative := rule.Flat.Sign() < 0 || rule.Rate.Sign() < 0 ||
+			rule.Min != nil && rule.Min.Sign() < 0 || rule.Max != nil && rule.Max.Sign() < 0
+		if negative {
+			return errors.Errorf("fee rule %d has a negative amount", i)
+		}
+		if rule.Min != nil && rule.Max != nil && rule.Min.Cmp(*rule.Max) > 0 {
+			return errors.Errorf("fee rule %d has min greater than max", i)
+		}
+	}
+	return nil
+}
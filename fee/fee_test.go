@@ -0,0 +1,47 @@
+package fee
+
+import (
+	"github.com/georgysavva/generic-wallet/money"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func amountPtr(s string) *money.Amount {
+	amount := money.MustParse(s)
+	return &amount
+}
+
+func TestScheduleFee(t *testing.T) {
+	schedule := Schedule{
+		{Tier: "premium"},
+		{Currency: "USD", Flat: money.MustParse("0.3"), Rate: money.MustParse("0.029")},
+		{Currency: "EUR", Rate: money.MustParse("0.01"), Min: amountPtr("1"), Max: amountPtr("5")},
+		{Currency: "JPY", Rate: money.MustParse("0.015")},
+	}
+	cases := []struct {
+		currency string
+		tier     string
+		amount   string
+		expected string
+	}{
+		{"USD", "premium", "100", "0"},
+		{"USD", "standard", "100", "3.2"},
+		{"USD", "standard", "10.55", "0.61"},
+		{"EUR", "standard", "50", "1"},
+		{"EUR", "standard", "250", "2.5"},
+		{"EUR", "standard", "1000", "5"},
+		{"JPY", "standard", "1234", "19"},
+		{"GBP", "standard", "100", "0"},
+	}
+	for _, c := range cases {
+		fee, err := schedule.Fee(c.currency, c.tier, money.MustParse(c.amount))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, money.MustParse(c.expected), fee, "%s %s %s", c.currency, c.tier, c.amount)
+	}
+}
+
+func TestScheduleValidate(t *testing.T) {
+	assert.Equal(t, nil, Schedule{{Min: amountPtr("1"), Max: amountPtr("1")}}.Validate())
+	assert.NotEqual(t, nil, Schedule{{Rate: money.MustParse("-0.01")}}.Validate())
+	assert.NotEqual(t, nil, Schedule{{Min: amountPtr("2"), Max: amountPtr("1")}}.Validate())
+}
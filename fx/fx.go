@@ -0,0 +1,106 @@
+package fx
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/georgysavva/generic-wallet/money"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var RateNotFoundErr = errors.New("exchange rate not found")
+
+// ProviderError means that the rates service failed or responded with a rate that can't be used,
+// e.g. a rate with more than money.RateScale decimal places.
+type ProviderError struct {
+	Err error
+}
+
+func (e *ProviderError) Error() string {
+	return e.Err.Error()
+}
+
+// Provider provides exchange rates between currencies.
+type Provider interface {
+	// Rate returns the amount of the to currency which one unit of the from currency costs.
+	// It returns RateNotFoundErr if the currency pair isn't supported and *ProviderError if the rate is unavailable.
+	Rate(ctx context.Context, fromCurrency, toCurrency string) (money.Rate, error)
+}
+
+// StaticProvider provides fixed exchange rates, e.g. loaded from a file.
+type StaticProvider struct {
+	rates map[string]money.Rate
+}
+
+// NewStaticProvider creates a provider from the rates keyed by currency pairs in "USD/EUR" format.
+func NewStaticProvider(rates map[string]money.Rate) *StaticProvider {
+	return &StaticProvider{rates: rates}
+}
+
+// LoadStaticProvider creates a provider from a JSON file with rates keyed by currency pairs,
+// e.g. {"USD/EUR": 0.9, "EUR/USD": 1.11}.
+func LoadStaticProvider(filePath string) (*StaticProvider, error) {
+	b, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	rates := map[string]money.Rate{}
+	err = json.Unmarshal(b, &rates)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't decode exchange rates file %s", filePath)
+	}
+	return NewStaticProvider(rates), nil
+}
+
+func (sp *StaticProvider) Rate(ctx context.Context, fromCurrency, toCurrency string) (money.Rate, error) {
+	rate, ok := sp.rates[fromCurrency+"/"+toCurrency]
+	if !ok {
+		return money.Rate{}, RateNotFoundErr
+	}
+	return rate, nil
+}
+
+// HTTPProvider fetches exchange rates from a rates service on every call.
+// The service is requested as GET <url>?from=USD&to=EUR and must respond with {"rate": 0.9}
+// or with 404 status if the currency pair isn't supported.
+type HTTPProvider struct {
+	url    string
+	client *http.Client
+}
+
+func NewHTTPProvider(ratesURL string, timeout time.Duration) *HTTPProvider {
+	return &HTTPProvider{url: ratesURL, client: &http.Client{Timeout: timeout}}
+}
+
+func (hp *HTTPProvider) Rate(ctx context.Context, fromCurrency, toCurrency string) (money.Rate, error) {
+	query := url.Values{"from": {fromCurrency}, "to": {toCurrency}}
+	req, err := http.NewRequest(http.MethodGet, hp.url+"?"+query.Encode(), nil)
+	if err != nil {
+		return money.Rate{}, err
+	}
+	resp, err := hp.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return money.Rate{}, &ProviderError{errors.Wrap(err, "exchange rate request failed")}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return money.Rate{}, RateNotFoundErr
+	}
+	if resp.StatusCode != http.StatusOK {
+		return money.Rate{}, &ProviderError{errors.Errorf("exchange rate request failed with status %d", resp.StatusCode)}
+	}
+	body := &struct {
+		Rate *money.Rate `json:"rate"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(body)
+	if err != nil {
+		return money.Rate{}, &ProviderError{errors.Wrap(err, "can't decode exchange rate response")}
+	}
+	if body.Rate == nil || body.Rate.Sign() <= 0 {
+		return money.Rate{}, &ProviderError{errors.New("exchange rate response doesn't contain a positive rate")}
+	}
+	return *body.Rate, nil
+}
@@ -0,0 +1,53 @@
+package fx
+
+import (
+	"context"
+	"fmt"
+	"github.com/georgysavva/generic-wallet/money"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStaticProvider(t *testing.T) {
+	p := NewStaticProvider(map[string]money.Rate{"USD/EUR": money.MustParseRate("0.9")})
+	ctx := context.Background()
+	rate, err := p.Rate(ctx, "USD", "EUR")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, money.MustParseRate("0.9"), rate)
+	_, err = p.Rate(ctx, "EUR", "USD")
+	assert.Equal(t, RateNotFoundErr, err)
+}
+
+func TestHTTPProvider(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.FormValue("from") + "/" + r.FormValue("to") {
+		case "USD/EUR":
+			fmt.Fprint(w, `{"rate": 0.912345678}`)
+		case "USD/JPY":
+			fmt.Fprint(w, `{"rate": 112.1234567890123}`)
+		case "USD/GBP":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer stub.Close()
+	p := NewHTTPProvider(stub.URL, time.Second)
+	ctx := context.Background()
+
+	rate, err := p.Rate(ctx, "USD", "EUR")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, money.MustParseRate("0.912345678"), rate)
+	_, err = p.Rate(ctx, "EUR", "JPY")
+	assert.Equal(t, RateNotFoundErr, err)
+	_, err = p.Rate(ctx, "USD", "GBP")
+	_, ok := err.(*ProviderError)
+	assert.Equal(t, true, ok, "ProviderError type assertion")
+	// The rate has more decimal places than a rate can be stored with.
+	_, err = p.Rate(ctx, "USD", "JPY")
+	_, ok = err.(*ProviderError)
+	assert.Equal(t, true, ok, "ProviderError type assertion")
+}
@@ -0,0 +1,115 @@
+// Package health serves liveness and readiness probes of the service.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	upStatus   = "up"
+	downStatus = "down"
+)
+
+// CheckFunc returns an error if the dependency isn't available.
+type CheckFunc func(ctx context.Context) error
+
+type check struct {
+	name  string
+	check CheckFunc
+}
+
+// Health tracks the readiness of the service to serve requests.
+type Health struct {
+	checks []*check
+	// timeout bounds every readiness probe, a dependency not responding in time is down.
+	// Zero timeout doesn't bound the probes.
+	timeout      time.Duration
+	shuttingDown int32
+}
+
+func New(timeout time.Duration) *Health {
+	return &Health{timeout: timeout}
+}
+
+// AddCheck adds a dependency the service isn't ready without, it must be called before serving the probes.
+func (h *Health) AddCheck(name string, checkFunc CheckFunc) {
+	h.checks = append(h.checks, &check{name: name, check: checkFunc})
+}
+
+// ShutDown makes the service not ready, so load balancers stop sending requests before the server stops.
+func (h *Health) ShutDown() {
+	atomic.StoreInt32(&h.shuttingDown, 1)
+}
+
+type status struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type report struct {
+	Status       string             `json:"status"`
+	ShuttingDown bool               `json:"shutting_down,omitempty"`
+	Dependencies map[string]*status `json:"dependencies,omitempty"`
+}
+
+func newStatus(err error) *status {
+	if err != nil {
+		return &status{Status: downStatus, Error: err.Error()}
+	}
+	return &status{Status: upStatus}
+}
+
+// LivenessHandler reports that the process is alive, it doesn't check the dependencies.
+func (h *Health) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, &report{Status: upStatus})
+	})
+}
+
+// ReadinessHandler runs all checks concurrently and reports the status of every dependency.
+// The service is ready if all dependencies are up and it isn't shutting down.
+func (h *Health) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if h.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, h.timeout)
+			defer cancel()
+		}
+		statuses := make([]*status, len(h.checks))
+		var wg sync.WaitGroup
+		for i, c := range h.checks {
+			wg.Add(1)
+			go func(i int, c *check) {
+				defer wg.Done()
+				statuses[i] = newStatus(c.check(ctx))
+			}(i, c)
+		}
+		wg.Wait()
+
+		rep := &report{Status: upStatus, Dependencies: map[string]*status{}}
+		for i, c := range h.checks {
+			rep.Dependencies[c.name] = statuses[i]
+			if statuses[i].Status != upStatus {
+				rep.Status = downStatus
+			}
+		}
+		if atomic.LoadInt32(&h.shuttingDown) == 1 {
+			rep.Status, rep.ShuttingDown = downStatus, true
+		}
+		writeReport(w, rep)
+	})
+}
+
+func writeReport(w http.ResponseWriter, rep *report) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if rep.Status != upStatus {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(rep)
+}
@@ -0,0 +1,43 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func probe(handler http.Handler) (int, *report) {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	rep := &report{}
+	json.NewDecoder(w.Body).Decode(rep)
+	return w.Code, rep
+}
+
+func TestHealth(t *testing.T) {
+	h := New(time.Second)
+	var postgresErr error
+	h.AddCheck("postgres", func(ctx context.Context) error { return postgresErr })
+
+	code, rep := probe(h.ReadinessHandler())
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, &report{Status: upStatus, Dependencies: map[string]*status{"postgres": {Status: upStatus}}}, rep)
+
+	postgresErr = errors.New("connection refused")
+	code, rep = probe(h.ReadinessHandler())
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, &status{Status: downStatus, Error: "connection refused"}, rep.Dependencies["postgres"])
+
+	postgresErr = nil
+	h.ShutDown()
+	code, rep = probe(h.ReadinessHandler())
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.True(t, rep.ShuttingDown)
+	code, _ = probe(h.LivenessHandler())
+	assert.Equal(t, http.StatusOK, code)
+}
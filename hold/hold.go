@@ -0,0 +1,73 @@
+package hold
+
+import (
+	"context"
+	"github.com/georgysavva/generic-wallet/account"
+	"github.com/georgysavva/generic-wallet/money"
+	"github.com/georgysavva/generic-wallet/payment"
+	"github.com/pkg/errors"
+	"time"
+)
+
+const (
+	// ActiveStatus holds reserve money of the source account until they expire.
+	ActiveStatus   = "active"
+	CapturedStatus = "captured"
+	VoidedStatus   = "voided"
+	// ExpiredStatus is reported for active holds past their expiration time, it's never stored.
+	ExpiredStatus = "expired"
+)
+
+// Hold reserves money of the source account for a payment to the destination account.
+// The reserved amount isn't available for other payments, but it stays in the account balance
+// until the hold is captured. Capture transfers the whole amount or its part and releases the rest.
+// The fee of the payment is fixed and reserved with the amount, so the capture can't fail for the lack of it.
+type Hold struct {
+	Id            string `json:"id"`
+	FromAccountId string `json:"from_account"`
+	ToAccountId   string `json:"to_account"`
+	// Amount is in the source account currency.
+	Amount money.Amount `json:"amount"`
+	// Fee is the max fee the capture charges on top of the captured amount.
+	Fee            money.Amount `json:"fee"`
+	CapturedAmount money.Amount `json:"captured_amount"`
+	// TransferId is set when the hold is captured.
+	TransferId string    `json:"transfer_id,omitempty"`
+	Status     string    `json:"status"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	CreatedAt  time.Time `json:"created_at"`
+	// SpendingLimits of the source account the hold is checked against, they aren't stored.
+	SpendingLimits *account.SpendingLimits `json:"-"`
+}
+
+// ReservedAmount returns the money of the source account the hold reserves while it's active.
+func (h *Hold) ReservedAmount() money.Amount {
+	return h.Amount.Add(h.Fee)
+}
+
+// IsActive returns true if the hold still reserves money at the moment.
+func (h *Hold) IsActive(now time.Time) bool {
+	return h.Status == ActiveStatus && now.Before(h.ExpiresAt)
+}
+
+var NotActiveErr = errors.New("hold has already been captured or voided")
+var ExpiredErr = errors.New("hold has expired")
+var CaptureExceedsAmountErr = errors.New("captured amount can't exceed the amount of the hold")
+
+type Repository interface {
+	// Create reserves the amount with the fee of the source account and fills in the creation time of the hold.
+	// It returns the errors of payment.CheckBalance if the account can't afford them
+	// and the error of payment.CheckFromAccountStatus if the account can't send payments.
+	// The amounts of the active holds count as spent, so it returns the errors of payment.CheckSpendingLimits
+	// if the amount together with the spent money and the held amounts exceeds the limits.
+	Create(ctx context.Context, hold *Hold) error
+	// Get returns nil if the hold doesn't exist.
+	Get(ctx context.Context, holdId string) (*Hold, error)
+	// Capture marks the hold captured and executes the transfer like payment.Repository.Save does
+	// in the same transaction.
+	// It returns NotActiveErr, ExpiredErr or CaptureExceedsAmountErr if the hold can't be captured for the amount.
+	Capture(ctx context.Context, holdId string, transfer *payment.Transfer) (*Hold, error)
+	// Void releases the reserved amount, it returns nil if the hold doesn't exist
+	// and NotActiveErr or ExpiredErr if it isn't active.
+	Void(ctx context.Context, holdId string) (*Hold, error)
+}
@@ -0,0 +1,37 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// Record is a stored outcome of a request sent with an idempotency key.
+type Record struct {
+	// Scope is the caller the key belongs to, keys of different callers don't collide.
+	Scope string
+	Key   string
+	// Fingerprint identifies the request parameters the key was first used with.
+	Fingerprint string
+	Response    json.RawMessage
+}
+
+// InternalKeyPrefix starts the keys of the requests the service makes itself, e.g. to send scheduled payments.
+// Clients can't use keys with it, so they can't take the key of an internal request before it's made.
+const InternalKeyPrefix = "internal:"
+
+// KeyAlreadyUsedErr is returned by repositories when a record with the same scope and key already exists.
+var KeyAlreadyUsedErr = errors.New("idempotency key has already been used")
+
+type Repository interface {
+	Get(ctx context.Context, scope, key string) (*Record, error)
+}
+
+// Fingerprint returns a digest of the request parameters.
+func Fingerprint(params ...string) string {
+	h := sha256.Sum256([]byte(strings.Join(params, "\x00")))
+	return hex.EncodeToString(h[:])
+}
@@ -2,29 +2,107 @@ package inmem_repository
 
 import (
 	"context"
+	"encoding/json"
 	"github.com/georgysavva/generic-wallet/account"
+	"github.com/georgysavva/generic-wallet/hold"
+	"github.com/georgysavva/generic-wallet/idempotency"
+	"github.com/georgysavva/generic-wallet/ledger"
+	"github.com/georgysavva/generic-wallet/money"
+	"github.com/georgysavva/generic-wallet/outbox"
 	"github.com/georgysavva/generic-wallet/payment"
+	"github.com/georgysavva/generic-wallet/schedule"
+	"github.com/georgysavva/generic-wallet/standing"
 	"github.com/pkg/errors"
 	"sort"
+	"time"
 )
 
-func InstantiateRepositories(accounts []*account.Account, payments []*payment.Payment) (*AccountsRepository, *PaymentsRepository) {
-	accountsRepo := &AccountsRepository{accounts: map[string]*account.Account{}}
+// openingTransactionId is the id of the ledger transaction funding the initial balances of the accounts.
+const openingTransactionId = "opening_balances"
+
+type Repositories struct {
+	Accounts    *AccountsRepository
+	Payments    *PaymentsRepository
+	Idempotency *IdempotencyRepository
+	Ledger      *LedgerRepository
+	Holds       *HoldsRepository
+	Scheduled   *ScheduledPaymentsRepository
+	Standing    *StandingOrdersRepository
+	Outbox      *OutboxRepository
+}
+
+func InstantiateRepositories(accounts []*account.Account, payments []*payment.Payment) *Repositories {
+	holdsRepo := &HoldsRepository{holds: map[string]*hold.Hold{}}
+	accountsRepo := &AccountsRepository{accounts: map[string]*account.Account{}, holdsRepo: holdsRepo}
 	for _, a := range accounts {
 		accountsRepo.accounts[a.Id] = a
 	}
-	paymentsRepo := &PaymentsRepository{accountsRepo: accountsRepo}
+	idempotencyRepo := &IdempotencyRepository{records: map[idempotencyRecordKey]*idempotency.Record{}}
+	ledgerRepo := &LedgerRepository{accountsRepo: accountsRepo, transactions: map[string]*ledger.Transaction{}}
+	outboxRepo := &OutboxRepository{claimedAt: map[int64]time.Time{}}
+	paymentsRepo := &PaymentsRepository{
+		transfers:       map[string]*payment.Transfer{},
+		accountsRepo:    accountsRepo,
+		idempotencyRepo: idempotencyRepo,
+		ledgerRepo:      ledgerRepo,
+		outboxRepo:      outboxRepo,
+	}
+	holdsRepo.paymentsRepo = paymentsRepo
 	for _, p := range payments {
 		paymentsRepo.payments = append(paymentsRepo.payments, p)
+		if p.Id > ledgerRepo.lastPostingId {
+			ledgerRepo.lastPostingId = p.Id
+		}
+	}
+	paymentsRepo.indexInitialTransfers()
+	if openingTransaction := ledger.NewOpeningTransaction(openingTransactionId, accounts); openingTransaction != nil {
+		ledgerRepo.save(openingTransaction, time.Now())
+	}
+	return &Repositories{
+		Accounts:    accountsRepo,
+		Payments:    paymentsRepo,
+		Idempotency: idempotencyRepo,
+		Ledger:      ledgerRepo,
+		Holds:       holdsRepo,
+		Scheduled: &ScheduledPaymentsRepository{
+			payments: map[string]*schedule.Payment{}, claimedAt: map[string]time.Time{},
+		},
+		Standing: &StandingOrdersRepository{
+			orders: map[string]*standing.Order{}, claimedAt: map[string]time.Time{},
+		},
+		Outbox: outboxRepo,
 	}
-	return accountsRepo, paymentsRepo
 }
 
 type AccountsRepository struct {
-	accounts map[string]*account.Account
+	accounts  map[string]*account.Account
+	holdsRepo *HoldsRepository
 }
 
 func (ar *AccountsRepository) GetAll(ctx context.Context, offset, limit *int) ([]*account.Account, error) {
+	accountsList := ar.sortedAccounts()
+	start, end := paginate(len(accountsList), offset, limit)
+	return accountsList[start:end], nil
+}
+
+func (ar *AccountsRepository) GetAllAfter(ctx context.Context, afterId string, limit int) ([]*account.Account, error) {
+	accountsList := ar.sortedAccounts()
+	offset := sort.Search(len(accountsList), func(i int) bool {
+		return accountsList[i].Id > afterId
+	})
+	start, end := paginate(len(accountsList), &offset, &limit)
+	return accountsList[start:end], nil
+}
+
+func (ar *AccountsRepository) GetAllBefore(ctx context.Context, beforeId string, limit int) ([]*account.Account, error) {
+	accountsList := ar.sortedAccounts()
+	end := sort.Search(len(accountsList), func(i int) bool {
+		return accountsList[i].Id >= beforeId
+	})
+	return accountsList[pageStartBefore(end, limit):end], nil
+}
+
+func (ar *AccountsRepository) sortedAccounts() []*account.Account {
 	var accountsList []*account.Account
 	for _, accountRecord := range ar.accounts {
 		accountsList = append(accountsList, accountRecord)
@@ -32,7 +110,15 @@ func (ar *AccountsRepository) GetAll(ctx context.Context, offset, limit *int) ([
 	sort.Slice(accountsList, func(i, j int) bool {
 		return accountsList[i].Id < accountsList[j].Id
 	})
-	return accountsList, nil
+	for _, accountRecord := range accountsList {
+		ar.refreshAvailableBalance(accountRecord)
+	}
+	return accountsList
+}
+
+// refreshAvailableBalance sets the available balance of the account as of now, since holds expire with time.
+func (ar *AccountsRepository) refreshAvailableBalance(accountRecord *account.Account) {
+	accountRecord.AvailableBalance = accountRecord.Balance.Sub(ar.holdsRepo.reservedAmount(accountRecord.Id))
 }
 
 func (ar *AccountsRepository) CountAll(ctx context.Context) (int, error) {
@@ -40,48 +126,911 @@ func (ar *AccountsRepository) CountAll(ctx context.Context) (int, error) {
 }
 
 func (ar *AccountsRepository) Get(ctx context.Context, accountId string) (*account.Account, error) {
-	accountRecords := ar.accounts[accountId]
-	return accountRecords, nil
+	accountRecord := ar.accounts[accountId]
+	if accountRecord != nil {
+		ar.refreshAvailableBalance(accountRecord)
+	}
+	return accountRecord, nil
+}
+
+func (ar *AccountsRepository) Create(ctx context.Context, record *account.Account) error {
+	if ar.accounts[record.Id] != nil {
+		return account.AlreadyExistsErr
+	}
+	ar.accounts[record.Id] = record
+	return nil
+}
+
+func (ar *AccountsRepository) SetStatus(
+	ctx context.Context, accountId, status, reason string,
+) (*account.Account, error) {
+	accountRecord := ar.accounts[accountId]
+	if accountRecord == nil {
+		return nil, nil
+	}
+	if accountRecord.Status == account.ClosedStatus {
+		return nil, account.ClosedErr
+	}
+	accountRecord.Status, accountRecord.StatusReason = status, reason
+	return accountRecord, nil
+}
+
+func (ar *AccountsRepository) SetOverdraftLimit(
+	ctx context.Context, accountId string, limit money.Amount,
+) (*account.Account, error) {
+	accountRecord := ar.accounts[accountId]
+	if accountRecord == nil {
+		return nil, nil
+	}
+	if accountRecord.Status == account.ClosedStatus {
+		return nil, account.ClosedErr
+	}
+	accountRecord.OverdraftLimit = limit
+	ar.refreshAvailableBalance(accountRecord)
+	return accountRecord, nil
+}
+
+func (ar *AccountsRepository) SetSpendingLimits(
+	ctx context.Context, accountId string, limits account.SpendingLimits,
+) (*account.Account, error) {
+	accountRecord := ar.accounts[accountId]
+	if accountRecord == nil {
+		return nil, nil
+	}
+	if accountRecord.Status == account.ClosedStatus {
+		return nil, account.ClosedErr
+	}
+	accountRecord.SpendingLimits = limits
+	return accountRecord, nil
+}
+
+func (ar *AccountsRepository) SetTier(ctx context.Context, accountId, tier string) (*account.Account, error) {
+	accountRecord := ar.accounts[accountId]
+	if accountRecord == nil {
+		return nil, nil
+	}
+	if accountRecord.Status == account.ClosedStatus {
+		return nil, account.ClosedErr
+	}
+	accountRecord.Tier = tier
+	return accountRecord, nil
+}
+
+func (ar *AccountsRepository) Close(ctx context.Context, accountId string) (*account.Account, error) {
+	accountRecord := ar.accounts[accountId]
+	if accountRecord == nil || accountRecord.Status == account.ClosedStatus {
+		return accountRecord, nil
+	}
+	if !accountRecord.Balance.IsZero() {
+		return nil, account.NonZeroBalanceErr
+	}
+	accountRecord.Status = account.ClosedStatus
+	return accountRecord, nil
 }
 
 type PaymentsRepository struct {
-	payments     []*payment.Payment
-	accountsRepo *AccountsRepository
+	payments []*payment.Payment
+	// transfers contain both the saved and the initial payments grouped by their transfer ids.
+	transfers       map[string]*payment.Transfer
+	accountsRepo    *AccountsRepository
+	idempotencyRepo *IdempotencyRepository
+	ledgerRepo      *LedgerRepository
+	outboxRepo      *OutboxRepository
 }
 
-func (pr *PaymentsRepository) GetAll(ctx context.Context, offset, limit *int) ([]*payment.Payment, error) {
-	return pr.payments, nil
+func (pr *PaymentsRepository) GetAll(
+	ctx context.Context, filter payment.Filter, order payment.Sort, offset, limit *int,
+) ([]*payment.Payment, error) {
+	paymentsList := pr.find(filter, order)
+	start, end := paginate(len(paymentsList), offset, limit)
+	return paymentsList[start:end], nil
 }
 
-func (pr *PaymentsRepository) CountAll(ctx context.Context) (int, error) {
-	return len(pr.payments), nil
+func (pr *PaymentsRepository) CountAll(ctx context.Context, filter payment.Filter) (int, error) {
+	return len(pr.find(filter, payment.Sort{})), nil
+}
+
+func (pr *PaymentsRepository) GetAllAfter(
+	ctx context.Context, filter payment.Filter, order payment.Sort, after *payment.Payment, limit int,
+) ([]*payment.Payment, error) {
+	paymentsList := pr.find(filter, order)
+	offset := sort.Search(len(paymentsList), func(i int) bool {
+		return comparePayments(paymentsList[i], after, order) > 0
+	})
+	start, end := paginate(len(paymentsList), &offset, &limit)
+	return paymentsList[start:end], nil
 }
 
-func (pr *PaymentsRepository) Save(ctx context.Context, fromAccountId, toAccountId string, amount float64) error {
-	fromAccount := pr.accountsRepo.accounts[fromAccountId]
+func (pr *PaymentsRepository) GetAllBefore(
+	ctx context.Context, filter payment.Filter, order payment.Sort, before *payment.Payment, limit int,
+) ([]*payment.Payment, error) {
+	paymentsList := pr.find(filter, order)
+	end := sort.Search(len(paymentsList), func(i int) bool {
+		return comparePayments(paymentsList[i], before, order) >= 0
+	})
+	return paymentsList[pageStartBefore(end, limit):end], nil
+}
+
+// find returns payments matching the filter in the sort order.
+func (pr *PaymentsRepository) find(filter payment.Filter, order payment.Sort) []*payment.Payment {
+	var paymentsList []*payment.Payment
+	for _, p := range pr.payments {
+		if matchesFilter(p, filter) {
+			paymentsList = append(paymentsList, p)
+		}
+	}
+	sort.Slice(paymentsList, func(i, j int) bool {
+		return comparePayments(paymentsList[i], paymentsList[j], order) < 0
+	})
+	return paymentsList
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesFilter(p *payment.Payment, filter payment.Filter) bool {
+	switch {
+	case filter.AccountId != "" && p.AccountId != filter.AccountId:
+		return false
+	case len(filter.AccountIds) != 0 && !containsString(filter.AccountIds, p.AccountId):
+		return false
+	case filter.CounterpartyId != "" && p.ToAccountId != filter.CounterpartyId && p.FromAccountId != filter.CounterpartyId:
+		return false
+	case filter.Direction != "" && p.Direction != filter.Direction:
+		return false
+	case filter.MinAmount != nil && p.Amount.Cmp(*filter.MinAmount) < 0:
+		return false
+	case filter.MaxAmount != nil && p.Amount.Cmp(*filter.MaxAmount) > 0:
+		return false
+	case filter.CreatedFrom != nil && p.CreatedAt.Before(*filter.CreatedFrom):
+		return false
+	case filter.CreatedTo != nil && !p.CreatedAt.Before(*filter.CreatedTo):
+		return false
+	default:
+		return true
+	}
+}
+
+// comparePayments compares positions of the payments in the sort order.
+func comparePayments(a, b *payment.Payment, order payment.Sort) int {
+	result := 0
+	switch order.By {
+	case payment.SortByTime:
+		if a.CreatedAt.Before(b.CreatedAt) {
+			result = -1
+		} else if a.CreatedAt.After(b.CreatedAt) {
+			result = 1
+		}
+	case payment.SortByAmount:
+		result = a.Amount.Cmp(b.Amount)
+	}
+	if result == 0 && a.Id != b.Id {
+		result = 1
+		if a.Id < b.Id {
+			result = -1
+		}
+	}
+	if order.Descending {
+		result = -result
+	}
+	return result
+}
+
+func (pr *PaymentsRepository) Get(ctx context.Context, paymentId int64) (*payment.Payment, error) {
+	for _, p := range pr.payments {
+		if p.Id == paymentId {
+			return p, nil
+		}
+	}
+	return nil, nil
+}
+
+func (pr *PaymentsRepository) Save(
+	ctx context.Context, transfer *payment.Transfer, idempotencyRecord *idempotency.Record,
+) error {
+	var recordKey idempotencyRecordKey
+	if idempotencyRecord != nil {
+		recordKey = idempotencyRecordKey{scope: idempotencyRecord.Scope, key: idempotencyRecord.Key}
+		if pr.idempotencyRepo.records[recordKey] != nil {
+			return idempotency.KeyAlreadyUsedErr
+		}
+	}
+	err := pr.saveTransfer(transfer)
+	if err != nil {
+		return err
+	}
+	if idempotencyRecord != nil {
+		response, err := json.Marshal(transfer)
+		if err != nil {
+			return err
+		}
+		idempotencyRecord.Response = response
+		pr.idempotencyRepo.records[recordKey] = idempotencyRecord
+	}
+	return nil
+}
+
+// indexInitialTransfers restores the transfers of the initial payments from their transfer ids,
+// so they can be fetched and refunded like the saved ones, same as in postgres.
+func (pr *PaymentsRepository) indexInitialTransfers() {
+	for _, p := range pr.payments {
+		if p.TransferId == "" {
+			continue
+		}
+		transfer := pr.transfers[p.TransferId]
+		if transfer == nil {
+			transfer = &payment.Transfer{
+				Id:                 p.TransferId,
+				Amount:             p.SourceAmount,
+				DestinationAmount:  p.DestinationAmount,
+				ExchangeRate:       p.ExchangeRate,
+				RefundedTransferId: p.RefundedTransferId,
+				RefundedAmount:     p.RefundedAmount,
+				CreatedAt:          p.CreatedAt,
+			}
+			pr.transfers[p.TransferId] = transfer
+		}
+		transfer.Payments = append(transfer.Payments, p)
+		accountRecord := pr.accountsRepo.accounts[p.AccountId]
+		if p.Direction == payment.OutgoingDirection {
+			transfer.FromAccountId, transfer.ToAccountId, transfer.Fee = p.AccountId, p.ToAccountId, p.Fee
+			if accountRecord != nil {
+				transfer.Currency = accountRecord.Currency
+			}
+		} else {
+			transfer.FromAccountId, transfer.ToAccountId = p.FromAccountId, p.AccountId
+			if accountRecord != nil {
+				transfer.DestinationCurrency = accountRecord.Currency
+			}
+		}
+	}
+	// The refund goes backwards, so its amount refunds the destination amount of the refunded transfer.
+	for _, transfer := range pr.transfers {
+		if refundedTransfer := pr.transfers[transfer.RefundedTransferId]; refundedTransfer != nil {
+			refundedTransfer.RefundedDestinationAmount = refundedTransfer.RefundedDestinationAmount.Add(transfer.Amount)
+		}
+	}
+}
+
+func (pr *PaymentsRepository) GetTransfer(ctx context.Context, transferId string) (*payment.Transfer, error) {
+	return pr.transfers[transferId], nil
+}
+
+func (pr *PaymentsRepository) SaveRefund(ctx context.Context, refund *payment.Transfer) error {
+	refundedTransfer := pr.transfers[refund.RefundedTransferId]
+	if refundedTransfer == nil || refundedTransfer.RefundedTransferId != "" {
+		return errors.New("refunded transfer not found")
+	}
+	// The refund goes backwards, so its destination amount refunds the source amount of the transfer.
+	refundedAmount := refundedTransfer.RefundedAmount.Add(refund.DestinationAmount)
+	refundedDestinationAmount := refundedTransfer.RefundedDestinationAmount.Add(refund.Amount)
+	if refundedAmount.Cmp(refundedTransfer.Amount) > 0 ||
+		refundedDestinationAmount.Cmp(refundedTransfer.DestinationAmount) > 0 {
+		return payment.RefundExceedsAmountErr
+	}
+	err := pr.saveTransfer(refund)
+	if err != nil {
+		return err
+	}
+	refundedTransfer.RefundedAmount = refundedAmount
+	refundedTransfer.RefundedDestinationAmount = refundedDestinationAmount
+	for _, p := range refundedTransfer.Payments {
+		p.RefundedAmount = refundedAmount
+	}
+	return nil
+}
+
+func (pr *PaymentsRepository) SaveBatch(ctx context.Context, transfers []*payment.Transfer) error {
+	// The transfers are checked against the balances they would leave before any of them is saved,
+	// which makes the batch atomic.
+	availableBalances := map[string]money.Amount{}
+	for _, accountRecord := range pr.accountsRepo.accounts {
+		pr.accountsRepo.refreshAvailableBalance(accountRecord)
+		availableBalances[accountRecord.Id] = accountRecord.AvailableBalance
+	}
+	batchSpent := map[string]money.Amount{}
+	for i, transfer := range transfers {
+		fromAccount := pr.accountsRepo.accounts[transfer.FromAccountId]
+		toAccount := pr.accountsRepo.accounts[transfer.ToAccountId]
+		if fromAccount == nil || toAccount == nil {
+			return errors.New("account not found")
+		}
+		err := payment.CheckFromAccountStatus(fromAccount.Status)
+		if err == nil {
+			err = payment.CheckToAccountStatus(toAccount.Status)
+		}
+		if err == nil {
+			err = payment.CheckBalance(
+				availableBalances[fromAccount.Id], fromAccount.OverdraftLimit, transfer.Amount.Add(transfer.Fee),
+			)
+		}
+		if err == nil {
+			spentDaily, spentMonthly := pr.spentAmounts(fromAccount.Id)
+			spentInBatch := batchSpent[fromAccount.Id]
+			err = payment.CheckSpendingLimits(
+				transfer.SpendingLimits, transfer.Amount, spentDaily.Add(spentInBatch), spentMonthly.Add(spentInBatch),
+			)
+		}
+		if err != nil {
+			return &payment.BatchItemError{Index: i, Err: err}
+		}
+		batchSpent[fromAccount.Id] = batchSpent[fromAccount.Id].Add(transfer.Amount)
+		availableBalances[fromAccount.Id] = availableBalances[fromAccount.Id].Sub(transfer.Amount.Add(transfer.Fee))
+		availableBalances[toAccount.Id] = availableBalances[toAccount.Id].Add(transfer.DestinationAmount)
+	}
+	for _, transfer := range transfers {
+		err := pr.saveTransfer(transfer)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// spentAmounts returns the money the account has sent within the daily and the monthly limit windows,
+// refunds aren't counted. The captures of the active holds will be spent, so they count in both windows already.
+func (pr *PaymentsRepository) spentAmounts(accountId string) (money.Amount, money.Amount) {
+	held := pr.accountsRepo.holdsRepo.heldAmount(accountId)
+	spentDaily, spentMonthly := held, held
+	now := time.Now()
+	for _, transfer := range pr.transfers {
+		if transfer.FromAccountId != accountId || transfer.RefundedTransferId != "" {
+			continue
+		}
+		if transfer.CreatedAt.After(now.Add(-account.DailyLimitWindow)) {
+			spentDaily = spentDaily.Add(transfer.Amount)
+		}
+		if transfer.CreatedAt.After(now.Add(-account.MonthlyLimitWindow)) {
+			spentMonthly = spentMonthly.Add(transfer.Amount)
+		}
+	}
+	return spentDaily, spentMonthly
+}
+
+// saveTransfer records the transfer with its ledger transaction, updates the account balances
+// and fills in the creation time and the payments of the transfer.
+func (pr *PaymentsRepository) saveTransfer(transfer *payment.Transfer) error {
+	fromAccount := pr.accountsRepo.accounts[transfer.FromAccountId]
 	if fromAccount == nil {
 		return errors.New("source account not found")
 	}
-	toAccount := pr.accountsRepo.accounts[toAccountId]
+	toAccount := pr.accountsRepo.accounts[transfer.ToAccountId]
 	if toAccount == nil {
 		return errors.New("destination account not found")
 	}
-	if fromAccount.Balance-amount < 0 {
-		return payment.LowBalanceErr
+	err := payment.CheckFromAccountStatus(fromAccount.Status)
+	if err != nil {
+		return err
+	}
+	err = payment.CheckToAccountStatus(toAccount.Status)
+	if err != nil {
+		return err
+	}
+	pr.accountsRepo.refreshAvailableBalance(fromAccount)
+	err = payment.CheckBalance(
+		fromAccount.AvailableBalance, fromAccount.OverdraftLimit, transfer.Amount.Add(transfer.Fee),
+	)
+	if err != nil {
+		return err
 	}
-	pr.payments = append(pr.payments, &payment.Payment{
-		AccountId:   fromAccount.Id,
-		ToAccountId: toAccount.Id,
-		Amount:      amount,
-		Direction:   payment.OutgoingDirection,
+	spentDaily, spentMonthly := pr.spentAmounts(fromAccount.Id)
+	err = payment.CheckSpendingLimits(transfer.SpendingLimits, transfer.Amount, spentDaily, spentMonthly)
+	if err != nil {
+		return err
+	}
+	ledgerTransaction := ledger.NewTransferTransaction(transfer)
+	err = ledgerTransaction.Validate()
+	if err != nil {
+		return err
+	}
+	pr.ledgerRepo.save(ledgerTransaction, time.Now())
+	for _, posting := range ledgerTransaction.Postings {
+		if accountRecord := pr.accountsRepo.accounts[posting.AccountId]; accountRecord != nil {
+			accountRecord.Balance = accountRecord.Balance.Add(posting.Amount)
+		}
+	}
+	transfer.CreatedAt = ledgerTransaction.CreatedAt
+	transfer.Payments = transferPayments(transfer, ledgerTransaction)
+	pr.payments = append(pr.payments, transfer.Payments...)
+	pr.transfers[transfer.Id] = transfer
+	event, err := outbox.NewPaymentCompletedEvent(transfer)
+	if err != nil {
+		return err
+	}
+	pr.outboxRepo.save(event)
+	return nil
+}
+
+// transferPayments returns payments of the transfer from its ledger transaction,
+// same as the payments view in postgres does.
+func transferPayments(transfer *payment.Transfer, transaction *ledger.Transaction) []*payment.Payment {
+	var payments []*payment.Payment
+	for _, posting := range transaction.Postings {
+		if ledger.IsSystemAccount(posting.AccountId) {
+			continue
+		}
+		p := &payment.Payment{
+			Id:                 posting.Id,
+			TransferId:         transaction.Id,
+			AccountId:          posting.AccountId,
+			SourceAmount:       transfer.Amount,
+			DestinationAmount:  transfer.DestinationAmount,
+			ExchangeRate:       transfer.ExchangeRate,
+			RefundedTransferId: transfer.RefundedTransferId,
+			RefundedAmount:     transfer.RefundedAmount,
+			CreatedAt:          posting.CreatedAt,
+		}
+		if posting.Amount.Sign() < 0 {
+			// The source account is debited with the fee in the same posting.
+			p.ToAccountId, p.Amount, p.Fee = transfer.ToAccountId, posting.Amount.Neg().Sub(transfer.Fee), transfer.Fee
+			p.Direction = payment.OutgoingDirection
+		} else {
+			p.FromAccountId, p.Amount, p.Direction = transfer.FromAccountId, posting.Amount, payment.IncomingDirection
+		}
+		payments = append(payments, p)
+	}
+	return payments
+}
+
+type LedgerRepository struct {
+	transactions  map[string]*ledger.Transaction
+	postings      []*ledger.Posting
+	lastPostingId int64
+	accountsRepo  *AccountsRepository
+}
+
+func (lr *LedgerRepository) GetTransaction(ctx context.Context, transactionId string) (*ledger.Transaction, error) {
+	return lr.transactions[transactionId], nil
+}
+
+func (lr *LedgerRepository) GetDiscrepancies(ctx context.Context) ([]*ledger.Discrepancy, error) {
+	ledgerBalances := map[string]money.Amount{}
+	for _, posting := range lr.postings {
+		ledgerBalances[posting.AccountId] = ledgerBalances[posting.AccountId].Add(posting.Amount)
+	}
+	var discrepancies []*ledger.Discrepancy
+	for _, accountRecord := range lr.accountsRepo.sortedAccounts() {
+		if accountRecord.Balance.Cmp(ledgerBalances[accountRecord.Id]) != 0 {
+			discrepancies = append(discrepancies, &ledger.Discrepancy{
+				AccountId: accountRecord.Id, Balance: accountRecord.Balance, LedgerBalance: ledgerBalances[accountRecord.Id],
+			})
+		}
+	}
+	return discrepancies, nil
+}
+
+// save stores the transaction and fills in ids and creation time of its postings.
+func (lr *LedgerRepository) save(transaction *ledger.Transaction, createdAt time.Time) {
+	transaction.CreatedAt = createdAt
+	for _, posting := range transaction.Postings {
+		lr.lastPostingId++
+		posting.Id = lr.lastPostingId
+		posting.CreatedAt = createdAt
+	}
+	lr.transactions[transaction.Id] = transaction
+	lr.postings = append(lr.postings, transaction.Postings...)
+}
+
+type HoldsRepository struct {
+	holds        map[string]*hold.Hold
+	paymentsRepo *PaymentsRepository
+}
+
+func (hr *HoldsRepository) Create(ctx context.Context, record *hold.Hold) error {
+	fromAccount := hr.paymentsRepo.accountsRepo.accounts[record.FromAccountId]
+	if fromAccount == nil {
+		return errors.New("source account not found")
+	}
+	err := payment.CheckFromAccountStatus(fromAccount.Status)
+	if err != nil {
+		return err
+	}
+	availableBalance := fromAccount.Balance.Sub(hr.reservedAmount(fromAccount.Id))
+	err = payment.CheckBalance(availableBalance, fromAccount.OverdraftLimit, record.ReservedAmount())
+	if err != nil {
+		return err
+	}
+	spentDaily, spentMonthly := hr.paymentsRepo.spentAmounts(fromAccount.Id)
+	err = payment.CheckSpendingLimits(record.SpendingLimits, record.Amount, spentDaily, spentMonthly)
+	if err != nil {
+		return err
+	}
+	record.CreatedAt = time.Now()
+	hr.holds[record.Id] = record
+	return nil
+}
+
+func (hr *HoldsRepository) Get(ctx context.Context, holdId string) (*hold.Hold, error) {
+	record := hr.holds[holdId]
+	if record == nil {
+		return nil, nil
+	}
+	return withCurrentStatus(record), nil
+}
+
+func (hr *HoldsRepository) Capture(ctx context.Context, holdId string, transfer *payment.Transfer) (*hold.Hold, error) {
+	record := hr.holds[holdId]
+	if record == nil {
+		return nil, nil
+	}
+	err := checkHoldActive(record)
+	if err != nil {
+		return nil, err
+	}
+	if transfer.Amount.Cmp(record.Amount) > 0 {
+		return nil, hold.CaptureExceedsAmountErr
+	}
+	// The hold stops reserving money before the transfer checks the available balance,
+	// so the transfer can spend the money the hold has reserved.
+	record.Status = hold.CapturedStatus
+	err = hr.paymentsRepo.saveTransfer(transfer)
+	if err != nil {
+		record.Status = hold.ActiveStatus
+		return nil, err
+	}
+	record.CapturedAmount, record.TransferId = transfer.Amount, transfer.Id
+	return record, nil
+}
+
+func (hr *HoldsRepository) Void(ctx context.Context, holdId string) (*hold.Hold, error) {
+	record := hr.holds[holdId]
+	if record == nil {
+		return nil, nil
+	}
+	err := checkHoldActive(record)
+	if err != nil {
+		return nil, err
+	}
+	record.Status = hold.VoidedStatus
+	return record, nil
+}
+
+// heldAmount returns the total amount of the active holds of the account without their fees.
+func (hr *HoldsRepository) heldAmount(accountId string) money.Amount {
+	var held money.Amount
+	now := time.Now()
+	for _, h := range hr.holds {
+		if h.FromAccountId == accountId && h.IsActive(now) {
+			held = held.Add(h.Amount)
+		}
+	}
+	return held
+}
+
+// reservedAmount returns the total amount of the active holds of the account with their fees.
+func (hr *HoldsRepository) reservedAmount(accountId string) money.Amount {
+	var reserved money.Amount
+	now := time.Now()
+	for _, h := range hr.holds {
+		if h.FromAccountId == accountId && h.IsActive(now) {
+			reserved = reserved.Add(h.ReservedAmount())
+		}
+	}
+	return reserved
+}
+
+func checkHoldActive(record *hold.Hold) error {
+	switch withCurrentStatus(record).Status {
+	case hold.ActiveStatus:
+		return nil
+	case hold.ExpiredStatus:
+		return hold.ExpiredErr
+	default:
+		return hold.NotActiveErr
+	}
+}
+
+// withCurrentStatus returns a copy of the hold reported as expired if it's active past its expiration time,
+// same as postgres does.
+func withCurrentStatus(record *hold.Hold) *hold.Hold {
+	recordCopy := *record
+	if recordCopy.Status == hold.ActiveStatus && !recordCopy.IsActive(time.Now()) {
+		recordCopy.Status = hold.ExpiredStatus
+	}
+	return &recordCopy
+}
+
+type ScheduledPaymentsRepository struct {
+	payments  map[string]*schedule.Payment
+	claimedAt map[string]time.Time
+}
+
+func (sr *ScheduledPaymentsRepository) Create(ctx context.Context, record *schedule.Payment) error {
+	record.CreatedAt = time.Now()
+	sr.payments[record.Id] = record
+	return nil
+}
+
+func (sr *ScheduledPaymentsRepository) Get(ctx context.Context, paymentId string) (*schedule.Payment, error) {
+	return sr.payments[paymentId], nil
+}
+
+func (sr *ScheduledPaymentsRepository) GetAll(
+	ctx context.Context, accountId string, offset, limit *int,
+) ([]*schedule.Payment, error) {
+	paymentsList := sr.accountPayments(accountId)
+	start, end := paginate(len(paymentsList), offset, limit)
+	return paymentsList[start:end], nil
+}
+
+func (sr *ScheduledPaymentsRepository) CountAll(ctx context.Context, accountId string) (int, error) {
+	return len(sr.accountPayments(accountId)), nil
+}
+
+// accountPayments returns payments from or to the account ordered by the execution time.
+func (sr *ScheduledPaymentsRepository) accountPayments(accountId string) []*schedule.Payment {
+	var paymentsList []*schedule.Payment
+	for _, p := range sr.payments {
+		if accountId == "" || p.FromAccountId == accountId || p.ToAccountId == accountId {
+			paymentsList = append(paymentsList, p)
+		}
+	}
+	sort.Slice(paymentsList, func(i, j int) bool {
+		if paymentsList[i].ExecuteAt.Equal(paymentsList[j].ExecuteAt) {
+			return paymentsList[i].Id < paymentsList[j].Id
+		}
+		return paymentsList[i].ExecuteAt.Before(paymentsList[j].ExecuteAt)
 	})
-	pr.payments = append(pr.payments, &payment.Payment{
-		AccountId:     toAccount.Id,
-		FromAccountId: fromAccount.Id,
-		Amount:        amount,
-		Direction:     payment.IncomingDirection,
+	return paymentsList
+}
+
+func (sr *ScheduledPaymentsRepository) Cancel(ctx context.Context, paymentId string) (*schedule.Payment, error) {
+	record := sr.payments[paymentId]
+	if record == nil {
+		return nil, nil
+	}
+	if record.Status != schedule.PendingStatus {
+		return nil, schedule.NotPendingErr
+	}
+	record.Status = schedule.CanceledStatus
+	return record, nil
+}
+
+func (sr *ScheduledPaymentsRepository) ClaimDue(
+	ctx context.Context, limit int, claimTimeout time.Duration,
+) ([]*schedule.Payment, error) {
+	now := time.Now()
+	var claimed []*schedule.Payment
+	for _, p := range sr.accountPayments("") {
+		if len(claimed) == limit {
+			break
+		}
+		if p.ExecuteAt.After(now) {
+			continue
+		}
+		if p.Status == schedule.PendingStatus ||
+			p.Status == schedule.ExecutingStatus && !sr.claimedAt[p.Id].After(now.Add(-claimTimeout)) {
+			p.Status = schedule.ExecutingStatus
+			sr.claimedAt[p.Id] = now
+			// Workers get copies, same as from postgres, so their changes are stored only by Finish.
+			claimedCopy := *p
+			claimed = append(claimed, &claimedCopy)
+		}
+	}
+	return claimed, nil
+}
+
+func (sr *ScheduledPaymentsRepository) Finish(ctx context.Context, record *schedule.Payment) error {
+	stored := sr.payments[record.Id]
+	if stored == nil || stored.Status != schedule.ExecutingStatus {
+		return schedule.AlreadyFinishedErr
+	}
+	executedAt := time.Now()
+	record.ExecutedAt = &executedAt
+	stored.Status, stored.TransferId, stored.FailureReason = record.Status, record.TransferId, record.FailureReason
+	stored.ExecutedAt = record.ExecutedAt
+	return nil
+}
+
+type StandingOrdersRepository struct {
+	orders map[string]*standing.Order
+	// claimedAt has the claim times of the claimed orders.
+	claimedAt map[string]time.Time
+}
+
+func (sr *StandingOrdersRepository) Create(ctx context.Context, order *standing.Order) error {
+	order.CreatedAt = time.Now()
+	sr.orders[order.Id] = order
+	return nil
+}
+
+func (sr *StandingOrdersRepository) Get(ctx context.Context, orderId string) (*standing.Order, error) {
+	return sr.orders[orderId], nil
+}
+
+func (sr *StandingOrdersRepository) GetAll(
+	ctx context.Context, accountId string, offset, limit *int,
+) ([]*standing.Order, error) {
+	ordersList := sr.accountOrders(accountId)
+	start, end := paginate(len(ordersList), offset, limit)
+	return ordersList[start:end], nil
+}
+
+func (sr *StandingOrdersRepository) CountAll(ctx context.Context, accountId string) (int, error) {
+	return len(sr.accountOrders(accountId)), nil
+}
+
+// accountOrders returns orders from or to the account ordered by the creation time.
+func (sr *StandingOrdersRepository) accountOrders(accountId string) []*standing.Order {
+	var ordersList []*standing.Order
+	for _, o := range sr.orders {
+		if accountId == "" || o.FromAccountId == accountId || o.ToAccountId == accountId {
+			ordersList = append(ordersList, o)
+		}
+	}
+	sort.Slice(ordersList, func(i, j int) bool {
+		if ordersList[i].CreatedAt.Equal(ordersList[j].CreatedAt) {
+			return ordersList[i].Id < ordersList[j].Id
+		}
+		return ordersList[i].CreatedAt.Before(ordersList[j].CreatedAt)
 	})
-	fromAccount.Balance -= amount
-	toAccount.Balance += amount
+	return ordersList
+}
+
+func (sr *StandingOrdersRepository) Cancel(ctx context.Context, orderId string) (*standing.Order, error) {
+	order := sr.orders[orderId]
+	if order == nil {
+		return nil, nil
+	}
+	if order.Status != standing.ActiveStatus {
+		return nil, standing.NotActiveErr
+	}
+	order.Status = standing.CanceledStatus
+	return order, nil
+}
+
+func (sr *StandingOrdersRepository) ClaimDue(
+	ctx context.Context, limit int, claimTimeout time.Duration,
+) ([]*standing.Order, error) {
+	now := time.Now()
+	var claimed []*standing.Order
+	for _, o := range sr.accountOrders("") {
+		if len(claimed) == limit {
+			break
+		}
+		if o.Status != standing.ActiveStatus || o.NextRunAt.After(now) {
+			continue
+		}
+		if claimedAt, ok := sr.claimedAt[o.Id]; ok && claimedAt.After(now.Add(-claimTimeout)) {
+			continue
+		}
+		sr.claimedAt[o.Id] = now
+		// The worker gets a copy, as it would get from postgres, so a concurrent cancellation isn't overwritten.
+		orderCopy := *o
+		claimed = append(claimed, &orderCopy)
+	}
+	return claimed, nil
+}
+
+func (sr *StandingOrdersRepository) Update(ctx context.Context, order *standing.Order) error {
+	delete(sr.claimedAt, order.Id)
+	if sr.orders[order.Id].Status != standing.ActiveStatus {
+		return nil
+	}
+	orderCopy := *order
+	sr.orders[order.Id] = &orderCopy
 	return nil
 }
+
+// OutboxRepository keeps the events in the id order, published events are removed.
+type OutboxRepository struct {
+	// events are the undelivered ones in the id order, dead events are moved to deadEvents.
+	events      []*outbox.Event
+	deadEvents  []*outbox.Event
+	lastEventId int64
+	claimedAt   map[int64]time.Time
+}
+
+func (or *OutboxRepository) save(event *outbox.Event) {
+	or.lastEventId++
+	event.Id = or.lastEventId
+	event.CreatedAt = time.Now()
+	event.NextAttemptAt = event.CreatedAt
+	or.events = append(or.events, event)
+}
+
+func (or *OutboxRepository) ClaimPending(
+	ctx context.Context, limit int, claimTimeout time.Duration,
+) ([]*outbox.Event, error) {
+	now := time.Now()
+	for _, claimedAt := range or.claimedAt {
+		if claimedAt.After(now.Add(-claimTimeout)) {
+			return nil, nil
+		}
+	}
+	if len(or.events) == 0 || or.events[0].NextAttemptAt.After(now) {
+		return nil, nil
+	}
+	n := len(or.events)
+	if n > limit {
+		n = limit
+	}
+	claimed := append([]*outbox.Event(nil), or.events[:n]...)
+	for _, event := range claimed {
+		or.claimedAt[event.Id] = now
+	}
+	return claimed, nil
+}
+
+func (or *OutboxRepository) MarkPublished(ctx context.Context, eventId int64) error {
+	for i, event := range or.events {
+		if event.Id == eventId {
+			or.events = append(or.events[:i], or.events[i+1:]...)
+			delete(or.claimedAt, eventId)
+			return nil
+		}
+	}
+	return errors.New("event not found")
+}
+
+func (or *OutboxRepository) MarkFailed(
+	ctx context.Context, eventId int64, deliveryErr error, nextAttemptAt time.Time,
+) error {
+	for _, event := range or.events {
+		if event.Id == eventId {
+			event.Attempts++
+			event.LastError = deliveryErr.Error()
+			event.NextAttemptAt = nextAttemptAt
+			or.claimedAt = map[int64]time.Time{}
+			return nil
+		}
+	}
+	return errors.New("event not found")
+}
+
+func (or *OutboxRepository) MarkDead(ctx context.Context, eventId int64, deliveryErr error) error {
+	for i, event := range or.events {
+		if event.Id == eventId {
+			event.Attempts++
+			event.LastError = deliveryErr.Error()
+			or.events = append(or.events[:i], or.events[i+1:]...)
+			or.deadEvents = append(or.deadEvents, event)
+			delete(or.claimedAt, eventId)
+			return nil
+		}
+	}
+	return errors.New("event not found")
+}
+
+// idempotencyRecordKey identifies a record the same way the primary key of the postgres table does.
+type idempotencyRecordKey struct {
+	scope, key string
+}
+
+type IdempotencyRepository struct {
+	records map[idempotencyRecordKey]*idempotency.Record
+}
+
+func (ir *IdempotencyRepository) Get(ctx context.Context, scope, key string) (*idempotency.Record, error) {
+	return ir.records[idempotencyRecordKey{scope: scope, key: key}], nil
+}
+
+// paginate returns bounds of the page within a list of the given length, same as sql offset and limit do.
+func paginate(length int, offset, limit *int) (int, int) {
+	start := 0
+	if offset != nil {
+		start = *offset
+	}
+	if start > length {
+		start = length
+	}
+	end := length
+	if limit != nil && start+*limit < end {
+		end = start + *limit
+	}
+	return start, end
+}
+
+// pageStartBefore returns the start of a page of up to limit items, which ends right before the end index.
+func pageStartBefore(end, limit int) int {
+	if end < limit {
+		return 0
+	}
+	return end - limit
+}
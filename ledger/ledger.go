@@ -0,0 +1,158 @@
+package ledger
+
+import (
+	"context"
+	"github.com/georgysavva/generic-wallet/account"
+	"github.com/georgysavva/generic-wallet/money"
+	"github.com/georgysavva/generic-wallet/payment"
+	"github.com/pkg/errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// TransferKind transactions move money between accounts, they are listed as payments.
+	TransferKind = "transfer"
+	// RefundKind transactions move money of a transfer back, they are listed as payments too.
+	RefundKind = "refund"
+	// OpeningKind transactions set initial balances of accounts created with money.
+	OpeningKind = "opening"
+)
+
+// System accounts are internal to the ledger, they have no account records and are never listed as payments.
+// Their ids start with a character which isn't allowed in ids of regular accounts.
+const systemAccountPrefix = "@"
+
+// ExchangeAccount returns the system account which balances currency exchanges in the currency.
+// It receives the source amount of a cross-currency transfer and pays out the destination amount.
+func ExchangeAccount(currency string) string {
+	return systemAccountPrefix + "exchange:" + currency
+}
+
+// RevenueAccount returns the system account which receives the fees charged in the currency.
+func RevenueAccount(currency string) string {
+	return systemAccountPrefix + "revenue:" + currency
+}
+
+// EquityAccount returns the system account which funds opening balances in the currency.
+func EquityAccount(currency string) string {
+	return systemAccountPrefix + "equity:" + currency
+}
+
+func IsSystemAccount(accountId string) bool {
+	return strings.HasPrefix(accountId, systemAccountPrefix)
+}
+
+// Posting is a single entry of a transaction.
+type Posting struct {
+	Id            int64  `json:"id"`
+	TransactionId string `json:"transaction_id"`
+	AccountId     string `json:"account"`
+	Currency      string `json:"currency"`
+	// Amount is positive for a credit, which increases the account balance, and negative for a debit.
+	Amount    money.Amount `json:"amount"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// Transaction is an atomic set of postings, postings in each currency sum to zero,
+// so money is never created or destroyed.
+type Transaction struct {
+	Id        string     `json:"id"`
+	Kind      string     `json:"kind"`
+	Postings  []*Posting `json:"postings"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// NewTransferTransaction returns the transaction recording the transfer or the refund.
+// A cross-currency transfer goes through the exchange accounts of both currencies.
+// The source account is debited with the amount and the fee in a single posting,
+// the fee goes to the revenue account of the source currency.
+func NewTransferTransaction(transfer *payment.Transfer) *Transaction {
+	t := &Transaction{Id: transfer.Id, Kind: TransferKind}
+	if transfer.RefundedTransferId != "" {
+		t.Kind = RefundKind
+	}
+	t.post(transfer.FromAccountId, transfer.Currency, transfer.Amount.Add(transfer.Fee).Neg())
+	if !transfer.Fee.IsZero() {
+		t.post(RevenueAccount(transfer.Currency), transfer.Currency, transfer.Fee)
+	}
+	if transfer.Currency == transfer.DestinationCurrency {
+		t.post(transfer.ToAccountId, transfer.DestinationCurrency, transfer.DestinationAmount)
+		return t
+	}
+	t.post(ExchangeAccount(transfer.Currency), transfer.Currency, transfer.Amount)
+	t.post(ExchangeAccount(transfer.DestinationCurrency), transfer.DestinationCurrency, transfer.DestinationAmount.Neg())
+	t.post(transfer.ToAccountId, transfer.DestinationCurrency, transfer.DestinationAmount)
+	return t
+}
+
+// NewOpeningTransaction returns the transaction funding the current balances of the accounts
+// from the equity accounts, or nil if all balances are zero.
+func NewOpeningTransaction(transactionId string, accounts []*account.Account) *Transaction {
+	t := &Transaction{Id: transactionId, Kind: OpeningKind}
+	totals := map[string]money.Amount{}
+	for _, a := range accounts {
+		if a.Balance.IsZero() {
+			continue
+		}
+		t.post(a.Id, a.Currency, a.Balance)
+		totals[a.Currency] = totals[a.Currency].Add(a.Balance)
+	}
+	if len(t.Postings) == 0 {
+		return nil
+	}
+	var currencies []string
+	for currency := range totals {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	for _, currency := range currencies {
+		t.post(EquityAccount(currency), currency, totals[currency].Neg())
+	}
+	return t
+}
+
+func (t *Transaction) post(accountId, currency string, amount money.Amount) {
+	t.Postings = append(t.Postings, &Posting{
+		TransactionId: t.Id, AccountId: accountId, Currency: currency, Amount: amount,
+	})
+}
+
+// Validate returns UnbalancedTransactionErr if postings of any currency don't sum to zero.
+func (t *Transaction) Validate() error {
+	if len(t.Postings) < 2 {
+		return errors.Wrapf(UnbalancedTransactionErr, "transaction %s has less than 2 postings", t.Id)
+	}
+	sums := map[string]money.Amount{}
+	for _, p := range t.Postings {
+		if p.Amount.IsZero() {
+			return errors.Errorf("transaction %s has a zero posting to %s", t.Id, p.AccountId)
+		}
+		sums[p.Currency] = sums[p.Currency].Add(p.Amount)
+	}
+	for currency, sum := range sums {
+		if !sum.IsZero() {
+			return errors.Wrapf(UnbalancedTransactionErr, "transaction %s postings in %s sum to %s", t.Id, currency, sum)
+		}
+	}
+	return nil
+}
+
+var UnbalancedTransactionErr = errors.New("ledger transaction is unbalanced")
+
+// Discrepancy is an account whose stored balance doesn't match the sum of its postings.
+type Discrepancy struct {
+	AccountId     string       `json:"account"`
+	Balance       money.Amount `json:"balance"`
+	LedgerBalance money.Amount `json:"ledger_balance"`
+}
+
+// Repository reads the ledger, transactions are written by the payments repository
+// together with the account balances they change.
+type Repository interface {
+	// GetTransaction returns nil if the transaction doesn't exist.
+	GetTransaction(ctx context.Context, transactionId string) (*Transaction, error)
+	// GetDiscrepancies returns accounts whose stored balance differs from the sum of their postings.
+	GetDiscrepancies(ctx context.Context) ([]*Discrepancy, error)
+}
@@ -0,0 +1,78 @@
+package ledger
+
+import (
+	"github.com/georgysavva/generic-wallet/account"
+	"github.com/georgysavva/generic-wallet/money"
+	"github.com/georgysavva/generic-wallet/payment"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNewTransferTransaction(t *testing.T) {
+	transaction := NewTransferTransaction(&payment.Transfer{
+		Id: "t1", FromAccountId: "alice", ToAccountId: "bob",
+		Amount: money.MustParse("20"), Currency: "USD",
+		DestinationAmount: money.MustParse("20"), DestinationCurrency: "USD",
+	})
+	assert.Equal(t, nil, transaction.Validate())
+	expectedPostings := []*Posting{
+		{TransactionId: "t1", AccountId: "alice", Currency: "USD", Amount: money.MustParse("-20")},
+		{TransactionId: "t1", AccountId: "bob", Currency: "USD", Amount: money.MustParse("20")},
+	}
+	assert.Equal(t, expectedPostings, transaction.Postings)
+
+	transaction = NewTransferTransaction(&payment.Transfer{
+		Id: "t2", FromAccountId: "alice", ToAccountId: "kate",
+		Amount: money.MustParse("10"), Currency: "USD",
+		DestinationAmount: money.MustParse("9"), DestinationCurrency: "EUR",
+	})
+	assert.Equal(t, nil, transaction.Validate())
+	expectedPostings = []*Posting{
+		{TransactionId: "t2", AccountId: "alice", Currency: "USD", Amount: money.MustParse("-10")},
+		{TransactionId: "t2", AccountId: "@exchange:USD", Currency: "USD", Amount: money.MustParse("10")},
+		{TransactionId: "t2", AccountId: "@exchange:EUR", Currency: "EUR", Amount: money.MustParse("-9")},
+		{TransactionId: "t2", AccountId: "kate", Currency: "EUR", Amount: money.MustParse("9")},
+	}
+	assert.Equal(t, expectedPostings, transaction.Postings)
+
+	transaction = NewTransferTransaction(&payment.Transfer{
+		Id: "t3", FromAccountId: "alice", ToAccountId: "bob",
+		Amount: money.MustParse("20"), Currency: "USD", Fee: money.MustParse("0.5"),
+		DestinationAmount: money.MustParse("20"), DestinationCurrency: "USD",
+	})
+	assert.Equal(t, nil, transaction.Validate())
+	expectedPostings = []*Posting{
+		{TransactionId: "t3", AccountId: "alice", Currency: "USD", Amount: money.MustParse("-20.5")},
+		{TransactionId: "t3", AccountId: "@revenue:USD", Currency: "USD", Amount: money.MustParse("0.5")},
+		{TransactionId: "t3", AccountId: "bob", Currency: "USD", Amount: money.MustParse("20")},
+	}
+	assert.Equal(t, expectedPostings, transaction.Postings)
+}
+
+func TestNewOpeningTransaction(t *testing.T) {
+	transaction := NewOpeningTransaction("opening", []*account.Account{
+		{Id: "alice", Balance: money.MustParse("100"), Currency: "USD"},
+		{Id: "bob", Balance: money.MustParse("50"), Currency: "USD"},
+		{Id: "empty", Currency: "USD"},
+		{Id: "kate", Balance: money.MustParse("30"), Currency: "EUR"},
+	})
+	assert.Equal(t, nil, transaction.Validate())
+	assert.Equal(t, 5, len(transaction.Postings))
+	assert.Equal(t, EquityAccount("EUR"), transaction.Postings[3].AccountId)
+	assert.Equal(t, money.MustParse("-30"), transaction.Postings[3].Amount)
+	assert.Equal(t, money.MustParse("-150"), transaction.Postings[4].Amount)
+
+	assert.Equal(t, (*Transaction)(nil), NewOpeningTransaction("opening", []*account.Account{{Id: "empty"}}))
+}
+
+func TestValidate_Unbalanced(t *testing.T) {
+	transaction := &Transaction{Id: "t1", Kind: TransferKind}
+	transaction.post("alice", "USD", money.MustParse("-10"))
+	transaction.post("kate", "EUR", money.MustParse("10"))
+	assert.Equal(t, UnbalancedTransactionErr, errors.Cause(transaction.Validate()))
+
+	transaction = &Transaction{Id: "t2", Kind: TransferKind}
+	transaction.post("alice", "USD", money.MustParse("-10"))
+	assert.Equal(t, UnbalancedTransactionErr, errors.Cause(transaction.Validate()))
+}
@@ -5,11 +5,17 @@ import (
 	"flag"
 	"fmt"
 	"github.com/georgysavva/generic-wallet/config"
+	"github.com/georgysavva/generic-wallet/fx"
+	"github.com/georgysavva/generic-wallet/health"
+	"github.com/georgysavva/generic-wallet/metrics"
+	"github.com/georgysavva/generic-wallet/outbox"
 	"github.com/georgysavva/generic-wallet/postgres"
+	"github.com/georgysavva/generic-wallet/tracing"
 	"github.com/georgysavva/generic-wallet/wallet"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
@@ -36,14 +42,94 @@ func main() {
 		panic(err)
 	}
 
+	idempotencyRepository, err := postgres.NewIdempotencyRepository(conf.Postgres)
+	if err != nil {
+		panic(err)
+	}
+
+	ledgerRepository, err := postgres.NewLedgerRepository(conf.Postgres)
+	if err != nil {
+		panic(err)
+	}
+
+	holdsRepository, err := postgres.NewHoldsRepository(conf.Postgres)
+	if err != nil {
+		panic(err)
+	}
+
+	scheduledPaymentsRepository, err := postgres.NewScheduledPaymentsRepository(conf.Postgres)
+	if err != nil {
+		panic(err)
+	}
+
+	standingOrdersRepository, err := postgres.NewStandingOrdersRepository(conf.Postgres)
+	if err != nil {
+		panic(err)
+	}
+
+	outboxRepository, err := postgres.NewOutboxRepository(conf.Postgres)
+	if err != nil {
+		panic(err)
+	}
+
+	rates, err := newRatesProvider(conf.FX)
+	if err != nil {
+		panic(err)
+	}
+
+	err = conf.Fees.Validate()
+	if err != nil {
+		panic(err)
+	}
+
 	var logger log.Logger
 	logger = log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
 	logger = log.With(logger, "ts", log.DefaultTimestampUTC)
-	ws := wallet.NewService(paymentsRepository, accountsRepository)
+	ws := wallet.NewService(
+		paymentsRepository, accountsRepository, idempotencyRepository, ledgerRepository, holdsRepository,
+		scheduledPaymentsRepository, standingOrdersRepository, rates, conf.SpendingLimits, conf.Fees,
+	)
+	ws = wallet.NewTracingService(ws)
 	ws = wallet.NewLoggingService(log.With(logger, "component", "wallet"), ws)
+	registry := metrics.NewRegistry()
+	ws = wallet.NewInstrumentingService(registry, ws)
+	pools := map[string]postgres.Pool{
+		"payments":           paymentsRepository,
+		"accounts":           accountsRepository,
+		"idempotency":        idempotencyRepository,
+		"ledger":             ledgerRepository,
+		"holds":              holdsRepository,
+		"scheduled_payments": scheduledPaymentsRepository,
+		"standing_orders":    standingOrdersRepository,
+		"outbox":             outboxRepository,
+	}
+	postgres.RegisterPoolMetrics(registry, pools)
+	probes := health.New(time.Millisecond * time.Duration(conf.Postgres.Timeout))
+	for name, pool := range pools {
+		probes.AddCheck("postgres."+name, pool.Ping)
+	}
 	mux := http.NewServeMux()
+	mux.Handle("/healthz", probes.LivenessHandler())
+	mux.Handle("/readyz", probes.ReadinessHandler())
 	httpLogger := log.With(logger, "component", "http")
-	mux.Handle("/wallet/v1/", wallet.MakeHandler(ws, httpLogger))
+	authenticator, err := newAuthenticator(conf.Auth)
+	if err != nil {
+		panic(err)
+	}
+	if authenticator == nil {
+		logger.Log("msg", "Authentication is disabled")
+	}
+	rateLimits, err := newRateLimits(conf.RateLimits)
+	if err != nil {
+		panic(err)
+	}
+	tracer, err := newTracer(conf.Tracing)
+	if err != nil {
+		panic(err)
+	}
+	mux.Handle("/wallet/v1/", wallet.MakeHandler(
+		wallet.NewAuthorizationService(ws), authenticator, rateLimits, tracer, httpLogger,
+	))
 
 	httpAddr := fmt.Sprintf(":%d", conf.Port)
 	server := &http.Server{Addr: httpAddr, Handler: mux}
@@ -53,19 +139,232 @@ func main() {
 			panic(err)
 		}
 	}()
+	// Metrics are served on a separate port, which isn't exposed publicly.
+	var metricsServer *http.Server
+	if conf.MetricsPort != 0 {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", registry)
+		metricsAddr := fmt.Sprintf(":%d", conf.MetricsPort)
+		metricsServer = &http.Server{Addr: metricsAddr, Handler: metricsMux}
+		logger.Log("msg", "Start listening", "transport", "http", "address", metricsAddr, "component", "metrics")
+		go func() {
+			if err := metricsServer.ListenAndServe(); err != http.ErrServerClosed {
+				panic(err)
+			}
+		}()
+	} else {
+		logger.Log("msg", "Metrics port is not set, metrics are not served")
+	}
+
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	schedulerLogger := log.With(logger, "component", "scheduler")
+	scheduler := newScheduledPaymentsWorker(ws, scheduledPaymentsRepository, conf.Scheduler, schedulerLogger)
+	standingOrdersLogger := log.With(logger, "component", "standing_orders")
+	standingOrders := newStandingOrdersWorker(ws, standingOrdersRepository, conf.StandingOrders, standingOrdersLogger)
+	outboxLogger := log.With(logger, "component", "outbox")
+	relay, err := newOutboxRelay(outboxRepository, conf.Outbox, outboxLogger)
+	if err != nil {
+		panic(err)
+	}
+	workers.Add(3)
+	go func() {
+		defer workers.Done()
+		scheduler.Run(workersCtx)
+	}()
+	go func() {
+		defer workers.Done()
+		standingOrders.Run(workersCtx)
+	}()
+	go func() {
+		defer workers.Done()
+		relay.Run(workersCtx)
+	}()
 
 	signalCode := waitingForShutdown()
 	logger.Log("msg", "Received shutdown signal", "code", signalCode)
+	// Load balancers stop sending requests once readiness fails, the server keeps serving until then.
+	probes.ShutDown()
+	time.Sleep(time.Millisecond * time.Duration(conf.ShutDownDelay))
+	stopWorkers()
+	workers.Wait()
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*time.Duration(conf.ShutDownTimeout))
 	defer cancel()
 	if err := server.Shutdown(ctx); err != nil {
 		logger.Log("msg", "Graceful shutdown failed", "err", err)
 	}
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(ctx); err != nil {
+			logger.Log("msg", "Graceful shutdown of the metrics server failed", "err", err)
+		}
+	}
+}
+
+// newRatesProvider returns nil if payments between different currencies must be rejected.
+func newRatesProvider(settings *config.FX) (fx.Provider, error) {
+	if settings == nil || settings.Mode == "" || settings.Mode == config.FXRejectMode {
+		return nil, nil
+	}
+	if settings.Mode != config.FXConvertMode {
+		return nil, fmt.Errorf("unknown fx mode %q", settings.Mode)
+	}
+	if settings.RatesFile != "" {
+		return fx.LoadStaticProvider(settings.RatesFile)
+	}
+	if settings.RatesURL != "" {
+		return fx.NewHTTPProvider(settings.RatesURL, time.Millisecond*time.Duration(settings.Timeout)), nil
+	}
+	return nil, fmt.Errorf("either rates_file or rates_url must be set in %s fx mode", config.FXConvertMode)
+}
+
+// newAuthenticator returns nil if authentication is disabled.
+func newAuthenticator(settings *config.Auth) (*wallet.Authenticator, error) {
+	if settings == nil {
+		return nil, fmt.Errorf("auth settings are required, set disabled to serve requests without authentication")
+	}
+	if settings.Disabled {
+		return nil, nil
+	}
+	apiKeys := map[string]*wallet.Principal{}
+	for _, apiKey := range settings.APIKeys {
+		apiKeys[apiKey.Key] = &wallet.Principal{Id: apiKey.Principal, Role: apiKey.Role, Accounts: apiKey.Accounts}
+	}
+	jwtKeys := map[string][]byte{}
+	for keyId, key := range settings.JWTKeys {
+		jwtKeys[keyId] = []byte(key)
+	}
+	return wallet.NewAuthenticator(apiKeys, jwtKeys), nil
+}
+
+// newRateLimits doesn't limit the requests missing in the config.
+func newRateLimits(settings *config.RateLimits) (wallet.RateLimits, error) {
+	var rateLimits wallet.RateLimits
+	if settings == nil {
+		return rateLimits, nil
+	}
+	var err error
+	rateLimits.Reads, err = newRateLimiter("reads", settings.Reads)
+	if err != nil {
+		return rateLimits, err
+	}
+	rateLimits.Writes, err = newRateLimiter("writes", settings.Writes)
+	if err != nil {
+		return rateLimits, err
+	}
+	rateLimits.AuthFailures, err = newRateLimiter("auth_failures", settings.AuthFailures)
+	return rateLimits, err
+}
+
+func newRateLimiter(name string, settings *config.RateLimit) (*wallet.RateLimiter, error) {
+	if settings == nil {
+		return nil, nil
+	}
+	if settings.PerSecond <= 0 || settings.Burst < 1 {
+		return nil, fmt.Errorf("%s rate limit must have positive per_second and burst", name)
+	}
+	return wallet.NewRateLimiter(settings.PerSecond, settings.Burst), nil
+}
+
+// newTracer returns a tracer, which doesn't export spans if the exporter isn't configured.
+func newTracer(settings *config.Tracing) (*tracing.Tracer, error) {
+	if settings == nil || settings.Exporter == "" {
+		return tracing.NewTracer(nil), nil
+	}
+	switch settings.Exporter {
+	case config.StdoutExporter:
+		return tracing.NewTracer(tracing.NewWriterExporter(os.Stdout)), nil
+	case config.HTTPExporter:
+		if settings.CollectorURL == "" {
+			return nil, fmt.Errorf("collector_url must be set for %s tracing exporter", config.HTTPExporter)
+		}
+		timeout := time.Millisecond * time.Duration(settings.Timeout)
+		return tracing.NewTracer(tracing.NewHTTPExporter(settings.CollectorURL, timeout, 1000)), nil
+	}
+	return nil, fmt.Errorf("unknown tracing exporter %q", settings.Exporter)
+}
+
+// newScheduledPaymentsWorker uses defaults for the settings missing in the config.
+func newScheduledPaymentsWorker(
+	ws wallet.Service, scheduledPayments *postgres.ScheduledPaymentsRepository, settings *config.Scheduler,
+	logger log.Logger,
+) *wallet.ScheduledPaymentsWorker {
+	interval, batchSize := time.Second, 100
+	if settings != nil && settings.Interval > 0 {
+		interval = time.Millisecond * time.Duration(settings.Interval)
+	}
+	if settings != nil && settings.BatchSize > 0 {
+		batchSize = settings.BatchSize
+	}
+	return wallet.NewScheduledPaymentsWorker(ws, scheduledPayments, interval, batchSize, logger)
+}
+
+// newStandingOrdersWorker uses defaults for the settings missing in the config.
+func newStandingOrdersWorker(
+	ws wallet.Service, orders *postgres.StandingOrdersRepository, settings *config.StandingOrders, logger log.Logger,
+) *wallet.StandingOrdersWorker {
+	interval, batchSize := time.Minute, 100
+	retryPolicy := wallet.RetryPolicy{MaxAttempts: 3, Interval: time.Hour}
+	if settings != nil && settings.Interval > 0 {
+		interval = time.Millisecond * time.Duration(settings.Interval)
+	}
+	if settings != nil && settings.BatchSize > 0 {
+		batchSize = settings.BatchSize
+	}
+	if settings != nil && settings.RetryAttempts > 0 {
+		retryPolicy.MaxAttempts = settings.RetryAttempts
+	}
+	if settings != nil && settings.RetryInterval > 0 {
+		retryPolicy.Interval = time.Millisecond * time.Duration(settings.RetryInterval)
+	}
+	return wallet.NewStandingOrdersWorker(ws, orders, interval, batchSize, retryPolicy, logger)
+}
+
+// newOutboxRelay uses defaults for the settings missing in the config.
+func newOutboxRelay(
+	events *postgres.OutboxRepository, settings *config.Outbox, logger log.Logger,
+) (*wallet.OutboxRelay, error) {
+	interval, batchSize, retryInterval, maxAttempts := time.Second, 100, time.Second, 20
+	var publisher outbox.Publisher = outbox.NewLogPublisher(logger)
+	if settings == nil {
+		return wallet.NewOutboxRelay(events, publisher, interval, batchSize, retryInterval, maxAttempts, logger), nil
+	}
+	switch settings.Publisher {
+	case "", config.LogPublisher:
+	case config.HTTPPublisher:
+		if settings.URL == "" {
+			return nil, fmt.Errorf("url must be set for %s outbox publisher", config.HTTPPublisher)
+		}
+		publisher = outbox.NewHTTPPublisher(settings.URL, time.Millisecond*time.Duration(settings.Timeout))
+	case config.FilePublisher:
+		if settings.File == "" {
+			return nil, fmt.Errorf("file must be set for %s outbox publisher", config.FilePublisher)
+		}
+		filePublisher, err := outbox.NewFilePublisher(settings.File)
+		if err != nil {
+			return nil, err
+		}
+		publisher = filePublisher
+	default:
+		return nil, fmt.Errorf("unknown outbox publisher %q", settings.Publisher)
+	}
+	if settings.Interval > 0 {
+		interval = time.Millisecond * time.Duration(settings.Interval)
+	}
+	if settings.BatchSize > 0 {
+		batchSize = settings.BatchSize
+	}
+	if settings.RetryInterval > 0 {
+		retryInterval = time.Millisecond * time.Duration(settings.RetryInterval)
+	}
+	if settings.MaxAttempts > 0 {
+		maxAttempts = settings.MaxAttempts
+	}
+	return wallet.NewOutboxRelay(events, publisher, interval, batchSize, retryInterval, maxAttempts, logger), nil
 }
 
 func waitingForShutdown() os.Signal {
-	ch := make(chan os.Signal)
+	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
 	signalCode := <-ch
 	return signalCode
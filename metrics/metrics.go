@@ -0,0 +1,219 @@
+// Package metrics implements counters, gauges and histograms exposed in the Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds of histogram buckets for latencies in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds the metrics and serves them over HTTP.
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+	// collectors update metrics mirroring values kept elsewhere right before they are served.
+	collectors []func()
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// OnCollect registers the function called every time the metrics are served.
+func (r *Registry) OnCollect(collect func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, collect)
+}
+
+func (r *Registry) register(name, help, metricType string, labelNames []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f := &family{
+		name: name, help: help, metricType: metricType, labelNames: labelNames, series: map[string]*series{},
+	}
+	r.families = append(r.families, f)
+	return f
+}
+
+// ServeHTTP writes all metrics in the Prometheus text format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	r.mu.Lock()
+	collectors := r.collectors
+	families := r.families
+	r.mu.Unlock()
+	for _, collect := range collectors {
+		collect()
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw)
+	}
+	bw.Flush()
+}
+
+// family is a metric with all its label combinations.
+type family struct {
+	name       string
+	help       string
+	metricType string
+	labelNames []string
+	buckets    []float64
+	mu         sync.Mutex
+	series     map[string]*series
+}
+
+// series is a metric with particular label values.
+type series struct {
+	labels string
+	value  float64
+	// bucketCounts and count are used only by histograms, value is the sum of the observations then.
+	bucketCounts []uint64
+	count        uint64
+}
+
+// with calls update with the series of the label values under the lock of the family.
+func (f *family) with(labelValues []string, update func(s *series)) {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", f.name, len(f.labelNames), len(labelValues)))
+	}
+	labels := formatLabels(f.labelNames, labelValues)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s := f.series[labels]
+	if s == nil {
+		s = &series{labels: labels, bucketCounts: make([]uint64, len(f.buckets))}
+		f.series[labels] = s
+	}
+	update(s)
+}
+
+func (f *family) write(w *bufio.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escape(f.help, false))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.metricType)
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		if f.metricType != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, braces(s.labels), formatFloat(s.value))
+			continue
+		}
+		for i, upperBound := range f.buckets {
+			bucketLabels := joinLabels(s.labels, `le="`+formatFloat(upperBound)+`"`)
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, braces(bucketLabels), s.bucketCounts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, braces(joinLabels(s.labels, `le="+Inf"`)), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, braces(s.labels), formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, braces(s.labels), s.count)
+	}
+}
+
+// Counter is a metric, which only goes up.
+type Counter struct {
+	family *family
+}
+
+func (r *Registry) NewCounter(name, help string, labelNames ...string) *Counter {
+	return &Counter{r.register(name, help, "counter", labelNames)}
+}
+
+// Add increases the counter with the label values, they are in the order of the label names.
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	c.family.with(labelValues, func(s *series) { s.value += delta })
+}
+
+// Set is for counters mirroring cumulative values kept elsewhere, e.g. by a connection pool.
+func (c *Counter) Set(value float64, labelValues ...string) {
+	c.family.with(labelValues, func(s *series) { s.value = value })
+}
+
+// Gauge is a metric, which can go up and down.
+type Gauge struct {
+	family *family
+}
+
+func (r *Registry) NewGauge(name, help string, labelNames ...string) *Gauge {
+	return &Gauge{r.register(name, help, "gauge", labelNames)}
+}
+
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.family.with(labelValues, func(s *series) { s.value = value })
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	family *family
+}
+
+// NewHistogram returns a histogram with the bucket upper bounds, they must be sorted.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	f := r.register(name, help, "histogram", labelNames)
+	f.buckets = buckets
+	return &Histogram{f}
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.family.with(labelValues, func(s *series) {
+		for i, upperBound := range h.family.buckets {
+			if value <= upperBound {
+				s.bucketCounts[i]++
+			}
+		}
+		s.count++
+		s.value += value
+	})
+}
+
+func formatLabels(names, values []string) string {
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + escape(values[i], true) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+func joinLabels(labels, label string) string {
+	if labels == "" {
+		return label
+	}
+	return labels + "," + label
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+// escape escapes backslashes and line feeds, and double quotes in label values.
+func escape(s string, labelValue bool) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	if labelValue {
+		s = strings.Replace(s, `"`, `\"`, -1)
+	}
+	return s
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.NewCounter("requests_total", "Number of requests.", "method", "code")
+	latency := registry.NewHistogram("request_duration_seconds", "Request latency.", []float64{0.1, 1}, "method")
+	connections := registry.NewGauge("connections", "Open connections.")
+	registry.OnCollect(func() { connections.Set(3) })
+
+	requests.Add(1, "send", "OK")
+	requests.Add(2, "send", "LOW_BALANCE")
+	requests.Add(1, "get", `"quoted"`)
+	latency.Observe(0.05, "send")
+	latency.Observe(0.5, "send")
+
+	w := httptest.NewRecorder()
+	registry.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	expected := `# HELP requests_total Number of requests.
+# TYPE requests_total counter
+requests_total{method="get",code="\"quoted\""} 1
+requests_total{method="send",code="LOW_BALANCE"} 2
+requests_total{method="send",code="OK"} 1
+# HELP request_duration_seconds Request latency.
+# TYPE request_duration_seconds histogram
+request_duration_seconds_bucket{method="send",le="0.1"} 1
+request_duration_seconds_bucket{method="send",le="1"} 2
+request_duration_seconds_bucket{method="send",le="+Inf"} 2
+request_duration_seconds_sum{method="send"} 0.55
+request_duration_seconds_count{method="send"} 2
+# HELP connections Open connections.
+# TYPE connections gauge
+connections 3
+`
+	assert.Equal(t, expected, w.Body.String())
+}
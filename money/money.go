@@ -0,0 +1,290 @@
+package money
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Scale is the number of decimal places every amount is stored with.
+	// Postgres columns holding amounts are declared as numeric(18, 6) accordingly.
+	Scale       = 6
+	unitsPerOne = 1000000
+	// maxUnits bounds parsed amounts and rates, since their Postgres columns hold 18 digits.
+	// Amounts are at most 10^12, so adding or subtracting a few of them doesn't overflow.
+	maxUnits = 1000000000000000000
+)
+
+// Amount is an exact decimal amount of money.
+// It's stored as a fixed-point integer number of 10^-Scale units,
+// so adding and subtracting amounts never loses precision.
+type Amount struct {
+	units int64
+}
+
+// currencyPrecisions contains the number of decimal places allowed for each supported currency.
+var currencyPrecisions = map[string]int{
+	"USD": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"CHF": 2,
+	"CAD": 2,
+	"AUD": 2,
+	"CNY": 2,
+	"RUB": 2,
+	"JPY": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"BHD": 3,
+}
+
+// Precision returns the number of decimal places allowed for the currency
+// and false if the currency isn't supported.
+func Precision(currency string) (int, bool) {
+	precision, ok := currencyPrecisions[currency]
+	return precision, ok
+}
+
+// Parse parses a decimal amount, e.g. "-12.05".
+// Exponent notation isn't supported.
+func Parse(s string) (Amount, error) {
+	units, err := parseUnits(s, Scale, "amount")
+	if err != nil {
+		return Amount{}, err
+	}
+	return Amount{units: units}, nil
+}
+
+// parseUnits parses a decimal number into an integer number of 10^-scale units,
+// the kind of the number is used in the errors.
+func parseUnits(s string, scale int, kind string) (int64, error) {
+	text := s
+	negative := false
+	if strings.HasPrefix(text, "-") || strings.HasPrefix(text, "+") {
+		negative = text[0] == '-'
+		text = text[1:]
+	}
+	intPart, fracPart := text, ""
+	if i := strings.IndexByte(text, '.'); i != -1 {
+		intPart, fracPart = text[:i], text[i+1:]
+	}
+	if intPart == "" && fracPart == "" || !isDigits(intPart) || !isDigits(fracPart) {
+		return 0, errors.Errorf("invalid %s %q", kind, s)
+	}
+	fracPart = strings.TrimRight(fracPart, "0")
+	if len(fracPart) > scale {
+		return 0, errors.Errorf("%s %q has more than %d decimal places", kind, s, scale)
+	}
+	digits := intPart + fracPart + strings.Repeat("0", scale-len(fracPart))
+	units, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || units >= maxUnits {
+		return 0, errors.Errorf("%s %q is out of range", kind, s)
+	}
+	if negative {
+		units = -units
+	}
+	return units, nil
+}
+
+// MustParse is like Parse but panics if the amount can't be parsed.
+func MustParse(s string) Amount {
+	a, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Add panics if the sum overflows, it can't happen for amounts within the range of Parse
+// unless a huge number of them is summed.
+func (a Amount) Add(b Amount) Amount {
+	sum := a.units + b.units
+	if (sum > a.units) != (b.units > 0) {
+		panic(errors.Errorf("sum of amounts %s and %s overflows", a, b))
+	}
+	return Amount{units: sum}
+}
+
+// Sub panics if the difference overflows, see Add.
+func (a Amount) Sub(b Amount) Amount {
+	difference := a.units - b.units
+	if (difference < a.units) != (b.units > 0) {
+		panic(errors.Errorf("difference of amounts %s and %s overflows", a, b))
+	}
+	return Amount{units: difference}
+}
+
+func (a Amount) Neg() Amount {
+	return Amount{units: -a.units}
+}
+
+// Cmp returns -1 if a < b, 0 if a == b and +1 if a > b.
+func (a Amount) Cmp(b Amount) int {
+	switch {
+	case a.units < b.units:
+		return -1
+	case a.units > b.units:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Sign returns -1, 0 or +1 depending on the sign of the amount.
+func (a Amount) Sign() int {
+	return a.Cmp(Amount{})
+}
+
+func (a Amount) IsZero() bool {
+	return a.units == 0
+}
+
+// Convert multiplies the amount by the rate, e.g. a fee rate, and rounds the result half away from zero
+// to the given number of decimal places. Exchange rates are applied with Exchange.
+func (a Amount) Convert(rate Amount, decimals int) (Amount, error) {
+	return a.multiply(rate.units, Scale, rate, decimals)
+}
+
+// Exchange converts the amount at the exchange rate and rounds the result half away from zero
+// to the given number of decimal places.
+func (a Amount) Exchange(rate Rate, decimals int) (Amount, error) {
+	return a.multiply(rate.units, RateScale, rate, decimals)
+}
+
+// multiply multiplies the amount by a factor of 10^-factorScale units and rounds the result.
+func (a Amount) multiply(factorUnits int64, factorScale int, factor fmt.Stringer, decimals int) (Amount, error) {
+	if decimals < 0 || decimals > Scale {
+		return Amount{}, errors.Errorf("can't round amount to %d decimal places", decimals)
+	}
+	// The product has Scale+factorScale decimal places, it's rounded to the requested ones.
+	product := new(big.Int).Mul(big.NewInt(a.units), big.NewInt(factorUnits))
+	divisor := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(Scale+factorScale-decimals)), nil)
+	quotient, remainder := new(big.Int).QuoRem(product, divisor, new(big.Int))
+	doubledRemainder := remainder.Lsh(remainder.Abs(remainder), 1)
+	if doubledRemainder.Cmp(divisor) >= 0 {
+		quotient.Add(quotient, big.NewInt(int64(product.Sign())))
+	}
+	units := quotient.Mul(quotient, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(Scale-decimals)), nil))
+	if units.CmpAbs(big.NewInt(maxUnits)) >= 0 {
+		return Amount{}, errors.Errorf("amount %s converted at rate %s is out of range", a, factor)
+	}
+	return Amount{units: units.Int64()}, nil
+}
+
+// Float64 returns the nearest float, it's lossy and must be used only for statistics, e.g. metrics.
+func (a Amount) Float64() float64 {
+	return float64(a.units) / unitsPerOne
+}
+
+// Decimals returns the number of significant decimal places, e.g. 2 for "10.50" and 0 for "10.00".
+func (a Amount) Decimals() int {
+	units := a.units
+	decimals := Scale
+	for decimals > 0 && units%10 == 0 {
+		units /= 10
+		decimals--
+	}
+	return decimals
+}
+
+// String formats the amount without insignificant trailing zeros, e.g. "10.5".
+func (a Amount) String() string {
+	return formatUnits(a.units, Scale)
+}
+
+// formatUnits formats an integer number of 10^-scale units as a decimal number
+// without insignificant trailing zeros.
+func formatUnits(units int64, scale int) string {
+	sign := ""
+	if units < 0 {
+		sign = "-"
+	}
+	perOne := int64(math.Pow10(scale))
+	intPart := units / perOne
+	fracPart := units % perOne
+	if intPart < 0 {
+		intPart = -intPart
+	}
+	if fracPart < 0 {
+		fracPart = -fracPart
+	}
+	if fracPart == 0 {
+		return fmt.Sprintf("%s%d", sign, intPart)
+	}
+	frac := strings.TrimRight(fmt.Sprintf("%0*d", scale, fracPart), "0")
+	return fmt.Sprintf("%s%d.%s", sign, intPart, frac)
+}
+
+// MarshalJSON encodes the amount as a JSON number, keeping it exact.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON string or a plain decimal JSON number, numbers with an exponent are rejected.
+func (a *Amount) UnmarshalJSON(b []byte) error {
+	text, err := jsonDecimal(b)
+	if err != nil {
+		return err
+	}
+	parsed, err := Parse(text)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+// jsonDecimal returns the text of a JSON string or number holding a decimal number,
+// the text is validated by the parsing.
+func jsonDecimal(b []byte) (string, error) {
+	if len(b) > 0 && b[0] == '"' {
+		var text string
+		if err := json.Unmarshal(b, &text); err != nil {
+			return "", errors.Wrapf(err, "invalid decimal %s", b)
+		}
+		return text, nil
+	}
+	return string(b), nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (a *Amount) Scan(src interface{}) error {
+	var text string
+	switch v := src.(type) {
+	case []byte:
+		text = string(v)
+	case string:
+		text = v
+	case nil:
+		*a = Amount{}
+		return nil
+	default:
+		return errors.Errorf("can't scan %T into money.Amount", src)
+	}
+	parsed, err := Parse(text)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+// Value implements the driver.Valuer interface.
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
@@ -0,0 +1,139 @@
+package money
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		text     string
+		expected string
+		decimals int
+	}{
+		{"100", "100", 0},
+		{"100.50", "100.5", 1},
+		{"0.01", "0.01", 2},
+		{"-12.345", "-12.345", 3},
+		{"-0.5", "-0.5", 1},
+		{"+7.", "7", 0},
+		{".25", "0.25", 2},
+		{"1.1000000000", "1.1", 1},
+		{"-999999999999.999999", "-999999999999.999999", 6},
+	}
+	for _, c := range cases {
+		a, err := Parse(c.text)
+		assert.Equal(t, nil, err, c.text)
+		assert.Equal(t, c.expected, a.String(), c.text)
+		assert.Equal(t, c.decimals, a.Decimals(), c.text)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	invalid := []string{"", "-", ".", "abc", "1e5", "1.2.3", "0.0000001", "99999999999999999999", "1000000000000"}
+	for _, text := range invalid {
+		_, err := Parse(text)
+		assert.NotEqual(t, nil, err, text)
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	sum := Amount{}
+	for i := 0; i < 10; i++ {
+		sum = sum.Add(MustParse("0.1"))
+	}
+	assert.Equal(t, MustParse("1"), sum)
+	assert.Equal(t, MustParse("-0.9"), MustParse("0.1").Sub(sum))
+	assert.Equal(t, -1, MustParse("0.1").Cmp(sum))
+	assert.Equal(t, 1, sum.Sign())
+	assert.Equal(t, true, sum.Sub(sum).IsZero())
+	assert.Panics(t, func() { Amount{units: math.MaxInt64}.Add(MustParse("0.000001")) })
+	assert.Panics(t, func() { Amount{units: math.MinInt64}.Sub(MustParse("0.000001")) })
+	assert.Panics(t, func() { Amount{units: math.MaxInt64}.Sub(MustParse("-0.000001")) })
+}
+
+func TestJSON(t *testing.T) {
+	b, err := json.Marshal(map[string]Amount{"amount": MustParse("10.05")})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, `{"amount":10.05}`, string(b))
+
+	var decoded struct {
+		Number Amount `json:"number"`
+		Text   Amount `json:"text"`
+	}
+	err = json.Unmarshal([]byte(`{"number": 1.5, "text": "2.25"}`), &decoded)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, MustParse("1.5"), decoded.Number)
+	assert.Equal(t, MustParse("2.25"), decoded.Text)
+
+	for _, malformed := range []string{`"12`, `12"`, `""12""`, `"12"3`, `1e5`, `"1e5"`, `1.5E2`, `true`, `null`} {
+		var a Amount
+		err = a.UnmarshalJSON([]byte(malformed))
+		assert.NotEqual(t, nil, err, malformed)
+		var r Rate
+		err = r.UnmarshalJSON([]byte(malformed))
+		assert.NotEqual(t, nil, err, malformed)
+	}
+	err = json.Unmarshal([]byte(`{"number": 1e2}`), &decoded)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestConvert(t *testing.T) {
+	cases := []struct {
+		amount   string
+		rate     string
+		decimals int
+		expected string
+	}{
+		{"100", "0.9", 2, "90"},
+		{"10.01", "0.912345", 2, "9.13"},
+		{"0.05", "0.9", 2, "0.05"},
+		{"0.05", "0.7", 2, "0.04"},
+		{"-0.05", "0.9", 2, "-0.05"},
+		{"1000", "0.001234", 3, "1.234"},
+		{"3", "112.5", 0, "338"},
+		{"1.234567", "1", 6, "1.234567"},
+	}
+	for _, c := range cases {
+		converted, err := MustParse(c.amount).Convert(MustParse(c.rate), c.decimals)
+		assert.Equal(t, nil, err, c.amount)
+		assert.Equal(t, c.expected, converted.String(), c.amount)
+	}
+	_, err := MustParse("900000000000").Convert(MustParse("1000"), 2)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestExchange(t *testing.T) {
+	cases := []struct {
+		amount   string
+		rate     string
+		decimals int
+		expected string
+	}{
+		{"100", "0.9", 2, "90"},
+		// The rate digits beyond the amount scale still count.
+		{"1000000", "0.9123456789", 2, "912345.68"},
+		{"1", "0.000000123456", 6, "0"},
+		{"1000", "0.000000123456", 6, "0.000123"},
+		{"-0.05", "0.9", 2, "-0.05"},
+	}
+	for _, c := range cases {
+		converted, err := MustParse(c.amount).Exchange(MustParseRate(c.rate), c.decimals)
+		assert.Equal(t, nil, err, c.amount)
+		assert.Equal(t, c.expected, converted.String(), c.amount)
+	}
+	_, err := MustParse("900000000000").Exchange(MustParseRate("1000"), 2)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestParseRate(t *testing.T) {
+	rate, err := ParseRate("0.912345678901")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "0.912345678901", rate.String())
+	_, err = ParseRate("0.9123456789012")
+	assert.NotEqual(t, nil, err)
+	_, err = ParseRate("1000000")
+	assert.NotEqual(t, nil, err)
+}
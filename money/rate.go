@@ -0,0 +1,96 @@
+package money
+
+import (
+	"database/sql/driver"
+	"github.com/pkg/errors"
+)
+
+// RateScale is the number of decimal places every exchange rate is stored with.
+// It's higher than Scale, so converting large amounts doesn't lose precision.
+// Postgres columns holding rates are declared as numeric(18, 12) accordingly.
+const RateScale = 12
+
+// Rate is an exact decimal exchange rate, it's stored as a fixed-point integer number of 10^-RateScale units.
+type Rate struct {
+	units int64
+}
+
+// ParseRate parses a decimal rate, e.g. "0.912345678".
+// Exponent notation isn't supported.
+func ParseRate(s string) (Rate, error) {
+	units, err := parseUnits(s, RateScale, "rate")
+	if err != nil {
+		return Rate{}, err
+	}
+	return Rate{units: units}, nil
+}
+
+// MustParseRate is like ParseRate but panics if the rate is invalid, it's intended for constants and tests.
+func MustParseRate(s string) Rate {
+	r, err := ParseRate(s)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+func (r Rate) Sign() int {
+	switch {
+	case r.units > 0:
+		return 1
+	case r.units < 0:
+		return -1
+	}
+	return 0
+}
+
+// String formats the rate without insignificant trailing zeros, e.g. "0.9123".
+func (r Rate) String() string {
+	return formatUnits(r.units, RateScale)
+}
+
+// MarshalJSON encodes the rate as a JSON number, keeping it exact.
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON string or a plain decimal JSON number, numbers with an exponent are rejected.
+func (r *Rate) UnmarshalJSON(b []byte) error {
+	text, err := jsonDecimal(b)
+	if err != nil {
+		return err
+	}
+	parsed, err := ParseRate(text)
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (r *Rate) Scan(src interface{}) error {
+	var text string
+	switch v := src.(type) {
+	case []byte:
+		text = string(v)
+	case string:
+		text = v
+	case nil:
+		*r = Rate{}
+		return nil
+	default:
+		return errors.Errorf("can't scan %T into money.Rate", src)
+	}
+	parsed, err := ParseRate(text)
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+// Value implements the driver.Valuer interface.
+func (r Rate) Value() (driver.Value, error) {
+	return r.String(), nil
+}
@@ -0,0 +1,74 @@
+// Package outbox delivers events about payments to downstream systems.
+// Events are stored in the same database transaction as the changes they describe,
+// so an event is published if and only if its change has been committed.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/georgysavva/generic-wallet/payment"
+	"time"
+)
+
+// PaymentCompletedEvent is published for every saved transfer, including refunds,
+// its payload is the transfer with its payments.
+const PaymentCompletedEvent = "payment.completed"
+
+// Event is delivered at least once, consumers must deduplicate events by id.
+type Event struct {
+	// Id grows in the commit order of the stored events, events are delivered in the id order.
+	// Events of the same account are stored in the order of their payments, since the account is locked.
+	Id   int64  `json:"id"`
+	Type string `json:"type"`
+	// Key identifies the object the event is about, e.g. the transfer id.
+	Key       string          `json:"key"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+	// Attempts is the number of failed delivery attempts.
+	Attempts      int       `json:"-"`
+	LastError     string    `json:"-"`
+	NextAttemptAt time.Time `json:"-"`
+}
+
+func NewPaymentCompletedEvent(transfer *payment.Transfer) (*Event, error) {
+	payload, err := json.Marshal(transfer)
+	if err != nil {
+		return nil, err
+	}
+	return &Event{Type: PaymentCompletedEvent, Key: transfer.Id, Payload: payload}, nil
+}
+
+// Publisher delivers events to downstream systems.
+type Publisher interface {
+	// Publish returns an error if the event might not have been delivered, it's published again then.
+	Publish(ctx context.Context, event *Event) error
+}
+
+type Repository interface {
+	// ClaimPending returns up to limit undelivered events in the id order and claims them for claimTimeout,
+	// so only one relay delivers events at a time and their order is kept.
+	// It returns no events if other events are claimed or if the first undelivered event waits for a retry.
+	// Events claimed longer than claimTimeout ago are claimed again, since their relay might have crashed.
+	ClaimPending(ctx context.Context, limit int, claimTimeout time.Duration) ([]*Event, error)
+	// MarkPublished marks the claimed event as delivered.
+	MarkPublished(ctx context.Context, eventId int64) error
+	// MarkFailed records the failed delivery attempt of the event and releases all claimed events,
+	// so they are claimed again starting from the failed one at nextAttemptAt.
+	MarkFailed(ctx context.Context, eventId int64, deliveryErr error, nextAttemptAt time.Time) error
+	// MarkDead records the last failed delivery attempt of the claimed event and moves it to the dead letters.
+	// Dead events are kept for inspection, but they aren't delivered and don't hold back the later events.
+	MarkDead(ctx context.Context, eventId int64, deliveryErr error) error
+}
+
+// Backoff returns the delay before the next delivery attempt of an event, which has failed attempts times.
+// The delay doubles with every attempt starting from interval, but it doesn't exceed maxInterval.
+func Backoff(attempts int, interval, maxInterval time.Duration) time.Duration {
+	delay := interval
+	for i := 1; i < attempts && delay < maxInterval; i++ {
+		delay *= 2
+	}
+	if delay > maxInterval {
+		delay = maxInterval
+	}
+	return delay
+}
@@ -0,0 +1,69 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, Backoff(1, time.Second, time.Minute))
+	assert.Equal(t, 2*time.Second, Backoff(2, time.Second, time.Minute))
+	assert.Equal(t, 8*time.Second, Backoff(4, time.Second, time.Minute))
+	assert.Equal(t, time.Minute, Backoff(10, time.Second, time.Minute))
+	assert.Equal(t, time.Minute, Backoff(1000, time.Second, time.Minute))
+}
+
+func TestHTTPPublisher(t *testing.T) {
+	var received []*Event
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := &Event{}
+		json.NewDecoder(r.Body).Decode(event)
+		received = append(received, event)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	publisher := NewHTTPPublisher(server.URL, time.Second)
+	event := &Event{Id: 1, Type: PaymentCompletedEvent, Key: "transfer", Payload: json.RawMessage(`{"amount":"10"}`)}
+
+	assert.Equal(t, nil, publisher.Publish(context.Background(), event))
+	assert.Equal(t, 1, len(received))
+	assert.Equal(t, event.Key, received[0].Key)
+	assert.Equal(t, `{"amount":"10"}`, string(received[0].Payload))
+
+	status = http.StatusServiceUnavailable
+	assert.NotEqual(t, nil, publisher.Publish(context.Background(), event))
+}
+
+func TestFilePublisher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outbox")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.jsonl")
+	publisher, err := NewFilePublisher(path)
+	assert.Equal(t, nil, err)
+
+	for _, key := range []string{"first", "second"} {
+		event := &Event{Type: PaymentCompletedEvent, Key: key, Payload: json.RawMessage(`{}`)}
+		err = publisher.Publish(context.Background(), event)
+		assert.Equal(t, nil, err)
+	}
+	assert.Equal(t, nil, publisher.Close())
+
+	content, err := ioutil.ReadFile(path)
+	assert.Equal(t, nil, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	assert.Equal(t, 2, len(lines))
+	event := &Event{}
+	assert.Equal(t, nil, json.Unmarshal([]byte(lines[1]), event))
+	assert.Equal(t, "second", event.Key)
+}
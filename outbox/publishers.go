@@ -0,0 +1,96 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// LogPublisher writes events to the log, e.g. for development.
+type LogPublisher struct {
+	logger log.Logger
+}
+
+func NewLogPublisher(logger log.Logger) *LogPublisher {
+	return &LogPublisher{logger: logger}
+}
+
+func (p *LogPublisher) Publish(ctx context.Context, event *Event) error {
+	return p.logger.Log(
+		"msg", "Published event",
+		"event", event.Id,
+		"type", event.Type,
+		"key", event.Key,
+		"payload", string(event.Payload),
+	)
+}
+
+// HTTPPublisher posts events as JSON objects to a webhook, any response status but 2xx fails the delivery.
+type HTTPPublisher struct {
+	url    string
+	client *http.Client
+}
+
+func NewHTTPPublisher(url string, timeout time.Duration) *HTTPPublisher {
+	return &HTTPPublisher{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+func (p *HTTPPublisher) Publish(ctx context.Context, event *Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("event endpoint responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// FilePublisher appends events to a file as JSON lines, every event is synced to disk before it's delivered.
+type FilePublisher struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFilePublisher opens the file for appending, it's created if it doesn't exist.
+func NewFilePublisher(path string) (*FilePublisher, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FilePublisher{file: file}, nil
+}
+
+func (p *FilePublisher) Publish(ctx context.Context, event *Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err = p.file.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+	return p.file.Sync()
+}
+
+func (p *FilePublisher) Close() error {
+	return p.file.Close()
+}
@@ -2,7 +2,12 @@ package payment
 
 import (
 	"context"
+	"fmt"
+	"github.com/georgysavva/generic-wallet/account"
+	"github.com/georgysavva/generic-wallet/idempotency"
+	"github.com/georgysavva/generic-wallet/money"
 	"github.com/pkg/errors"
+	"time"
 )
 
 const (
@@ -11,17 +16,222 @@ const (
 )
 
 type Payment struct {
-	AccountId     string  `json:"account"`
-	ToAccountId   string  `json:"to_account,omitempty"`
-	FromAccountId string  `json:"from_account,omitempty"`
-	Amount        float64 `json:"amount"`
-	Direction     string  `json:"direction"`
+	Id int64 `json:"id"`
+	// TransferId links the outgoing and the incoming payments of a single transfer.
+	TransferId    string `json:"transfer_id"`
+	AccountId     string `json:"account"`
+	ToAccountId   string `json:"to_account,omitempty"`
+	FromAccountId string `json:"from_account,omitempty"`
+	// Amount is in the currency of the account,
+	// it's equal to the source amount for an outgoing payment and to the destination amount for an incoming one.
+	Amount money.Amount `json:"amount"`
+	// Fee is charged on top of the amount of an outgoing payment, it's zero for incoming ones.
+	Fee               money.Amount `json:"fee"`
+	SourceAmount      money.Amount `json:"source_amount"`
+	DestinationAmount money.Amount `json:"destination_amount"`
+	ExchangeRate      money.Rate   `json:"exchange_rate"`
+	Direction         string       `json:"direction"`
+	// RefundedTransferId is set for payments of a refund.
+	RefundedTransferId string `json:"refunded_transfer_id,omitempty"`
+	// RefundedAmount is the total refunded part of the source amount of the transfer.
+	RefundedAmount money.Amount `json:"refunded_amount"`
+	CreatedAt      time.Time    `json:"created_at"`
+}
+
+// Transfer is a movement of money between two accounts,
+// it's recorded as an outgoing payment of the source account and an incoming payment of the destination account.
+// If the accounts have different currencies, Amount is converted to DestinationAmount at ExchangeRate,
+// otherwise both amounts are equal and the rate is 1.
+// A refund is a transfer in the opposite direction linked to the refunded transfer,
+// it uses the rate of the refunded transfer: its DestinationAmount is converted to Amount at ExchangeRate.
+type Transfer struct {
+	Id                  string       `json:"id"`
+	FromAccountId       string       `json:"from_account"`
+	ToAccountId         string       `json:"to_account"`
+	Amount              money.Amount `json:"amount"`
+	Currency            string       `json:"currency"`
+	DestinationAmount   money.Amount `json:"destination_amount"`
+	DestinationCurrency string       `json:"destination_currency"`
+	ExchangeRate        money.Rate   `json:"exchange_rate"`
+	// Fee is charged from the source account in its currency on top of Amount, refunds don't return it.
+	Fee                money.Amount `json:"fee"`
+	RefundedTransferId string       `json:"refunded_transfer_id,omitempty"`
+	// Refunded totals, they never exceed Amount and DestinationAmount respectively.
+	RefundedAmount            money.Amount `json:"refunded_amount"`
+	RefundedDestinationAmount money.Amount `json:"refunded_destination_amount"`
+	CreatedAt                 time.Time    `json:"created_at"`
+	Payments                  []*Payment   `json:"payments"`
+	// SpendingLimits of the source account the transfer is checked against, they aren't stored.
+	// Nil means no limits, refunds never have them.
+	SpendingLimits *account.SpendingLimits `json:"-"`
+	// Replayed is set if the transfer is the stored response of an idempotent retry rather than a new transfer.
+	Replayed bool `json:"-" sql:"-"`
+}
+
+const (
+	// SortById is the default order, payments are returned in the order they were created.
+	SortById     = ""
+	SortByTime   = "time"
+	SortByAmount = "amount"
+)
+
+// Filter selects payments from the list, empty fields don't filter anything.
+type Filter struct {
+	AccountId string
+	// AccountIds restricts payments to the accounts if it's not empty, e.g. to the accounts of the caller.
+	AccountIds []string
+	// CounterpartyId is the other account of the payment: the destination of an outgoing payment
+	// or the source of an incoming one.
+	CounterpartyId string
+	Direction      string
+	// Amount range bounds are inclusive.
+	MinAmount *money.Amount
+	MaxAmount *money.Amount
+	// CreatedFrom is inclusive, CreatedTo is exclusive.
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+}
+
+// Sort defines the order of the payments list.
+// Payments with equal sort values are ordered by id in the same direction.
+type Sort struct {
+	By         string
+	Descending bool
 }
 
 var LowBalanceErr = errors.New("account doesn't have enough money to send the payment")
+var FromAccountClosedErr = errors.New("source account is closed")
+var ToAccountClosedErr = errors.New("destination account is closed")
+var FromAccountFrozenErr = errors.New("source account is frozen")
+var FromAccountSuspendedErr = errors.New("source account is suspended")
+var ToAccountSuspendedErr = errors.New("destination account is suspended")
+var RefundExceedsAmountErr = errors.New("refunds can't exceed the amount of the payment")
+
+// CheckFromAccountStatus returns the error preventing the account with the status from sending payments, if any.
+func CheckFromAccountStatus(status string) error {
+	switch status {
+	case account.ClosedStatus:
+		return FromAccountClosedErr
+	case account.FrozenStatus:
+		return FromAccountFrozenErr
+	case account.SuspendedStatus:
+		return FromAccountSuspendedErr
+	}
+	return nil
+}
+
+// CheckToAccountStatus returns the error preventing the account with the status from receiving payments, if any.
+func CheckToAccountStatus(status string) error {
+	switch status {
+	case account.ClosedStatus:
+		return ToAccountClosedErr
+	case account.SuspendedStatus:
+		return ToAccountSuspendedErr
+	}
+	return nil
+}
+
+// CheckBalance returns LowBalanceErr if spending the amount would take the available balance
+// below the negated overdraft limit.
+func CheckBalance(availableBalance, overdraftLimit, amount money.Amount) error {
+	if availableBalance.Add(overdraftLimit).Sub(amount).Sign() < 0 {
+		return LowBalanceErr
+	}
+	return nil
+}
+
+const (
+	PerTransactionLimit = "per_transaction"
+	DailyLimit          = "daily"
+	MonthlyLimit        = "monthly"
+)
+
+// LimitExceededError is returned if a transfer exceeds a spending limit of the source account.
+type LimitExceededError struct {
+	// Limit is the first exceeded one of PerTransactionLimit, DailyLimit and MonthlyLimit.
+	Limit string
+	// Remaining is the most the account can send now without exceeding any of its limits.
+	Remaining money.Amount
+}
+
+func (e *LimitExceededError) Error() string {
+	return fmt.Sprintf("%s spending limit of the source account is exceeded, %s can be sent", e.Limit, e.Remaining)
+}
+
+// CheckSpendingLimits returns *LimitExceededError if the amount exceeds the per transaction limit
+// or together with the money already spent within the limit windows exceeds the daily or the monthly limit.
+func CheckSpendingLimits(limits *account.SpendingLimits, amount, spentDaily, spentMonthly money.Amount) error {
+	if limits == nil {
+		return nil
+	}
+	checks := []struct {
+		limit string
+		value *money.Amount
+		spent money.Amount
+	}{
+		{PerTransactionLimit, limits.PerTransaction, money.Amount{}},
+		{DailyLimit, limits.Daily, spentDaily},
+		{MonthlyLimit, limits.Monthly, spentMonthly},
+	}
+	var exceeded *LimitExceededError
+	for _, check := range checks {
+		if check.value == nil {
+			continue
+		}
+		remaining := check.value.Sub(check.spent)
+		if remaining.Sign() < 0 {
+			remaining = money.Amount{}
+		}
+		if exceeded == nil && remaining.Cmp(amount) < 0 {
+			exceeded = &LimitExceededError{Limit: check.limit, Remaining: remaining}
+		}
+		if exceeded != nil && remaining.Cmp(exceeded.Remaining) < 0 {
+			exceeded.Remaining = remaining
+		}
+	}
+	if exceeded == nil {
+		return nil
+	}
+	return exceeded
+}
+
+// BatchItemError is the error of the transfer with the index that makes a batch fail.
+type BatchItemError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchItemError) Error() string {
+	return fmt.Sprintf("payment %d of the batch: %s", e.Index, e.Err)
+}
 
 type Repository interface {
-	GetAll(ctx context.Context, offset, limit *int) ([]*Payment, error)
-	CountAll(ctx context.Context) (int, error)
-	Save(ctx context.Context, fromAccountId, toAccountId string, amount float64) error
+	GetAll(ctx context.Context, filter Filter, sort Sort, offset, limit *int) ([]*Payment, error)
+	CountAll(ctx context.Context, filter Filter) (int, error)
+	// GetAllAfter returns up to limit payments following the after payment in the sort order.
+	// Only the id and the sort field of the after payment are used.
+	GetAllAfter(ctx context.Context, filter Filter, sort Sort, after *Payment, limit int) ([]*Payment, error)
+	// GetAllBefore returns up to limit payments preceding the before payment in the sort order.
+	// Only the id and the sort field of the before payment are used.
+	GetAllBefore(ctx context.Context, filter Filter, sort Sort, before *Payment, limit int) ([]*Payment, error)
+	// Get returns nil if the payment doesn't exist.
+	Get(ctx context.Context, paymentId int64) (*Payment, error)
+	// Save records the transfer as a ledger transaction, updates the account balances
+	// and stores idempotencyRecord (if any), all in the same database transaction.
+	// It fills in the creation time and the payments of the transfer
+	// and sets the idempotency record response to the transfer.
+	// It returns idempotency.KeyAlreadyUsedErr if a record with the same key already exists
+	// and the errors of CheckFromAccountStatus, CheckToAccountStatus, CheckBalance and CheckSpendingLimits
+	// if the accounts can't make the transfer.
+	Save(ctx context.Context, transfer *Transfer, idempotencyRecord *idempotency.Record) error
+	// GetTransfer returns nil if the transfer doesn't exist.
+	GetTransfer(ctx context.Context, transferId string) (*Transfer, error)
+	// SaveRefund executes the refund like Save does and adds its amounts to the refunded totals
+	// of the refunded transfer in the same transaction.
+	// It returns RefundExceedsAmountErr if the totals would exceed the amounts of the refunded transfer.
+	SaveRefund(ctx context.Context, refund *Transfer) error
+	// SaveBatch executes the transfers one by one like Save does, all in the same database transaction,
+	// so either all of them are saved or none. A transfer can spend money received by the previous ones.
+	// It returns *BatchItemError with the same errors as Save does if a transfer fails.
+	SaveBatch(ctx context.Context, transfers []*Transfer) error
 }
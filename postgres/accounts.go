@@ -4,25 +4,43 @@ import (
 	"context"
 	"github.com/georgysavva/generic-wallet/account"
 	"github.com/georgysavva/generic-wallet/config"
+	"github.com/georgysavva/generic-wallet/hold"
+	"github.com/georgysavva/generic-wallet/money"
 	"github.com/go-pg/pg"
 )
 
+// accountColumns select accounts with the available balance, which is the balance without active holds
+// and their fees.
+const accountColumns = "id,balance,currency,status,status_reason,overdraft_limit,spending_limits,tier," +
+	"balance-coalesce((select sum(h.amount+h.fee) from holds h where h.from_account_id=accounts.id " +
+	"and h.status='" + hold.ActiveStatus + "' and h.expires_at>now()),0) as available_balance"
+
 type AccountsRepository struct {
 	db *pg.DB
 }
 
 func NewAccountsRepository(settings *config.Postgres) (*AccountsRepository, error) {
-	db, err := connect(settings)
+	db, err := connect(settings, "accounts")
 	if err != nil {
 		return nil, err
 	}
 	return &AccountsRepository{db: db}, nil
 }
 
+// PoolStats returns the stats of the connection pool of the repository.
+func (ar *AccountsRepository) PoolStats() *pg.PoolStats {
+	return ar.db.PoolStats()
+}
+
+// Ping checks that the database is reachable with a connection of the repository pool.
+func (ar *AccountsRepository) Ping(ctx context.Context) error {
+	return ping(ctx, ar.db)
+}
+
 func (ar *AccountsRepository) GetAll(ctx context.Context, offset, limit *int) ([]*account.Account, error) {
 	var records []*account.Account
 	_, err := ar.db.QueryContext(ctx,
-		&records, "select id,balance,currency from accounts order by id offset ?0 limit ?1",
+		&records, "select "+accountColumns+" from accounts order by id offset ?0 limit ?1",
 		offset, limit,
 	)
 	return records, err
@@ -37,11 +55,168 @@ func (ar *AccountsRepository) CountAll(ctx context.Context) (int, error) {
 	return count, nil
 }
 
+func (ar *AccountsRepository) GetAllAfter(ctx context.Context, afterId string, limit int) ([]*account.Account, error) {
+	var records []*account.Account
+	_, err := ar.db.QueryContext(ctx,
+		&records, "select "+accountColumns+" from accounts where id>?0 order by id limit ?1",
+		afterId, limit,
+	)
+	return records, err
+}
+
+func (ar *AccountsRepository) GetAllBefore(ctx context.Context, beforeId string, limit int) ([]*account.Account, error) {
+	var records []*account.Account
+	_, err := ar.db.QueryContext(ctx,
+		&records,
+		"select * from (select "+accountColumns+" from accounts where id<?0 order by id desc limit ?1) as page "+
+			"order by id",
+		beforeId, limit,
+	)
+	return records, err
+}
+
 func (ar *AccountsRepository) Get(ctx context.Context, accountId string) (*account.Account, error) {
 	record := &account.Account{}
 	_, err := ar.db.QueryOneContext(ctx,
-		record, "select id,balance,currency from accounts where id=?0", accountId,
+		record, "select "+accountColumns+" from accounts where id=?0", accountId,
+	)
+	if err != nil {
+		if err == pg.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return record, nil
+}
+
+func (ar *AccountsRepository) Create(ctx context.Context, record *account.Account) error {
+	res, err := ar.db.ExecContext(ctx,
+		"insert into accounts (id,balance,currency,status,tier) values (?0,?1,?2,?3,?4) on conflict (id) do nothing",
+		record.Id, record.Balance, record.Currency, record.Status, record.Tier,
 	)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return account.AlreadyExistsErr
+	}
+	return nil
+}
+
+func (ar *AccountsRepository) Close(ctx context.Context, accountId string) (*account.Account, error) {
+	record := &account.Account{}
+	err := ar.db.RunInTransaction(func(tx *pg.Tx) error {
+		// Lock the account row, so a concurrent payment can't change the balance
+		// between the check and the update.
+		_, err := tx.QueryOneContext(ctx,
+			record, "select "+accountColumns+" from accounts where id=?0 for update", accountId,
+		)
+		if err != nil {
+			return err
+		}
+		if record.Status == account.ClosedStatus {
+			return nil
+		}
+		if !record.Balance.IsZero() {
+			return account.NonZeroBalanceErr
+		}
+		_, err = tx.ExecOneContext(ctx,
+			"update accounts set status=?0 where id=?1", account.ClosedStatus, accountId,
+		)
+		if err != nil {
+			return err
+		}
+		record.Status = account.ClosedStatus
+		return nil
+	})
+	if err != nil {
+		if err == pg.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return record, nil
+}
+
+func (ar *AccountsRepository) SetStatus(
+	ctx context.Context, accountId, status, reason string,
+) (*account.Account, error) {
+	record := &account.Account{}
+	err := ar.db.RunInTransaction(func(tx *pg.Tx) error {
+		// Lock the account row, so the status doesn't change in the middle of a payment.
+		_, err := tx.QueryOneContext(ctx,
+			record, "select "+accountColumns+" from accounts where id=?0 for update", accountId,
+		)
+		if err != nil {
+			return err
+		}
+		if record.Status == account.ClosedStatus {
+			return account.ClosedErr
+		}
+		_, err = tx.ExecOneContext(ctx,
+			"update accounts set status=?0,status_reason=?1 where id=?2", status, reason, accountId,
+		)
+		if err != nil {
+			return err
+		}
+		record.Status, record.StatusReason = status, reason
+		return nil
+	})
+	if err != nil {
+		if err == pg.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return record, nil
+}
+
+func (ar *AccountsRepository) SetOverdraftLimit(
+	ctx context.Context, accountId string, limit money.Amount,
+) (*account.Account, error) {
+	record, err := ar.updateOpenAccount(ctx, accountId, "overdraft_limit", limit)
+	if record != nil {
+		record.OverdraftLimit = limit
+	}
+	return record, err
+}
+
+func (ar *AccountsRepository) SetSpendingLimits(
+	ctx context.Context, accountId string, limits account.SpendingLimits,
+) (*account.Account, error) {
+	record, err := ar.updateOpenAccount(ctx, accountId, "spending_limits", limits)
+	if record != nil {
+		record.SpendingLimits = limits
+	}
+	return record, err
+}
+
+func (ar *AccountsRepository) SetTier(ctx context.Context, accountId, tier string) (*account.Account, error) {
+	record, err := ar.updateOpenAccount(ctx, accountId, "tier", tier)
+	if record != nil {
+		record.Tier = tier
+	}
+	return record, err
+}
+
+// updateOpenAccount sets the column of the account unless it's closed and returns the account read before the update.
+func (ar *AccountsRepository) updateOpenAccount(
+	ctx context.Context, accountId, column string, value interface{},
+) (*account.Account, error) {
+	var record *account.Account
+	err := ar.db.RunInTransaction(func(tx *pg.Tx) error {
+		// Lock the account row, so the column doesn't change in the middle of a payment.
+		var err error
+		record, err = lockAccount(ctx, tx, accountId)
+		if err != nil {
+			return err
+		}
+		if record.Status == account.ClosedStatus {
+			return account.ClosedErr
+		}
+		_, err = tx.ExecOneContext(ctx, "update accounts set "+column+"=?0 where id=?1", value, accountId)
+		return err
+	})
 	if err != nil {
 		if err == pg.ErrNoRows {
 			return nil, nil
@@ -50,3 +225,31 @@ func (ar *AccountsRepository) Get(ctx context.Context, accountId string) (*accou
 	}
 	return record, nil
 }
+
+// lockAccount locks the account row until the end of the database transaction and returns the account.
+// The account is read by a separate statement after the lock is acquired, since a locking statement
+// computes the available balance from the snapshot taken before it waited for the lock
+// and would miss the holds and the payments committed in the meantime.
+// The available balance can't change while the row is locked, since holds are created and payments are made
+// only under the lock of the source account.
+func lockAccount(ctx context.Context, tx *pg.Tx, accountId string) (*account.Account, error) {
+	err := lockAccounts(ctx, tx, accountId)
+	if err != nil {
+		return nil, err
+	}
+	return readLockedAccount(ctx, tx, accountId)
+}
+
+// readLockedAccount returns the account, its row must have been locked by a previous statement of the transaction.
+func readLockedAccount(ctx context.Context, tx *pg.Tx, accountId string) (*account.Account, error) {
+	record := &account.Account{}
+	_, err := tx.QueryOneContext(ctx, record, "select "+accountColumns+" from accounts where id=?0", accountId)
+	return record, err
+}
+
+// lockAccounts locks the account rows in the id order until the end of the database transaction.
+// Transactions updating several accounts lock them with it first, so they don't deadlock with each other.
+func lockAccounts(ctx context.Context, tx *pg.Tx, accountIds ...string) error {
+	_, err := tx.ExecContext(ctx, "select id from accounts where id in (?0) order by id for update", pg.In(accountIds))
+	return err
+}
@@ -1,26 +1,254 @@
 CREATE TABLE public.accounts
 (
     id text PRIMARY KEY NOT NULL,
-    balance float DEFAULT 0 NOT NULL,
-    currency text DEFAULT 'USD' NOT NULL
+    balance numeric(18, 6) DEFAULT 0 NOT NULL,
+    currency text DEFAULT 'USD' NOT NULL,
+    status text DEFAULT 'active' NOT NULL,
+    -- Explains the last status change, e.g. why the account has been frozen.
+    status_reason text DEFAULT '' NOT NULL,
+    -- How far below zero the balance may go, payments are checked against it under the account row lock.
+    overdraft_limit numeric(18, 6) DEFAULT 0 NOT NULL,
+    -- Spending limits set for the account specifically, e.g. {"per_transaction": 100, "daily": 500}.
+    spending_limits jsonb DEFAULT '{}' NOT NULL,
+    -- Selects the fee rules applied to the payments of the account.
+    tier text DEFAULT 'standard' NOT NULL,
+    CONSTRAINT accounts_overdraft_limit_check CHECK (overdraft_limit >= 0)
 );
 
-CREATE TABLE public.payments
+-- The ledger is append-only, every movement of money is a transaction of postings summing to zero per currency.
+-- accounts.balance is kept in sync with the postings in the same database transaction.
+CREATE TABLE public.ledger_transactions
 (
-    id serial PRIMARY KEY NOT NULL,
-    account_id text NOT NULL,
-    to_account_id text,
+    id text PRIMARY KEY NOT NULL,
+    kind text NOT NULL,
+    -- Transfer details, they are set only for transfer and refund transactions.
     from_account_id text,
-    amount float NOT NULL,
-    direction text NOT NULL,
-    CONSTRAINT payments_accounts_id_fk FOREIGN KEY (account_id) REFERENCES public.accounts (id) ON DELETE CASCADE,
-    CONSTRAINT payments_accounts_id_fk_2 FOREIGN KEY (to_account_id) REFERENCES public.accounts (id) ON DELETE CASCADE,
-    CONSTRAINT payments_accounts_id_fk_3 FOREIGN KEY (from_account_id) REFERENCES public.accounts (id) ON DELETE CASCADE
+    to_account_id text,
+    source_amount numeric(18, 6),
+    source_currency text,
+    destination_amount numeric(18, 6),
+    destination_currency text,
+    exchange_rate numeric(18, 12),
+    -- Set for refunds.
+    refunded_transfer_id text,
+    -- Charged from the source account on top of source_amount.
+    fee numeric(18, 6) DEFAULT 0 NOT NULL,
+    -- Refunded totals of a transfer.
+    refunded_amount numeric(18, 6) DEFAULT 0 NOT NULL,
+    refunded_destination_amount numeric(18, 6) DEFAULT 0 NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    CONSTRAINT ledger_transactions_accounts_id_fk FOREIGN KEY (from_account_id) REFERENCES public.accounts (id),
+    CONSTRAINT ledger_transactions_accounts_id_fk_2 FOREIGN KEY (to_account_id) REFERENCES public.accounts (id),
+    CONSTRAINT ledger_transactions_refunded_transfer_id_fk
+        FOREIGN KEY (refunded_transfer_id) REFERENCES public.ledger_transactions (id),
+    CONSTRAINT ledger_transactions_refunds_check
+        CHECK (refunded_amount <= source_amount AND refunded_destination_amount <= destination_amount)
+);
+
+CREATE INDEX ledger_transactions_refunded_transfer_id_index ON public.ledger_transactions (refunded_transfer_id);
+-- Spending limits sum the recent transfers of the source account.
+CREATE INDEX ledger_transactions_from_account_id_index ON public.ledger_transactions (from_account_id, created_at);
+
+-- Postings reference either regular accounts or system accounts, which have ids starting with '@'
+-- and no rows in the accounts table, so account_id has no foreign key.
+CREATE TABLE public.postings
+(
+    id bigserial PRIMARY KEY NOT NULL,
+    transaction_id text NOT NULL,
+    account_id text NOT NULL,
+    currency text NOT NULL,
+    -- Positive for credits and negative for debits.
+    amount numeric(18, 6) NOT NULL CHECK (amount <> 0),
+    -- The absolute amount without the fee the source account is debited with in the same posting,
+    -- it's the amount of the payment, so payments are filtered and sorted by it with an index.
+    net_amount numeric(18, 6) NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    CONSTRAINT postings_ledger_transactions_id_fk FOREIGN KEY (transaction_id) REFERENCES public.ledger_transactions (id)
+);
+
+CREATE INDEX postings_account_id_index ON public.postings (account_id, id);
+CREATE INDEX postings_transaction_id_index ON public.postings (transaction_id);
+CREATE INDEX postings_created_at_index ON public.postings (created_at, id);
+CREATE INDEX postings_net_amount_index ON public.postings (net_amount, id);
+
+-- Checked at commit, so all postings of a transaction are inserted by then.
+CREATE FUNCTION public.check_transaction_balanced() RETURNS trigger AS
+$$
+BEGIN
+    IF EXISTS(SELECT 1
+              FROM public.postings
+              WHERE transaction_id = NEW.transaction_id
+              GROUP BY currency
+              HAVING sum(amount) <> 0) THEN
+        RAISE EXCEPTION 'ledger transaction % is unbalanced', NEW.transaction_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER postings_balanced
+    AFTER INSERT
+    ON public.postings
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW
+EXECUTE PROCEDURE public.check_transaction_balanced();
+
+CREATE FUNCTION public.forbid_postings_change() RETURNS trigger AS
+$$
+BEGIN
+    RAISE EXCEPTION 'postings can''t be changed or deleted';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER postings_append_only
+    BEFORE UPDATE OR DELETE
+    ON public.postings
+    FOR EACH ROW
+EXECUTE PROCEDURE public.forbid_postings_change();
+
+-- Payments are postings of transfers and refunds to regular accounts.
+CREATE VIEW public.payments AS
+SELECT p.id,
+       p.transaction_id AS transfer_id,
+       p.account_id,
+       CASE WHEN p.amount < 0 THEN t.to_account_id END AS to_account_id,
+       CASE WHEN p.amount > 0 THEN t.from_account_id END AS from_account_id,
+       -- The fee the source account is debited with in the same posting isn't a part of the amount.
+       p.net_amount AS amount,
+       CASE WHEN p.amount < 0 THEN t.fee ELSE 0 END AS fee,
+       t.source_amount,
+       t.destination_amount,
+       t.exchange_rate,
+       CASE WHEN p.amount < 0 THEN 'outgoing' ELSE 'incoming' END AS direction,
+       t.refunded_transfer_id,
+       t.refunded_amount,
+       p.created_at
+FROM public.postings p
+         JOIN public.ledger_transactions t ON t.id = p.transaction_id
+WHERE t.kind IN ('transfer', 'refund')
+  AND p.account_id NOT LIKE '@%';
+
+-- Active holds past expires_at are expired, their status isn't updated.
+CREATE TABLE public.holds
+(
+    id text PRIMARY KEY NOT NULL,
+    from_account_id text NOT NULL,
+    to_account_id text NOT NULL,
+    amount numeric(18, 6) NOT NULL,
+    fee numeric(18, 6) DEFAULT 0 NOT NULL,
+    captured_amount numeric(18, 6) DEFAULT 0 NOT NULL,
+    transfer_id text,
+    status text NOT NULL,
+    expires_at timestamp with time zone NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    CONSTRAINT holds_accounts_id_fk FOREIGN KEY (from_account_id) REFERENCES public.accounts (id),
+    CONSTRAINT holds_accounts_id_fk_2 FOREIGN KEY (to_account_id) REFERENCES public.accounts (id),
+    CONSTRAINT holds_ledger_transactions_id_fk FOREIGN KEY (transfer_id) REFERENCES public.ledger_transactions (id)
 );
 
+CREATE INDEX holds_from_account_id_index ON public.holds (from_account_id) WHERE status = 'active';
+
+-- Pending and executing payments are claimed by workers, claimed_at is the time of the last claim.
+CREATE TABLE public.scheduled_payments
+(
+    id text PRIMARY KEY NOT NULL,
+    from_account_id text NOT NULL,
+    to_account_id text NOT NULL,
+    amount numeric(18, 6) NOT NULL,
+    execute_at timestamp with time zone NOT NULL,
+    status text NOT NULL,
+    transfer_id text,
+    failure_reason text,
+    claimed_at timestamp with time zone,
+    executed_at timestamp with time zone,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    CONSTRAINT scheduled_payments_accounts_id_fk FOREIGN KEY (from_account_id) REFERENCES public.accounts (id),
+    CONSTRAINT scheduled_payments_accounts_id_fk_2 FOREIGN KEY (to_account_id) REFERENCES public.accounts (id),
+    CONSTRAINT scheduled_payments_ledger_transactions_id_fk
+        FOREIGN KEY (transfer_id) REFERENCES public.ledger_transactions (id)
+);
+
+CREATE INDEX scheduled_payments_execute_at_index ON public.scheduled_payments (execute_at)
+    WHERE status IN ('pending', 'executing');
+CREATE INDEX scheduled_payments_from_account_id_index ON public.scheduled_payments (from_account_id);
+CREATE INDEX scheduled_payments_to_account_id_index ON public.scheduled_payments (to_account_id);
+
+-- claimed_at is the time an active order has been claimed by a worker, it's reset when the worker is done.
+CREATE TABLE public.standing_orders
+(
+    id text PRIMARY KEY NOT NULL,
+    from_account_id text NOT NULL,
+    to_account_id text NOT NULL,
+    amount numeric(18, 6) NOT NULL,
+    frequency text NOT NULL,
+    start_at timestamp with time zone NOT NULL,
+    end_at timestamp with time zone,
+    count integer,
+    next_run_at timestamp with time zone NOT NULL,
+    occurrences integer DEFAULT 0 NOT NULL,
+    attempts integer DEFAULT 0 NOT NULL,
+    failed_occurrences integer DEFAULT 0 NOT NULL,
+    last_transfer_id text,
+    last_failure_reason text,
+    status text NOT NULL,
+    claimed_at timestamp with time zone,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    CONSTRAINT standing_orders_accounts_id_fk FOREIGN KEY (from_account_id) REFERENCES public.accounts (id),
+    CONSTRAINT standing_orders_accounts_id_fk_2 FOREIGN KEY (to_account_id) REFERENCES public.accounts (id),
+    CONSTRAINT standing_orders_ledger_transactions_id_fk
+        FOREIGN KEY (last_transfer_id) REFERENCES public.ledger_transactions (id)
+);
+
+CREATE INDEX standing_orders_next_run_at_index ON public.standing_orders (next_run_at) WHERE status = 'active';
+CREATE INDEX standing_orders_from_account_id_index ON public.standing_orders (from_account_id);
+CREATE INDEX standing_orders_to_account_id_index ON public.standing_orders (to_account_id);
+
+-- Keys are scoped by the caller, e.g. the authenticated principal, so keys of different callers don't collide.
+CREATE TABLE public.idempotency_keys
+(
+    scope text NOT NULL,
+    key text NOT NULL,
+    fingerprint text NOT NULL,
+    response jsonb NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    PRIMARY KEY (scope, key)
+);
+
+-- Events are written in the transactions of the changes they describe and delivered by relays in the id order.
+-- claimed_at is the time an undelivered event has been claimed by a relay, it's reset when the delivery fails.
+CREATE TABLE public.outbox_events
+(
+    id bigserial PRIMARY KEY NOT NULL,
+    type text NOT NULL,
+    key text NOT NULL,
+    payload jsonb NOT NULL,
+    attempts integer DEFAULT 0 NOT NULL,
+    last_error text,
+    next_attempt_at timestamp with time zone DEFAULT now() NOT NULL,
+    claimed_at timestamp with time zone,
+    published_at timestamp with time zone,
+    -- Set for events moved to the dead letters after too many failed deliveries, they aren't delivered anymore.
+    dead_at timestamp with time zone,
+    created_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+CREATE INDEX outbox_events_id_index ON public.outbox_events (id) WHERE published_at IS NULL AND dead_at IS NULL;
+
 INSERT INTO accounts
 VALUES ('alice', 100.0, 'USD'),
        ('bob', 100.0, 'USD'),
        ('mark', 100.0, 'USD'),
        ('john', 100.0, 'USD'),
        ('kate_in_europe', 100.0, 'EUR');
+
+INSERT INTO ledger_transactions (id, kind)
+VALUES ('opening_balances', 'opening');
+
+INSERT INTO postings (transaction_id, account_id, currency, amount, net_amount)
+VALUES ('opening_balances', 'alice', 'USD', 100.0, 100.0),
+       ('opening_balances', 'bob', 'USD', 100.0, 100.0),
+       ('opening_balances', 'mark', 'USD', 100.0, 100.0),
+       ('opening_balances', 'john', 'USD', 100.0, 100.0),
+       ('opening_balances', 'kate_in_europe', 'EUR', 100.0, 100.0),
+       ('opening_balances', '@equity:USD', 'USD', -400.0, 400.0),
+       ('opening_balances', '@equity:EUR', 'EUR', -100.0, 100.0);
@@ -0,0 +1,177 @@
+package postgres
+
+import (
+	"context"
+	"github.com/georgysavva/generic-wallet/config"
+	"github.com/georgysavva/generic-wallet/hold"
+	"github.com/georgysavva/generic-wallet/ledger"
+	"github.com/georgysavva/generic-wallet/payment"
+	"github.com/go-pg/pg"
+)
+
+// holdColumns select holds reporting active holds past their expiration time as expired.
+const holdColumns = "id,from_account_id,to_account_id,amount,fee,captured_amount,transfer_id,expires_at,created_at," +
+	"case when status='" + hold.ActiveStatus + "' and expires_at<=now() then '" + hold.ExpiredStatus + "' " +
+	"else status end as status"
+
+type HoldsRepository struct {
+	db *pg.DB
+}
+
+func NewHoldsRepository(settings *config.Postgres) (*HoldsRepository, error) {
+	db, err := connect(settings, "holds")
+	if err != nil {
+		return nil, err
+	}
+	return &HoldsRepository{db: db}, nil
+}
+
+// PoolStats returns the stats of the connection pool of the repository.
+func (hr *HoldsRepository) PoolStats() *pg.PoolStats {
+	return hr.db.PoolStats()
+}
+
+// Ping checks that the database is reachable with a connection of the repository pool.
+func (hr *HoldsRepository) Ping(ctx context.Context) error {
+	return ping(ctx, hr.db)
+}
+
+func (hr *HoldsRepository) Create(ctx context.Context, record *hold.Hold) error {
+	err := hr.db.RunInTransaction(func(tx *pg.Tx) error {
+		// The source account is locked the same way as for payments,
+		// so a concurrent payment can't spend the money being reserved.
+		fromAccount, err := lockAccount(ctx, tx, record.FromAccountId)
+		if err != nil {
+			return err
+		}
+		err = payment.CheckFromAccountStatus(fromAccount.Status)
+		if err != nil {
+			return err
+		}
+		err = payment.CheckBalance(fromAccount.AvailableBalance, fromAccount.OverdraftLimit, record.ReservedAmount())
+		if err != nil {
+			return err
+		}
+		if record.SpendingLimits != nil {
+			spentDaily, spentMonthly, err := spentAmounts(ctx, tx, record.FromAccountId)
+			if err != nil {
+				return err
+			}
+			err = payment.CheckSpendingLimits(record.SpendingLimits, record.Amount, spentDaily, spentMonthly)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = tx.QueryOneContext(ctx,
+			pg.Scan(&record.CreatedAt),
+			"insert into holds (id,from_account_id,to_account_id,amount,fee,status,expires_at) "+
+				"values (?0,?1,?2,?3,?4,?5,?6) returning created_at",
+			record.Id, record.FromAccountId, record.ToAccountId, record.Amount, record.Fee, record.Status,
+			record.ExpiresAt,
+		)
+		return err
+	})
+	return err
+}
+
+func (hr *HoldsRepository) Get(ctx context.Context, holdId string) (*hold.Hold, error) {
+	record := &hold.Hold{}
+	_, err := hr.db.QueryOneContext(ctx, record, "select "+holdColumns+" from holds where id=?0", holdId)
+	if err != nil {
+		if err == pg.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return record, nil
+}
+
+func (hr *HoldsRepository) Capture(ctx context.Context, holdId string, transfer *payment.Transfer) (*hold.Hold, error) {
+	ledgerTransaction := ledger.NewTransferTransaction(transfer)
+	err := ledgerTransaction.Validate()
+	if err != nil {
+		return nil, err
+	}
+	record := &hold.Hold{}
+	err = hr.db.RunInTransaction(func(tx *pg.Tx) error {
+		err := lockActiveHold(ctx, tx, holdId, record)
+		if err != nil {
+			return err
+		}
+		if transfer.Amount.Cmp(record.Amount) > 0 {
+			return hold.CaptureExceedsAmountErr
+		}
+		// The hold stops reserving money before the transfer checks the available balance,
+		// so the transfer can spend the money the hold has reserved.
+		_, err = tx.ExecOneContext(ctx,
+			"update holds set status=?0,captured_amount=?1 where id=?2",
+			hold.CapturedStatus, transfer.Amount, holdId,
+		)
+		if err != nil {
+			return err
+		}
+		err = saveTransfer(ctx, tx, transfer, ledgerTransaction)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecOneContext(ctx, "update holds set transfer_id=?0 where id=?1", transfer.Id, holdId)
+		if err != nil {
+			return err
+		}
+		err = savePaymentCompletedEvents(ctx, tx, transfer)
+		if err != nil {
+			return err
+		}
+		record.Status, record.CapturedAmount, record.TransferId = hold.CapturedStatus, transfer.Amount, transfer.Id
+		return nil
+	})
+	if err != nil {
+		if err == pg.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return record, nil
+}
+
+func (hr *HoldsRepository) Void(ctx context.Context, holdId string) (*hold.Hold, error) {
+	record := &hold.Hold{}
+	err := hr.db.RunInTransaction(func(tx *pg.Tx) error {
+		err := lockActiveHold(ctx, tx, holdId, record)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecOneContext(ctx, "update holds set status=?0 where id=?1", hold.VoidedStatus, holdId)
+		if err != nil {
+			return err
+		}
+		record.Status = hold.VoidedStatus
+		return nil
+	})
+	if err != nil {
+		if err == pg.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return record, nil
+}
+
+// lockActiveHold locks the hold row until the end of the database transaction and reads it into the record.
+// It returns pg.ErrNoRows if the hold doesn't exist.
+func lockActiveHold(ctx context.Context, tx *pg.Tx, holdId string, record *hold.Hold) error {
+	_, err := tx.QueryOneContext(ctx,
+		record, "select * from (select "+holdColumns+" from holds where id=?0 for update) as h", holdId,
+	)
+	if err != nil {
+		return err
+	}
+	switch record.Status {
+	case hold.ActiveStatus:
+		return nil
+	case hold.ExpiredStatus:
+		return hold.ExpiredErr
+	default:
+		return hold.NotActiveErr
+	}
+}
@@ -0,0 +1,62 @@
+package postgres
+
+import (
+	"context"
+	"github.com/georgysavva/generic-wallet/config"
+	"github.com/georgysavva/generic-wallet/idempotency"
+	"github.com/go-pg/pg"
+)
+
+type IdempotencyRepository struct {
+	db *pg.DB
+}
+
+func NewIdempotencyRepository(settings *config.Postgres) (*IdempotencyRepository, error) {
+	db, err := connect(settings, "idempotency")
+	if err != nil {
+		return nil, err
+	}
+	return &IdempotencyRepository{db: db}, nil
+}
+
+// PoolStats returns the stats of the connection pool of the repository.
+func (ir *IdempotencyRepository) PoolStats() *pg.PoolStats {
+	return ir.db.PoolStats()
+}
+
+// Ping checks that the database is reachable with a connection of the repository pool.
+func (ir *IdempotencyRepository) Ping(ctx context.Context) error {
+	return ping(ctx, ir.db)
+}
+
+func (ir *IdempotencyRepository) Get(ctx context.Context, scope, key string) (*idempotency.Record, error) {
+	record := &idempotency.Record{}
+	_, err := ir.db.QueryOneContext(ctx,
+		record, "select scope,key,fingerprint,response from idempotency_keys where scope=?0 and key=?1", scope, key,
+	)
+	if err != nil {
+		if err == pg.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return record, nil
+}
+
+// saveIdempotencyRecord must be called inside the transaction that performs the request,
+// so the record is stored only if the request succeeds.
+// If a concurrent transaction has inserted the same scope and key, the insert waits for it to finish.
+func saveIdempotencyRecord(ctx context.Context, tx *pg.Tx, record *idempotency.Record) error {
+	res, err := tx.ExecContext(ctx,
+		"insert into idempotency_keys (scope,key,fingerprint,response) values (?0,?1,?2,?3) "+
+			"on conflict (scope,key) do nothing",
+		record.Scope, record.Key, record.Fingerprint, record.Response,
+	)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return idempotency.KeyAlreadyUsedErr
+	}
+	return nil
+}
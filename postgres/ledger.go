@@ -0,0 +1,120 @@
+package postgres
+
+import (
+	"context"
+	"github.com/georgysavva/generic-wallet/config"
+	"github.com/georgysavva/generic-wallet/ledger"
+	"github.com/georgysavva/generic-wallet/money"
+	"github.com/georgysavva/generic-wallet/payment"
+	"github.com/go-pg/pg"
+)
+
+type LedgerRepository struct {
+	db *pg.DB
+}
+
+func NewLedgerRepository(settings *config.Postgres) (*LedgerRepository, error) {
+	db, err := connect(settings, "ledger")
+	if err != nil {
+		return nil, err
+	}
+	return &LedgerRepository{db: db}, nil
+}
+
+// PoolStats returns the stats of the connection pool of the repository.
+func (lr *LedgerRepository) PoolStats() *pg.PoolStats {
+	return lr.db.PoolStats()
+}
+
+// Ping checks that the database is reachable with a connection of the repository pool.
+func (lr *LedgerRepository) Ping(ctx context.Context) error {
+	return ping(ctx, lr.db)
+}
+
+func (lr *LedgerRepository) GetTransaction(ctx context.Context, transactionId string) (*ledger.Transaction, error) {
+	record := &ledger.Transaction{}
+	_, err := lr.db.QueryOneContext(ctx,
+		record, "select id,kind,created_at from ledger_transactions where id=?0", transactionId,
+	)
+	if err != nil {
+		if err == pg.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	_, err = lr.db.QueryContext(ctx,
+		&record.Postings,
+		"select id,transaction_id,account_id,currency,amount,created_at from postings where transaction_id=?0 order by id",
+		transactionId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (lr *LedgerRepository) GetDiscrepancies(ctx context.Context) ([]*ledger.Discrepancy, error) {
+	var records []*ledger.Discrepancy
+	_, err := lr.db.QueryContext(ctx,
+		&records,
+		"select a.id as account_id,a.balance,coalesce(sum(p.amount),0) as ledger_balance "+
+			"from accounts a left join postings p on p.account_id=a.id "+
+			"group by a.id,a.balance having a.balance<>coalesce(sum(p.amount),0) order by a.id",
+	)
+	return records, err
+}
+
+// saveLedgerTransaction inserts the transaction with its postings inside a database transaction
+// and fills in their ids and creation time.
+// The details of the transfer, if it's a transfer transaction, are stored for the payments view.
+// Postings are checked to sum to zero on commit.
+func saveLedgerTransaction(
+	ctx context.Context, tx *pg.Tx, transaction *ledger.Transaction, transfer *payment.Transfer,
+) error {
+	// Transfer details stay null for other transactions, their fee is zero.
+	var fee money.Amount
+	details := make([]interface{}, 8)
+	if transfer != nil {
+		fee = transfer.Fee
+		details = []interface{}{
+			transfer.FromAccountId, transfer.ToAccountId,
+			transfer.Amount, transfer.Currency, transfer.DestinationAmount, transfer.DestinationCurrency,
+			transfer.ExchangeRate, nil,
+		}
+		if transfer.RefundedTransferId != "" {
+			details[7] = transfer.RefundedTransferId
+		}
+	}
+	_, err := tx.QueryOneContext(ctx,
+		pg.Scan(&transaction.CreatedAt),
+		"insert into ledger_transactions "+
+			"(id,kind,from_account_id,to_account_id,source_amount,source_currency,"+
+			"destination_amount,destination_currency,exchange_rate,refunded_transfer_id,fee) "+
+			"values (?,?,?,?,?,?,?,?,?,?,?) returning created_at",
+		append(append([]interface{}{transaction.Id, transaction.Kind}, details...), fee)...,
+	)
+	if err != nil {
+		return err
+	}
+	for _, posting := range transaction.Postings {
+		posting.CreatedAt = transaction.CreatedAt
+		netAmount := posting.Amount
+		if netAmount.Sign() < 0 {
+			netAmount = netAmount.Neg()
+		}
+		if transfer != nil && posting.AccountId == transfer.FromAccountId && posting.Amount.Sign() < 0 {
+			// The source account is debited with the fee in the same posting.
+			netAmount = netAmount.Sub(fee)
+		}
+		_, err = tx.QueryOneContext(ctx,
+			pg.Scan(&posting.Id),
+			"insert into postings (transaction_id,account_id,currency,amount,net_amount,created_at) "+
+				"values (?0,?1,?2,?3,?4,?5) returning id",
+			transaction.Id, posting.AccountId, posting.Currency, posting.Amount, netAmount, posting.CreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
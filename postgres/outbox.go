@@ -0,0 +1,142 @@
+package postgres
+
+import (
+	"context"
+	"github.com/georgysavva/generic-wallet/config"
+	"github.com/georgysavva/generic-wallet/outbox"
+	"github.com/georgysavva/generic-wallet/payment"
+	"github.com/go-pg/pg"
+	"time"
+)
+
+const outboxEventColumns = "id,type,key,payload,attempts,last_error,next_attempt_at,created_at"
+
+// outboxClaimLock is the key of the advisory lock that serializes claims of the outbox events.
+const outboxClaimLock = 4201
+
+// outboxInsertLock is the key of the advisory lock that serializes inserts of the outbox events.
+const outboxInsertLock = 4202
+
+type OutboxRepository struct {
+	db *pg.DB
+}
+
+func NewOutboxRepository(settings *config.Postgres) (*OutboxRepository, error) {
+	db, err := connect(settings, "outbox")
+	if err != nil {
+		return nil, err
+	}
+	return &OutboxRepository{db: db}, nil
+}
+
+// PoolStats returns the stats of the connection pool of the repository.
+func (or *OutboxRepository) PoolStats() *pg.PoolStats {
+	return or.db.PoolStats()
+}
+
+// Ping checks that the database is reachable with a connection of the repository pool.
+func (or *OutboxRepository) Ping(ctx context.Context) error {
+	return ping(ctx, or.db)
+}
+
+func (or *OutboxRepository) ClaimPending(
+	ctx context.Context, limit int, claimTimeout time.Duration,
+) ([]*outbox.Event, error) {
+	var records []*outbox.Event
+	err := or.db.RunInTransaction(func(tx *pg.Tx) error {
+		// Relays of different service replicas claim one after another,
+		// so the events are never delivered by two relays at once and their order is kept.
+		_, err := tx.ExecContext(ctx, "select pg_advisory_xact_lock(?0)", outboxClaimLock)
+		if err != nil {
+			return err
+		}
+		var blocked bool
+		_, err = tx.QueryOneContext(ctx,
+			pg.Scan(&blocked),
+			"select exists(select 1 from outbox_events "+
+				"where published_at is null and dead_at is null and claimed_at>now()-?0*interval '1 millisecond') "+
+				"or coalesce((select next_attempt_at>now() from outbox_events "+
+				"where published_at is null and dead_at is null order by id limit 1), false)",
+			claimTimeout/time.Millisecond,
+		)
+		if err != nil || blocked {
+			return err
+		}
+		_, err = tx.QueryContext(ctx,
+			&records,
+			"with claimed as (update outbox_events set claimed_at=now() where id in ("+
+				"select id from outbox_events where published_at is null and dead_at is null order by id limit ?0"+
+				") returning "+outboxEventColumns+") "+
+				"select "+outboxEventColumns+" from claimed order by id",
+			limit,
+		)
+		return err
+	})
+	return records, err
+}
+
+func (or *OutboxRepository) MarkPublished(ctx context.Context, eventId int64) error {
+	_, err := or.db.ExecOneContext(ctx,
+		"update outbox_events set published_at=now(),claimed_at=null where id=?0", eventId,
+	)
+	return err
+}
+
+func (or *OutboxRepository) MarkFailed(
+	ctx context.Context, eventId int64, deliveryErr error, nextAttemptAt time.Time,
+) error {
+	return or.db.RunInTransaction(func(tx *pg.Tx) error {
+		_, err := tx.ExecOneContext(ctx,
+			"update outbox_events set attempts=attempts+1,last_error=?0,next_attempt_at=?1 where id=?2",
+			deliveryErr.Error(), nextAttemptAt, eventId,
+		)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx,
+			"update outbox_events set claimed_at=null where published_at is null and claimed_at is not null",
+		)
+		return err
+	})
+}
+
+func (or *OutboxRepository) MarkDead(ctx context.Context, eventId int64, deliveryErr error) error {
+	_, err := or.db.ExecOneContext(ctx,
+		"update outbox_events set attempts=attempts+1,last_error=?0,dead_at=now(),claimed_at=null where id=?1",
+		deliveryErr.Error(), eventId,
+	)
+	return err
+}
+
+// savePaymentCompletedEvents stores the events of the saved transfers, see saveOutboxEvent.
+func savePaymentCompletedEvents(ctx context.Context, tx *pg.Tx, transfers ...*payment.Transfer) error {
+	for _, transfer := range transfers {
+		event, err := outbox.NewPaymentCompletedEvent(transfer)
+		if err != nil {
+			return err
+		}
+		err = saveOutboxEvent(ctx, tx, event)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveOutboxEvent must be called inside the transaction that makes the change the event is about,
+// so the event is stored if and only if the change is committed.
+// Event ids must grow in the commit order, otherwise the relay could publish an event while an event
+// with a lower id is still uncommitted. So inserts are serialized with a lock held until the commit,
+// the events must be saved last to keep the lock short and not to wait for other locks under it.
+func saveOutboxEvent(ctx context.Context, tx *pg.Tx, event *outbox.Event) error {
+	_, err := tx.ExecContext(ctx, "select pg_advisory_xact_lock(?0)", outboxInsertLock)
+	if err != nil {
+		return err
+	}
+	_, err = tx.QueryOneContext(ctx,
+		pg.Scan(&event.Id, &event.CreatedAt),
+		"insert into outbox_events (type,key,payload) values (?0,?1,?2) returning id,created_at",
+		event.Type, event.Key, event.Payload,
+	)
+	return err
+}
@@ -2,96 +2,431 @@ package postgres
 
 import (
 	"context"
+	"encoding/json"
+	"github.com/georgysavva/generic-wallet/account"
 	"github.com/georgysavva/generic-wallet/config"
+	"github.com/georgysavva/generic-wallet/hold"
+	"github.com/georgysavva/generic-wallet/idempotency"
+	"github.com/georgysavva/generic-wallet/ledger"
+	"github.com/georgysavva/generic-wallet/money"
 	"github.com/georgysavva/generic-wallet/payment"
 	"github.com/go-pg/pg"
+	"strings"
 )
 
+const paymentColumns = "id,transfer_id,account_id,to_account_id,from_account_id,amount,fee," +
+	"source_amount,destination_amount,exchange_rate,direction,refunded_transfer_id,refunded_amount,created_at"
+
+const transferColumns = "id,from_account_id,to_account_id,source_amount as amount,source_currency as currency," +
+	"destination_amount,destination_currency,exchange_rate,fee,refunded_transfer_id,refunded_amount," +
+	"refunded_destination_amount,created_at"
+
 type PaymentsRepository struct {
 	db *pg.DB
 }
 
 func NewPaymentsRepository(settings *config.Postgres) (*PaymentsRepository, error) {
-	db, err := connect(settings)
+	db, err := connect(settings, "payments")
 	if err != nil {
 		return nil, err
 	}
 	return &PaymentsRepository{db: db}, nil
 }
 
-func (pr *PaymentsRepository) GetAll(ctx context.Context, offset, limit *int) ([]*payment.Payment, error) {
+// PoolStats returns the stats of the connection pool of the repository.
+func (pr *PaymentsRepository) PoolStats() *pg.PoolStats {
+	return pr.db.PoolStats()
+}
+
+// Ping checks that the database is reachable with a connection of the repository pool.
+func (pr *PaymentsRepository) Ping(ctx context.Context) error {
+	return ping(ctx, pr.db)
+}
+
+func (pr *PaymentsRepository) GetAll(
+	ctx context.Context, filter payment.Filter, sort payment.Sort, offset, limit *int,
+) ([]*payment.Payment, error) {
+	q := newPaymentsQuery(filter)
 	var records []*payment.Payment
 	_, err := pr.db.QueryContext(ctx,
 		&records,
-		"select account_id,to_account_id,from_account_id,amount,direction "+
-			"from payments order by id offset ?0 limit ?1",
-		offset, limit,
+		"select "+paymentColumns+" from payments"+q.whereClause()+orderBy(sort, false)+" offset ? limit ?",
+		append(q.params, offset, limit)...,
 	)
 	return records, err
 }
 
-func (pr *PaymentsRepository) CountAll(ctx context.Context) (int, error) {
+func (pr *PaymentsRepository) CountAll(ctx context.Context, filter payment.Filter) (int, error) {
+	q := newPaymentsQuery(filter)
 	var count int
-	_, err := pr.db.QueryOneContext(ctx, pg.Scan(&count), "select count(*) from payments")
+	_, err := pr.db.QueryOneContext(ctx, pg.Scan(&count), "select count(*) from payments"+q.whereClause(), q.params...)
 	if err != nil {
 		return 0, err
 	}
 	return count, nil
 }
 
-func (pr *PaymentsRepository) Save(ctx context.Context, fromAccountId, toAccountId string, amount float64) error {
-	err := pr.db.RunInTransaction(func(tx *pg.Tx) error {
-		var fromAccountBalance float64
-		// We need to lock source account row
-		// to prevent race condition on the balance field.
+func (pr *PaymentsRepository) GetAllAfter(
+	ctx context.Context, filter payment.Filter, sort payment.Sort, after *payment.Payment, limit int,
+) ([]*payment.Payment, error) {
+	q := newPaymentsQuery(filter)
+	q.whereBeyond(sort, after, false)
+	var records []*payment.Payment
+	_, err := pr.db.QueryContext(ctx,
+		&records,
+		"select "+paymentColumns+" from payments"+q.whereClause()+orderBy(sort, false)+" limit ?",
+		append(q.params, limit)...,
+	)
+	return records, err
+}
+
+func (pr *PaymentsRepository) GetAllBefore(
+	ctx context.Context, filter payment.Filter, sort payment.Sort, before *payment.Payment, limit int,
+) ([]*payment.Payment, error) {
+	q := newPaymentsQuery(filter)
+	q.whereBeyond(sort, before, true)
+	var records []*payment.Payment
+	// Take the closest payments going backwards and restore the sort order afterwards.
+	_, err := pr.db.QueryContext(ctx,
+		&records,
+		"select * from (select "+paymentColumns+" from payments"+q.whereClause()+orderBy(sort, true)+" limit ?) as page"+
+			orderBy(sort, false),
+		append(q.params, limit)...,
+	)
+	return records, err
+}
+
+// paymentsQuery accumulates conditions of a payments query.
+// Conditions contain only column names from the code and "?" placeholders, all values go to params.
+type paymentsQuery struct {
+	conditions []string
+	params     []interface{}
+}
+
+func newPaymentsQuery(filter payment.Filter) *paymentsQuery {
+	q := &paymentsQuery{}
+	if filter.AccountId != "" {
+		q.where("account_id=?", filter.AccountId)
+	}
+	if len(filter.AccountIds) != 0 {
+		q.where("account_id in (?)", pg.In(filter.AccountIds))
+	}
+	if filter.CounterpartyId != "" {
+		q.where("(to_account_id=? or from_account_id=?)", filter.CounterpartyId, filter.CounterpartyId)
+	}
+	if filter.Direction != "" {
+		q.where("direction=?", filter.Direction)
+	}
+	if filter.MinAmount != nil {
+		q.where("amount>=?", *filter.MinAmount)
+	}
+	if filter.MaxAmount != nil {
+		q.where("amount<=?", *filter.MaxAmount)
+	}
+	if filter.CreatedFrom != nil {
+		q.where("created_at>=?", *filter.CreatedFrom)
+	}
+	if filter.CreatedTo != nil {
+		q.where("created_at<?", *filter.CreatedTo)
+	}
+	return q
+}
+
+func (q *paymentsQuery) where(condition string, params ...interface{}) {
+	q.conditions = append(q.conditions, condition)
+	q.params = append(q.params, params...)
+}
+
+// whereBeyond selects payments following the position in the sort order or preceding it if backwards is true.
+func (q *paymentsQuery) whereBeyond(sort payment.Sort, position *payment.Payment, backwards bool) {
+	operator := ">"
+	if sort.Descending != backwards {
+		operator = "<"
+	}
+	switch sort.By {
+	case payment.SortByTime:
+		q.where("(created_at,id)"+operator+"(?,?)", position.CreatedAt, position.Id)
+	case payment.SortByAmount:
+		q.where("(amount,id)"+operator+"(?,?)", position.Amount, position.Id)
+	default:
+		q.where("id"+operator+"?", position.Id)
+	}
+}
+
+func (q *paymentsQuery) whereClause() string {
+	if len(q.conditions) == 0 {
+		return ""
+	}
+	return " where " + strings.Join(q.conditions, " and ")
+}
+
+// orderBy returns the order by clause for the sort, or for the opposite order if reversed is true.
+func orderBy(sort payment.Sort, reversed bool) string {
+	direction := "asc"
+	if sort.Descending != reversed {
+		direction = "desc"
+	}
+	switch sort.By {
+	case payment.SortByTime:
+		return " order by created_at " + direction + ",id " + direction
+	case payment.SortByAmount:
+		return " order by amount " + direction + ",id " + direction
+	default:
+		return " order by id " + direction
+	}
+}
+
+func (pr *PaymentsRepository) Get(ctx context.Context, paymentId int64) (*payment.Payment, error) {
+	record := &payment.Payment{}
+	_, err := pr.db.QueryOneContext(ctx,
+		record, "select "+paymentColumns+" from payments where id=?0", paymentId,
+	)
+	if err != nil {
+		if err == pg.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return record, nil
+}
+
+func (pr *PaymentsRepository) Save(
+	ctx context.Context, transfer *payment.Transfer, idempotencyRecord *idempotency.Record,
+) error {
+	ledgerTransaction := ledger.NewTransferTransaction(transfer)
+	err := ledgerTransaction.Validate()
+	if err != nil {
+		return err
+	}
+	err = pr.db.RunInTransaction(func(tx *pg.Tx) error {
+		err := saveTransfer(ctx, tx, transfer, ledgerTransaction)
+		if err != nil {
+			return err
+		}
+		if idempotencyRecord != nil {
+			idempotencyRecord.Response, err = json.Marshal(transfer)
+			if err != nil {
+				return err
+			}
+			// The key is stored last, a concurrent request with the same key
+			// is blocked either on the source account lock or on the key insert until we commit.
+			err = saveIdempotencyRecord(ctx, tx, idempotencyRecord)
+			if err != nil {
+				return err
+			}
+		}
+		return savePaymentCompletedEvents(ctx, tx, transfer)
+	})
+	return err
+}
+
+func (pr *PaymentsRepository) GetTransfer(ctx context.Context, transferId string) (*payment.Transfer, error) {
+	record := &payment.Transfer{}
+	_, err := pr.db.QueryOneContext(ctx,
+		record,
+		"select "+transferColumns+" from ledger_transactions where id=?0 and kind in (?1,?2)",
+		transferId, ledger.TransferKind, ledger.RefundKind,
+	)
+	if err != nil {
+		if err == pg.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	_, err = pr.db.QueryContext(ctx,
+		&record.Payments, "select "+paymentColumns+" from payments where transfer_id=?0 order by id", transferId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (pr *PaymentsRepository) SaveRefund(ctx context.Context, refund *payment.Transfer) error {
+	ledgerTransaction := ledger.NewTransferTransaction(refund)
+	err := ledgerTransaction.Validate()
+	if err != nil {
+		return err
+	}
+	err = pr.db.RunInTransaction(func(tx *pg.Tx) error {
+		// The refunded transfer is locked before the source account, so concurrent refunds of the same transfer
+		// can't exceed its amounts and don't deadlock with each other.
+		// Checking the totals here rather than relying on the table constraint lets us return a domain error.
+		var refundedAmount, refundedDestinationAmount, sourceAmount, destinationAmount money.Amount
 		_, err := tx.QueryOneContext(ctx,
-			pg.Scan(&fromAccountBalance),
-			"select balance from accounts where id=?0 for update",
-			fromAccountId,
+			pg.Scan(&refundedAmount, &refundedDestinationAmount, &sourceAmount, &destinationAmount),
+			"select refunded_amount,refunded_destination_amount,source_amount,destination_amount "+
+				"from ledger_transactions where id=?0 and kind=?1 for update",
+			refund.RefundedTransferId, ledger.TransferKind,
 		)
 		if err != nil {
 			return err
 		}
-		if fromAccountBalance-amount < 0 {
-			return payment.LowBalanceErr
+		// The refund goes backwards, so its destination amount refunds the source amount of the transfer.
+		refundedAmount = refundedAmount.Add(refund.DestinationAmount)
+		refundedDestinationAmount = refundedDestinationAmount.Add(refund.Amount)
+		if refundedAmount.Cmp(sourceAmount) > 0 || refundedDestinationAmount.Cmp(destinationAmount) > 0 {
+			return payment.RefundExceedsAmountErr
 		}
-
-		// Create an outgoing payment.
 		_, err = tx.ExecOneContext(ctx,
-			"insert into payments (account_id,to_account_id,amount,direction) values (?0,?1,?2,?3)",
-			fromAccountId, toAccountId, amount, payment.OutgoingDirection,
+			"update ledger_transactions set refunded_amount=?0,refunded_destination_amount=?1 where id=?2",
+			refundedAmount, refundedDestinationAmount, refund.RefundedTransferId,
 		)
 		if err != nil {
 			return err
 		}
+		err = saveTransfer(ctx, tx, refund, ledgerTransaction)
+		if err != nil {
+			return err
+		}
+		return savePaymentCompletedEvents(ctx, tx, refund)
+	})
+	return err
+}
 
-		// Create an incoming payment.
-		_, err = tx.ExecOneContext(ctx,
-			"insert into payments (account_id,from_account_id,amount,direction) values (?0,?1,?2,?3)",
-			toAccountId, fromAccountId, amount, payment.IncomingDirection,
-		)
+func (pr *PaymentsRepository) SaveBatch(ctx context.Context, transfers []*payment.Transfer) error {
+	ledgerTransactions := make([]*ledger.Transaction, len(transfers))
+	for i, transfer := range transfers {
+		ledgerTransactions[i] = ledger.NewTransferTransaction(transfer)
+		err := ledgerTransactions[i].Validate()
+		if err != nil {
+			return err
+		}
+	}
+	err := pr.db.RunInTransaction(func(tx *pg.Tx) error {
+		var accountIds []string
+		for _, transfer := range transfers {
+			accountIds = append(accountIds, transfer.FromAccountId, transfer.ToAccountId)
+		}
+		// All the accounts of the batch are locked up front,
+		// so saving the transfers one by one doesn't take the locks out of the id order.
+		err := lockAccounts(ctx, tx, accountIds...)
 		if err != nil {
 			return err
 		}
+		for i, transfer := range transfers {
+			err := saveTransfer(ctx, tx, transfer, ledgerTransactions[i])
+			if _, ok := err.(*payment.LimitExceededError); ok {
+				return &payment.BatchItemError{Index: i, Err: err}
+			}
+			switch err {
+			case nil:
+			case payment.LowBalanceErr, payment.FromAccountClosedErr, payment.ToAccountClosedErr,
+				payment.FromAccountFrozenErr, payment.FromAccountSuspendedErr, payment.ToAccountSuspendedErr:
+				return &payment.BatchItemError{Index: i, Err: err}
+			default:
+				return err
+			}
+		}
+		return savePaymentCompletedEvents(ctx, tx, transfers...)
+	})
+	return err
+}
 
-		// Decrease source account.
-		_, err = tx.ExecOneContext(ctx,
-			"update accounts set balance = balance - ?0 where id=?1",
-			amount, fromAccountId,
-		)
+// spentAmounts returns the money the account has sent within the daily and the monthly limit windows,
+// refunds aren't counted. The captures of the active holds will be spent, so they count in both windows already.
+// The source account must be locked, so the amounts can't change until the transaction ends.
+func spentAmounts(ctx context.Context, tx *pg.Tx, accountId string) (money.Amount, money.Amount, error) {
+	var held money.Amount
+	_, err := tx.QueryOneContext(ctx,
+		pg.Scan(&held),
+		"select coalesce(sum(amount),0) from holds where from_account_id=?0 and status=?1 and expires_at>now()",
+		accountId, hold.ActiveStatus,
+	)
+	if err != nil {
+		return money.Amount{}, money.Amount{}, err
+	}
+	var spentDaily, spentMonthly money.Amount
+	_, err = tx.QueryOneContext(ctx,
+		pg.Scan(&spentDaily, &spentMonthly),
+		"select coalesce(sum(source_amount) filter (where created_at>now()-make_interval(secs=>?2)),0),"+
+			"coalesce(sum(source_amount),0) from ledger_transactions "+
+			"where from_account_id=?0 and kind=?1 and created_at>now()-make_interval(secs=>?3)",
+		accountId, ledger.TransferKind,
+		account.DailyLimitWindow.Seconds(), account.MonthlyLimitWindow.Seconds(),
+	)
+	return spentDaily.Add(held), spentMonthly.Add(held), err
+}
+
+// saveTransfer records the transfer with its ledger transaction inside a database transaction,
+// updates the account balances and fills in the creation time and the payments of the transfer.
+// The caller stores the payment completed event at the end of the transaction, see saveOutboxEvent.
+func saveTransfer(
+	ctx context.Context, tx *pg.Tx, transfer *payment.Transfer, ledgerTransaction *ledger.Transaction,
+) error {
+	// We need to lock the account rows
+	// to prevent race condition on the balance and the status fields.
+	err := lockAccounts(ctx, tx, transfer.FromAccountId, transfer.ToAccountId)
+	if err != nil {
+		return err
+	}
+	// The source account is read after the lock is acquired,
+	// so its available balance includes the holds and the payments committed while we waited.
+	fromAccount, err := readLockedAccount(ctx, tx, transfer.FromAccountId)
+	if err != nil {
+		return err
+	}
+	err = payment.CheckFromAccountStatus(fromAccount.Status)
+	if err != nil {
+		return err
+	}
+	var toAccountStatus string
+	_, err = tx.QueryOneContext(ctx,
+		pg.Scan(&toAccountStatus), "select status from accounts where id=?0", transfer.ToAccountId,
+	)
+	if err != nil {
+		return err
+	}
+	err = payment.CheckToAccountStatus(toAccountStatus)
+	if err != nil {
+		return err
+	}
+	err = payment.CheckBalance(
+		fromAccount.AvailableBalance, fromAccount.OverdraftLimit, transfer.Amount.Add(transfer.Fee),
+	)
+	if err != nil {
+		return err
+	}
+	if transfer.SpendingLimits != nil {
+		// The spent amounts can't change concurrently, since the source account is locked.
+		spentDaily, spentMonthly, err := spentAmounts(ctx, tx, transfer.FromAccountId)
+		if err != nil {
+			return err
+		}
+		err = payment.CheckSpendingLimits(transfer.SpendingLimits, transfer.Amount, spentDaily, spentMonthly)
 		if err != nil {
 			return err
 		}
+	}
+
+	err = saveLedgerTransaction(ctx, tx, ledgerTransaction, transfer)
+	if err != nil {
+		return err
+	}
 
-		// Increase destination account.
+	// Apply the postings to the balances of regular accounts,
+	// their statuses can't change concurrently, since both accounts are locked.
+	for _, posting := range ledgerTransaction.Postings {
+		if ledger.IsSystemAccount(posting.AccountId) {
+			continue
+		}
 		_, err = tx.ExecOneContext(ctx,
-			"update accounts set balance = balance + ?0 where id=?1",
-			amount, toAccountId,
+			"update accounts set balance = balance + ?0 where id=?1", posting.Amount, posting.AccountId,
 		)
 		if err != nil {
 			return err
 		}
-		return nil
-	})
-	return err
+	}
+
+	// Payments are read from the view over the ledger, exactly as they are listed afterwards.
+	var payments []*payment.Payment
+	_, err = tx.QueryContext(ctx,
+		&payments, "select "+paymentColumns+" from payments where transfer_id=?0 order by id", transfer.Id,
+	)
+	if err != nil {
+		return err
+	}
+	transfer.CreatedAt = ledgerTransaction.CreatedAt
+	transfer.Payments = payments
+	return nil
 }
@@ -0,0 +1,145 @@
+package postgres
+
+import (
+	"context"
+	"github.com/georgysavva/generic-wallet/config"
+	"github.com/georgysavva/generic-wallet/schedule"
+	"github.com/go-pg/pg"
+	"time"
+)
+
+const scheduledPaymentColumns = "id,from_account_id,to_account_id,amount,execute_at,status,transfer_id," +
+	"failure_reason,executed_at,created_at"
+
+type ScheduledPaymentsRepository struct {
+	db *pg.DB
+}
+
+func NewScheduledPaymentsRepository(settings *config.Postgres) (*ScheduledPaymentsRepository, error) {
+	db, err := connect(settings, "scheduled_payments")
+	if err != nil {
+		return nil, err
+	}
+	return &ScheduledPaymentsRepository{db: db}, nil
+}
+
+// PoolStats returns the stats of the connection pool of the repository.
+func (sr *ScheduledPaymentsRepository) PoolStats() *pg.PoolStats {
+	return sr.db.PoolStats()
+}
+
+// Ping checks that the database is reachable with a connection of the repository pool.
+func (sr *ScheduledPaymentsRepository) Ping(ctx context.Context) error {
+	return ping(ctx, sr.db)
+}
+
+func (sr *ScheduledPaymentsRepository) Create(ctx context.Context, record *schedule.Payment) error {
+	_, err := sr.db.QueryOneContext(ctx,
+		pg.Scan(&record.CreatedAt),
+		"insert into scheduled_payments (id,from_account_id,to_account_id,amount,execute_at,status) "+
+			"values (?0,?1,?2,?3,?4,?5) returning created_at",
+		record.Id, record.FromAccountId, record.ToAccountId, record.Amount, record.ExecuteAt, record.Status,
+	)
+	return err
+}
+
+func (sr *ScheduledPaymentsRepository) Get(ctx context.Context, paymentId string) (*schedule.Payment, error) {
+	record := &schedule.Payment{}
+	_, err := sr.db.QueryOneContext(ctx,
+		record, "select "+scheduledPaymentColumns+" from scheduled_payments where id=?0", paymentId,
+	)
+	if err != nil {
+		if err == pg.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return record, nil
+}
+
+func (sr *ScheduledPaymentsRepository) GetAll(
+	ctx context.Context, accountId string, offset, limit *int,
+) ([]*schedule.Payment, error) {
+	var records []*schedule.Payment
+	_, err := sr.db.QueryContext(ctx,
+		&records,
+		"select "+scheduledPaymentColumns+" from scheduled_payments "+
+			"where ?0='' or from_account_id=?0 or to_account_id=?0 order by execute_at,id offset ?1 limit ?2",
+		accountId, offset, limit,
+	)
+	return records, err
+}
+
+func (sr *ScheduledPaymentsRepository) CountAll(ctx context.Context, accountId string) (int, error) {
+	var count int
+	_, err := sr.db.QueryOneContext(ctx,
+		pg.Scan(&count),
+		"select count(*) from scheduled_payments where ?0='' or from_account_id=?0 or to_account_id=?0",
+		accountId,
+	)
+	return count, err
+}
+
+func (sr *ScheduledPaymentsRepository) Cancel(ctx context.Context, paymentId string) (*schedule.Payment, error) {
+	record := &schedule.Payment{}
+	_, err := sr.db.QueryOneContext(ctx,
+		record,
+		"update scheduled_payments set status=?0 where id=?1 and status=?2 returning "+scheduledPaymentColumns,
+		schedule.CanceledStatus, paymentId, schedule.PendingStatus,
+	)
+	if err == pg.ErrNoRows {
+		// Either the payment doesn't exist or it isn't pending.
+		existing, err := sr.Get(ctx, paymentId)
+		if err != nil || existing == nil {
+			return nil, err
+		}
+		return nil, schedule.NotPendingErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (sr *ScheduledPaymentsRepository) ClaimDue(
+	ctx context.Context, limit int, claimTimeout time.Duration,
+) ([]*schedule.Payment, error) {
+	var records []*schedule.Payment
+	// Rows locked by a concurrent claim are skipped, so workers of different service replicas
+	// claim different payments without waiting for each other.
+	_, err := sr.db.QueryContext(ctx,
+		&records,
+		"update scheduled_payments set status=?0,claimed_at=now() where id in ("+
+			"select id from scheduled_payments "+
+			"where execute_at<=now() and (status=?1 or status=?0 and claimed_at<=now()-?2*interval '1 millisecond') "+
+			"order by execute_at limit ?3 for update skip locked"+
+			") returning "+scheduledPaymentColumns,
+		schedule.ExecutingStatus, schedule.PendingStatus, claimTimeout/time.Millisecond, limit,
+	)
+	return records, err
+}
+
+func (sr *ScheduledPaymentsRepository) Finish(ctx context.Context, record *schedule.Payment) error {
+	var transferId *string
+	if record.TransferId != "" {
+		transferId = &record.TransferId
+	}
+	var failureReason *string
+	if record.FailureReason != "" {
+		failureReason = &record.FailureReason
+	}
+	executedAt := time.Now()
+	res, err := sr.db.ExecContext(ctx,
+		"update scheduled_payments set status=?0,transfer_id=?1,failure_reason=?2,executed_at=?3 "+
+			"where id=?4 and status=?5",
+		record.Status, transferId, failureReason, executedAt, record.Id, schedule.ExecutingStatus,
+	)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return schedule.AlreadyFinishedErr
+	}
+	record.ExecutedAt = &executedAt
+	return nil
+}
@@ -0,0 +1,136 @@
+package postgres
+
+import (
+	"context"
+	"github.com/georgysavva/generic-wallet/config"
+	"github.com/georgysavva/generic-wallet/standing"
+	"github.com/go-pg/pg"
+	"time"
+)
+
+const standingOrderColumns = "id,from_account_id,to_account_id,amount,frequency,start_at,end_at,count,next_run_at," +
+	"occurrences,attempts,failed_occurrences,last_transfer_id,last_failure_reason,status,created_at"
+
+type StandingOrdersRepository struct {
+	db *pg.DB
+}
+
+func NewStandingOrdersRepository(settings *config.Postgres) (*StandingOrdersRepository, error) {
+	db, err := connect(settings, "standing_orders")
+	if err != nil {
+		return nil, err
+	}
+	return &StandingOrdersRepository{db: db}, nil
+}
+
+// PoolStats returns the stats of the connection pool of the repository.
+func (sr *StandingOrdersRepository) PoolStats() *pg.PoolStats {
+	return sr.db.PoolStats()
+}
+
+// Ping checks that the database is reachable with a connection of the repository pool.
+func (sr *StandingOrdersRepository) Ping(ctx context.Context) error {
+	return ping(ctx, sr.db)
+}
+
+func (sr *StandingOrdersRepository) Create(ctx context.Context, order *standing.Order) error {
+	_, err := sr.db.QueryOneContext(ctx,
+		pg.Scan(&order.CreatedAt),
+		"insert into standing_orders "+
+			"(id,from_account_id,to_account_id,amount,frequency,start_at,end_at,count,next_run_at,status) "+
+			"values (?0,?1,?2,?3,?4,?5,?6,?7,?8,?9) returning created_at",
+		order.Id, order.FromAccountId, order.ToAccountId, order.Amount, order.Frequency, order.StartAt, order.EndAt,
+		order.Count, order.NextRunAt, order.Status,
+	)
+	return err
+}
+
+func (sr *StandingOrdersRepository) Get(ctx context.Context, orderId string) (*standing.Order, error) {
+	order := &standing.Order{}
+	_, err := sr.db.QueryOneContext(ctx,
+		order, "select "+standingOrderColumns+" from standing_orders where id=?0", orderId,
+	)
+	if err != nil {
+		if err == pg.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return order, nil
+}
+
+func (sr *StandingOrdersRepository) GetAll(
+	ctx context.Context, accountId string, offset, limit *int,
+) ([]*standing.Order, error) {
+	var orders []*standing.Order
+	_, err := sr.db.QueryContext(ctx,
+		&orders,
+		"select "+standingOrderColumns+" from standing_orders "+
+			"where ?0='' or from_account_id=?0 or to_account_id=?0 order by created_at,id offset ?1 limit ?2",
+		accountId, offset, limit,
+	)
+	return orders, err
+}
+
+func (sr *StandingOrdersRepository) CountAll(ctx context.Context, accountId string) (int, error) {
+	var count int
+	_, err := sr.db.QueryOneContext(ctx,
+		pg.Scan(&count),
+		"select count(*) from standing_orders where ?0='' or from_account_id=?0 or to_account_id=?0",
+		accountId,
+	)
+	return count, err
+}
+
+func (sr *StandingOrdersRepository) Cancel(ctx context.Context, orderId string) (*standing.Order, error) {
+	order := &standing.Order{}
+	_, err := sr.db.QueryOneContext(ctx,
+		order,
+		"update standing_orders set status=?0 where id=?1 and status=?2 returning "+standingOrderColumns,
+		standing.CanceledStatus, orderId, standing.ActiveStatus,
+	)
+	if err == pg.ErrNoRows {
+		// Either the order doesn't exist or it isn't active.
+		existing, err := sr.Get(ctx, orderId)
+		if err != nil || existing == nil {
+			return nil, err
+		}
+		return nil, standing.NotActiveErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+func (sr *StandingOrdersRepository) ClaimDue(
+	ctx context.Context, limit int, claimTimeout time.Duration,
+) ([]*standing.Order, error) {
+	var orders []*standing.Order
+	// Rows locked by a concurrent claim are skipped, so workers of different service replicas
+	// claim different orders without waiting for each other.
+	_, err := sr.db.QueryContext(ctx,
+		&orders,
+		"update standing_orders set claimed_at=now() where id in ("+
+			"select id from standing_orders where status=?0 and next_run_at<=now() "+
+			"and (claimed_at is null or claimed_at<=now()-?1*interval '1 millisecond') "+
+			"order by next_run_at limit ?2 for update skip locked"+
+			") returning "+standingOrderColumns,
+		standing.ActiveStatus, claimTimeout/time.Millisecond, limit,
+	)
+	return orders, err
+}
+
+func (sr *StandingOrdersRepository) Update(ctx context.Context, order *standing.Order) error {
+	var lastTransferId *string
+	if order.LastTransferId != "" {
+		lastTransferId = &order.LastTransferId
+	}
+	_, err := sr.db.ExecContext(ctx,
+		"update standing_orders set next_run_at=?0,occurrences=?1,attempts=?2,failed_occurrences=?3,"+
+			"last_transfer_id=?4,last_failure_reason=?5,status=?6,claimed_at=null where id=?7 and status=?8",
+		order.NextRunAt, order.Occurrences, order.Attempts, order.FailedOccurrences, lastTransferId,
+		order.LastFailureReason, order.Status, order.Id, standing.ActiveStatus,
+	)
+	return err
+}
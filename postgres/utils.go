@@ -1,7 +1,10 @@
 package postgres
 
 import (
+	"context"
 	"github.com/georgysavva/generic-wallet/config"
+	"github.com/georgysavva/generic-wallet/metrics"
+	"github.com/georgysavva/generic-wallet/tracing"
 	"github.com/go-pg/pg"
 	"github.com/pkg/errors"
 	"net"
@@ -9,7 +12,9 @@ import (
 	"time"
 )
 
-func connect(settings *config.Postgres) (*pg.DB, error) {
+// connect opens a connection pool, queries made with the context of a traced request are traced
+// with spans named after the repository.
+func connect(settings *config.Postgres, repository string) (*pg.DB, error) {
 	timeout := time.Millisecond * time.Duration(settings.Timeout)
 	db := pg.Connect(&pg.Options{
 		User:         settings.User,
@@ -21,10 +26,75 @@ func connect(settings *config.Postgres) (*pg.DB, error) {
 		ReadTimeout:  timeout,
 		WriteTimeout: timeout,
 	})
-	var n int
-	_, err := db.QueryOne(pg.Scan(&n), "SELECT 1")
+	db.AddQueryHook(&tracingHook{spanName: "postgres." + repository})
+	err := ping(context.Background(), db)
 	if err != nil {
 		return nil, errors.Wrap(err, "connection failed")
 	}
 	return db, nil
 }
+
+func ping(ctx context.Context, db *pg.DB) error {
+	var n int
+	_, err := db.QueryOneContext(ctx, pg.Scan(&n), "SELECT 1")
+	return err
+}
+
+type spanKey struct{}
+
+// tracingHook starts a span before every query and finishes it after the query.
+type tracingHook struct {
+	spanName string
+}
+
+func (h *tracingHook) BeforeQuery(event *pg.QueryEvent) {
+	_, span := tracing.StartSpan(event.Ctx, h.spanName)
+	if span == nil {
+		return
+	}
+	if query, err := event.UnformattedQuery(); err == nil {
+		span.SetAttribute("db.statement", query)
+	}
+	event.Data[spanKey{}] = span
+}
+
+func (h *tracingHook) AfterQuery(event *pg.QueryEvent) {
+	span, _ := event.Data[spanKey{}].(*tracing.Span)
+	span.Finish(event.Error)
+}
+
+// Pool is implemented by the repositories, each of them has its own connection pool.
+type Pool interface {
+	PoolStats() *pg.PoolStats
+	Ping(ctx context.Context) error
+}
+
+// RegisterPoolMetrics adds metrics of the connection pools by name into the registry,
+// they are updated from the pool stats every time the metrics are collected.
+func RegisterPoolMetrics(registry *metrics.Registry, pools map[string]Pool) {
+	hits := registry.NewCounter(
+		"postgres_pool_hits_total", "Number of times a free connection was found in the pool.", "pool",
+	)
+	misses := registry.NewCounter(
+		"postgres_pool_misses_total", "Number of times a free connection wasn't found in the pool.", "pool",
+	)
+	timeouts := registry.NewCounter(
+		"postgres_pool_timeouts_total", "Number of times waiting for a connection timed out.", "pool",
+	)
+	staleConns := registry.NewCounter(
+		"postgres_pool_stale_connections_total", "Number of stale connections removed from the pool.", "pool",
+	)
+	totalConns := registry.NewGauge("postgres_pool_connections", "Number of connections in the pool.", "pool")
+	idleConns := registry.NewGauge("postgres_pool_idle_connections", "Number of idle connections in the pool.", "pool")
+	registry.OnCollect(func() {
+		for name, pool := range pools {
+			stats := pool.PoolStats()
+			hits.Set(float64(stats.Hits), name)
+			misses.Set(float64(stats.Misses), name)
+			timeouts.Set(float64(stats.Timeouts), name)
+			staleConns.Set(float64(stats.StaleConns), name)
+			totalConns.Set(float64(stats.TotalConns), name)
+			idleConns.Set(float64(stats.IdleConns), name)
+		}
+	})
+}
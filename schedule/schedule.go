@@ -0,0 +1,69 @@
+package schedule
+
+import (
+	"context"
+	"github.com/georgysavva/generic-wallet/idempotency"
+	"github.com/georgysavva/generic-wallet/money"
+	"github.com/pkg/errors"
+	"time"
+)
+
+const (
+	// PendingStatus payments wait for their execution time.
+	PendingStatus = "pending"
+	// ExecutingStatus payments have been claimed by a worker, which is sending them.
+	ExecutingStatus = "executing"
+	ExecutedStatus  = "executed"
+	// FailedStatus payments have been rejected, e.g. because of a low balance, they aren't retried.
+	FailedStatus   = "failed"
+	CanceledStatus = "canceled"
+)
+
+// Payment is a payment sent from the source account to the destination account at the execution time.
+type Payment struct {
+	Id            string `json:"id"`
+	FromAccountId string `json:"from_account"`
+	ToAccountId   string `json:"to_account"`
+	// Amount is in the source account currency.
+	Amount    money.Amount `json:"amount"`
+	ExecuteAt time.Time    `json:"execute_at"`
+	Status    string       `json:"status"`
+	// TransferId is set when the payment is executed.
+	TransferId string `json:"transfer_id,omitempty"`
+	// FailureReason is set when the payment fails.
+	FailureReason string     `json:"failure_reason,omitempty"`
+	ExecutedAt    *time.Time `json:"executed_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// IdempotencyKey is used to send the payment, so a payment claimed again after a worker crash
+// isn't sent twice.
+func (p *Payment) IdempotencyKey() string {
+	return idempotency.InternalKeyPrefix + "scheduled_payment:" + p.Id
+}
+
+var NotPendingErr = errors.New("scheduled payment has already been executed or canceled")
+
+// AlreadyFinishedErr means that the claim of the payment has timed out
+// and another worker has claimed the payment again and finished it.
+var AlreadyFinishedErr = errors.New("scheduled payment has already been finished by another worker")
+
+type Repository interface {
+	// Create fills in the creation time of the payment.
+	Create(ctx context.Context, payment *Payment) error
+	// Get returns nil if the payment doesn't exist.
+	Get(ctx context.Context, paymentId string) (*Payment, error)
+	// GetAll returns payments from or to the account ordered by the execution time,
+	// empty accountId means payments of all accounts.
+	GetAll(ctx context.Context, accountId string, offset, limit *int) ([]*Payment, error)
+	CountAll(ctx context.Context, accountId string) (int, error)
+	// Cancel returns nil if the payment doesn't exist and NotPendingErr if it isn't pending.
+	Cancel(ctx context.Context, paymentId string) (*Payment, error)
+	// ClaimDue marks up to limit pending payments past their execution time as executing and returns them.
+	// Concurrent calls never claim the same payment.
+	// Payments claimed longer than claimTimeout ago are claimed again, since their worker might have crashed.
+	ClaimDue(ctx context.Context, limit int, claimTimeout time.Duration) ([]*Payment, error)
+	// Finish stores the status, the transfer id and the failure reason of the executing payment
+	// and sets its execution time. It returns AlreadyFinishedErr if the payment isn't executing anymore.
+	Finish(ctx context.Context, payment *Payment) error
+}
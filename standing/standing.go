@@ -0,0 +1,129 @@
+package standing
+
+import (
+	"context"
+	"github.com/georgysavva/generic-wallet/idempotency"
+	"github.com/georgysavva/generic-wallet/money"
+	"github.com/pkg/errors"
+	"strconv"
+	"time"
+)
+
+const (
+	DailyFrequency   = "daily"
+	WeeklyFrequency  = "weekly"
+	MonthlyFrequency = "monthly"
+)
+
+const (
+	ActiveStatus = "active"
+	// FinishedStatus orders have reached their end date or their number of occurrences.
+	FinishedStatus = "finished"
+	CanceledStatus = "canceled"
+)
+
+// Rule is the recurrence rule of a standing order.
+type Rule struct {
+	Frequency string `json:"frequency"`
+	// StartAt is the time of the first occurrence, the following ones are at the same time of the day.
+	StartAt time.Time `json:"start_at"`
+	// EndAt is the time after which there are no occurrences, nil means no end date.
+	EndAt *time.Time `json:"end_at,omitempty"`
+	// Count is the max number of occurrences, nil means no limit.
+	Count *int `json:"count,omitempty"`
+}
+
+// Order sends the same payment from the source account to the destination account
+// at every occurrence of its recurrence rule.
+type Order struct {
+	Id            string `json:"id"`
+	FromAccountId string `json:"from_account"`
+	ToAccountId   string `json:"to_account"`
+	// Amount is in the source account currency.
+	Amount money.Amount `json:"amount"`
+	Rule
+	// NextRunAt is the time of the next attempt to send the payment of the current occurrence.
+	NextRunAt time.Time `json:"next_run_at"`
+	// Occurrences is the number of occurrences processed so far, it's the index of the current occurrence.
+	Occurrences int `json:"occurrences"`
+	// Attempts is the number of failed attempts to send the payment of the current occurrence.
+	Attempts          int       `json:"attempts"`
+	FailedOccurrences int       `json:"failed_occurrences"`
+	LastTransferId    string    `json:"last_transfer_id,omitempty"`
+	LastFailureReason string    `json:"last_failure_reason,omitempty"`
+	Status            string    `json:"status"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// IsValidFrequency returns true if the frequency is one of the supported ones.
+func IsValidFrequency(frequency string) bool {
+	switch frequency {
+	case DailyFrequency, WeeklyFrequency, MonthlyFrequency:
+		return true
+	}
+	return false
+}
+
+// OccurrenceTime returns the time of the occurrence with the index, the first occurrence has index 0.
+// Monthly occurrences fall on the last day of shorter months, e.g. January 31 is followed by February 28.
+func (r *Rule) OccurrenceTime(index int) time.Time {
+	switch r.Frequency {
+	case DailyFrequency:
+		return r.StartAt.AddDate(0, 0, index)
+	case WeeklyFrequency:
+		return r.StartAt.AddDate(0, 0, 7*index)
+	default:
+		return addMonths(r.StartAt, index)
+	}
+}
+
+// NextOccurrence moves the order to the next occurrence or finishes it if there are no more occurrences.
+func (o *Order) NextOccurrence() {
+	o.Occurrences++
+	o.Attempts = 0
+	next := o.OccurrenceTime(o.Occurrences)
+	if o.Count != nil && o.Occurrences >= *o.Count || o.EndAt != nil && next.After(*o.EndAt) {
+		o.Status = FinishedStatus
+		return
+	}
+	o.NextRunAt = next
+}
+
+// IdempotencyKey is used to send the payment of the occurrence,
+// so an occurrence processed again after a worker crash isn't paid twice.
+func (o *Order) IdempotencyKey(occurrence int) string {
+	return idempotency.InternalKeyPrefix + "standing_order:" + o.Id + ":" + strconv.Itoa(occurrence)
+}
+
+func addMonths(t time.Time, months int) time.Time {
+	year, month, day := t.Date()
+	firstOfMonth := time.Date(
+		year, month+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location(),
+	)
+	if lastDay := firstOfMonth.AddDate(0, 1, -1).Day(); day > lastDay {
+		day = lastDay
+	}
+	return firstOfMonth.AddDate(0, 0, day-1)
+}
+
+var NotActiveErr = errors.New("standing order has already finished or been canceled")
+
+type Repository interface {
+	// Create fills in the creation time of the order.
+	Create(ctx context.Context, order *Order) error
+	// Get returns nil if the order doesn't exist.
+	Get(ctx context.Context, orderId string) (*Order, error)
+	// GetAll returns orders from or to the account ordered by the creation time,
+	// empty accountId means orders of all accounts.
+	GetAll(ctx context.Context, accountId string, offset, limit *int) ([]*Order, error)
+	CountAll(ctx context.Context, accountId string) (int, error)
+	// Cancel returns nil if the order doesn't exist and NotActiveErr if it isn't active.
+	Cancel(ctx context.Context, orderId string) (*Order, error)
+	// ClaimDue claims up to limit active orders past their next run time and returns them.
+	// Concurrent calls never claim the same order.
+	// Orders claimed longer than claimTimeout ago are claimed again, since their worker might have crashed.
+	ClaimDue(ctx context.Context, limit int, claimTimeout time.Duration) ([]*Order, error)
+	// Update stores the progress of the claimed order and releases the claim.
+	// The order stays canceled if it has been canceled while claimed.
+	Update(ctx context.Context, order *Order) error
+}
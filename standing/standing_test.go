@@ -0,0 +1,53 @@
+package standing
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestOccurrenceTime(t *testing.T) {
+	start := time.Date(2020, time.January, 31, 10, 0, 0, 0, time.UTC)
+	cases := []struct {
+		frequency string
+		index     int
+		expected  time.Time
+	}{
+		{DailyFrequency, 0, start},
+		{DailyFrequency, 1, time.Date(2020, time.February, 1, 10, 0, 0, 0, time.UTC)},
+		{WeeklyFrequency, 2, time.Date(2020, time.February, 14, 10, 0, 0, 0, time.UTC)},
+		{MonthlyFrequency, 1, time.Date(2020, time.February, 29, 10, 0, 0, 0, time.UTC)},
+		{MonthlyFrequency, 2, time.Date(2020, time.March, 31, 10, 0, 0, 0, time.UTC)},
+		{MonthlyFrequency, 3, time.Date(2020, time.April, 30, 10, 0, 0, 0, time.UTC)},
+		{MonthlyFrequency, 13, time.Date(2021, time.February, 28, 10, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		rule := &Rule{Frequency: c.frequency, StartAt: start}
+		assert.Equal(t, c.expected, rule.OccurrenceTime(c.index), "%s %d", c.frequency, c.index)
+	}
+}
+
+func TestNextOccurrence(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 10, 0, 0, 0, time.UTC)
+	count := 2
+	order := &Order{
+		Rule: Rule{Frequency: WeeklyFrequency, StartAt: start, Count: &count}, NextRunAt: start, Status: ActiveStatus,
+	}
+	order.Attempts = 1
+	order.NextOccurrence()
+	assert.Equal(t, ActiveStatus, order.Status)
+	assert.Equal(t, 0, order.Attempts)
+	assert.Equal(t, time.Date(2020, time.January, 8, 10, 0, 0, 0, time.UTC), order.NextRunAt)
+	order.NextOccurrence()
+	assert.Equal(t, FinishedStatus, order.Status)
+
+	end := time.Date(2020, time.January, 2, 10, 0, 0, 0, time.UTC)
+	order = &Order{
+		Rule: Rule{Frequency: DailyFrequency, StartAt: start, EndAt: &end}, NextRunAt: start, Status: ActiveStatus,
+	}
+	order.NextOccurrence()
+	assert.Equal(t, ActiveStatus, order.Status)
+	order.NextOccurrence()
+	assert.Equal(t, FinishedStatus, order.Status)
+	assert.Equal(t, 2, order.Occurrences)
+}
@@ -0,0 +1,72 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Exporter sends finished spans somewhere, it must not block the traced operations.
+type Exporter interface {
+	Export(span *Span)
+}
+
+// WriterExporter writes spans to the writer as JSON lines, e.g. to stdout.
+type WriterExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{w: w}
+}
+
+func (e *WriterExporter) Export(span *Span) {
+	b, err := json.Marshal(span)
+	if err != nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.w.Write(append(b, '\n'))
+}
+
+// HTTPExporter posts spans as JSON objects to a collector in the background.
+// Spans are dropped if the collector can't keep up, tracing must never slow down payments.
+type HTTPExporter struct {
+	url    string
+	client *http.Client
+	spans  chan *Span
+}
+
+// NewHTTPExporter returns an exporter buffering up to bufferSize spans,
+// it starts a goroutine posting them to the collector url.
+func NewHTTPExporter(url string, timeout time.Duration, bufferSize int) *HTTPExporter {
+	e := &HTTPExporter{url: url, client: &http.Client{Timeout: timeout}, spans: make(chan *Span, bufferSize)}
+	go e.run()
+	return e
+}
+
+func (e *HTTPExporter) Export(span *Span) {
+	select {
+	case e.spans <- span:
+	default:
+	}
+}
+
+func (e *HTTPExporter) run() {
+	for span := range e.spans {
+		b, err := json.Marshal(span)
+		if err != nil {
+			continue
+		}
+		resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(b))
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+	}
+}
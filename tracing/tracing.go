@@ -0,0 +1,182 @@
+// Package tracing creates spans of requests, which are propagated with W3C traceparent headers,
+// and carries the request id through the context.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const (
+	traceIdSize = 16
+	spanIdSize  = 8
+)
+
+var traceparentRegexp = regexp.MustCompile(`^00-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})$`)
+
+// SpanContext identifies a span across process boundaries.
+type SpanContext struct {
+	TraceId string `json:"trace_id"`
+	SpanId  string `json:"span_id"`
+	// Sampled spans are exported, the flag comes from the caller.
+	Sampled bool `json:"-"`
+}
+
+// ParseTraceparent parses a W3C traceparent header, it returns false if the header is invalid.
+func ParseTraceparent(header string) (SpanContext, bool) {
+	match := traceparentRegexp.FindStringSubmatch(header)
+	if match == nil || isZero(match[1]) || isZero(match[2]) {
+		return SpanContext{}, false
+	}
+	flags, _ := hex.DecodeString(match[3])
+	return SpanContext{TraceId: match[1], SpanId: match[2], Sampled: flags[0]&1 == 1}, true
+}
+
+func isZero(id string) bool {
+	for _, c := range id {
+		if c != '0' {
+			return false
+		}
+	}
+	return true
+}
+
+// Traceparent formats the span context as a W3C traceparent header.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceId, sc.SpanId, flags)
+}
+
+// Span is a timed operation of a trace.
+type Span struct {
+	SpanContext
+	// ParentSpanId is empty for the root span of a trace.
+	ParentSpanId string            `json:"parent_span_id,omitempty"`
+	Name         string            `json:"name"`
+	StartTime    time.Time         `json:"start_time"`
+	EndTime      time.Time         `json:"end_time"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+	// Error is the message of the error the operation has failed with.
+	Error    string `json:"error,omitempty"`
+	mu       sync.Mutex
+	exporter Exporter
+}
+
+// SetAttribute is a no-op for nil span, so spans don't have to be checked when tracing is off.
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Attributes == nil {
+		s.Attributes = map[string]string{}
+	}
+	s.Attributes[key] = value
+}
+
+// Finish ends the span and exports it if it's sampled. Non-nil err marks the span as failed.
+func (s *Span) Finish(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.EndTime = time.Now()
+	if err != nil {
+		s.Error = err.Error()
+	}
+	s.mu.Unlock()
+	if s.Sampled && s.exporter != nil {
+		s.exporter.Export(s)
+	}
+}
+
+// Tracer starts the root spans of the requests the service receives.
+type Tracer struct {
+	exporter Exporter
+}
+
+// NewTracer returns a tracer exporting spans with the exporter, nil exporter doesn't export them,
+// but the trace ids are still propagated.
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+// StartServerSpan starts the span of a request received by the service.
+// The span continues the trace of the remote parent if it's set, otherwise it starts a new sampled trace.
+func (t *Tracer) StartServerSpan(ctx context.Context, name string, remoteParent *SpanContext) (context.Context, *Span) {
+	span := &Span{Name: name, StartTime: time.Now(), exporter: t.exporter}
+	if remoteParent != nil {
+		span.TraceId, span.ParentSpanId, span.Sampled = remoteParent.TraceId, remoteParent.SpanId, remoteParent.Sampled
+	} else {
+		span.TraceId, span.Sampled = generateId(traceIdSize), true
+	}
+	span.SpanId = generateId(spanIdSize)
+	return context.WithValue(ctx, spanContextKey, span), span
+}
+
+// StartSpan starts a child span of the span in the context.
+// It returns nil span if there is no span in the context, e.g. for calls made by background workers.
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	span := &Span{
+		SpanContext:  SpanContext{TraceId: parent.TraceId, SpanId: generateId(spanIdSize), Sampled: parent.Sampled},
+		ParentSpanId: parent.SpanId,
+		Name:         name,
+		StartTime:    time.Now(),
+		exporter:     parent.exporter,
+	}
+	return context.WithValue(ctx, spanContextKey, span), span
+}
+
+type contextKey int
+
+const (
+	spanContextKey contextKey = iota
+	requestIdContextKey
+)
+
+// SpanFromContext returns the current span or nil if there is none.
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(spanContextKey).(*Span)
+	return span
+}
+
+func ContextWithRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, requestIdContextKey, requestId)
+}
+
+// RequestIdFromContext returns the id of the request or an empty string if there is none.
+func RequestIdFromContext(ctx context.Context) string {
+	requestId, _ := ctx.Value(requestIdContextKey).(string)
+	return requestId
+}
+
+// GenerateRequestId returns a random request id.
+func GenerateRequestId() string {
+	return generateId(traceIdSize)
+}
+
+// generateId returns a random hex id of the size in bytes.
+func generateId(size int) string {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		// The system random source never fails on supported platforms.
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,63 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	sc, ok := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.True(t, ok)
+	assert.Equal(t,
+		SpanContext{TraceId: "4bf92f3577b34da6a3ce929d0e0e4736", SpanId: "00f067aa0ba902b7", Sampled: true}, sc,
+	)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.Traceparent())
+
+	invalidHeaders := []string{
+		"",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+	}
+	for _, header := range invalidHeaders {
+		_, ok := ParseTraceparent(header)
+		assert.False(t, ok, header)
+	}
+}
+
+func TestSpans(t *testing.T) {
+	var buffer bytes.Buffer
+	tracer := NewTracer(NewWriterExporter(&buffer))
+	remoteParent := &SpanContext{TraceId: "4bf92f3577b34da6a3ce929d0e0e4736", SpanId: "00f067aa0ba902b7", Sampled: true}
+
+	ctx, root := tracer.StartServerSpan(context.Background(), "POST /payments", remoteParent)
+	_, child := StartSpan(ctx, "wallet.send_payment")
+	child.SetAttribute("amount", "10")
+	child.Finish(errors.New("low balance"))
+	root.Finish(nil)
+
+	decoder := json.NewDecoder(&buffer)
+	var exported []map[string]interface{}
+	for decoder.More() {
+		span := map[string]interface{}{}
+		assert.Equal(t, nil, decoder.Decode(&span))
+		exported = append(exported, span)
+	}
+	assert.Equal(t, 2, len(exported))
+	assert.Equal(t, "wallet.send_payment", exported[0]["name"])
+	assert.Equal(t, remoteParent.TraceId, exported[0]["trace_id"])
+	assert.Equal(t, root.SpanId, exported[0]["parent_span_id"])
+	assert.Equal(t, "low balance", exported[0]["error"])
+	assert.Equal(t, map[string]interface{}{"amount": "10"}, exported[0]["attributes"])
+	assert.Equal(t, remoteParent.SpanId, exported[1]["parent_span_id"])
+
+	_, span := StartSpan(context.Background(), "untraced")
+	assert.Nil(t, span)
+	span.SetAttribute("key", "value")
+	span.Finish(nil)
+}
@@ -0,0 +1,203 @@
+package wallet
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/pkg/errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	apiKeyHeader        = "X-API-Key"
+	authorizationHeader = "Authorization"
+	bearerPrefix        = "Bearer "
+)
+
+// Principal is the authenticated caller of the API.
+type Principal struct {
+	Id string `json:"id"`
+	// Role defines what the principal is allowed to do, CustomerRole is assumed if it's empty.
+	Role string `json:"role"`
+	// Accounts the principal owns, it can move money only out of them.
+	Accounts []string `json:"accounts"`
+}
+
+// Owns returns true if the account belongs to the principal.
+func (p *Principal) Owns(accountId string) bool {
+	for _, ownedId := range p.Accounts {
+		if ownedId == accountId {
+			return true
+		}
+	}
+	return false
+}
+
+var UnauthenticatedErr = errors.New("request credentials are missing or invalid")
+var AccountNotOwnedErr = errors.New("account doesn't belong to the caller")
+
+type contextKey int
+
+const (
+	credentialsContextKey contextKey = iota
+	principalContextKey
+	clientAddressContextKey
+)
+
+// credentials are the raw secrets of a request, either an API key or a JWT.
+type credentials struct {
+	apiKey string
+	token  string
+}
+
+// PrincipalFromContext returns the principal authenticated for the request or false if there is none.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalContextKey).(*Principal)
+	return principal, ok
+}
+
+// credentialsToContext puts the credentials of the request into the context, they are verified by the endpoint.
+func credentialsToContext(ctx context.Context, r *http.Request) context.Context {
+	creds := &credentials{apiKey: r.Header.Get(apiKeyHeader)}
+	if authorization := r.Header.Get(authorizationHeader); strings.HasPrefix(authorization, bearerPrefix) {
+		creds.token = strings.TrimPrefix(authorization, bearerPrefix)
+	}
+	return context.WithValue(ctx, credentialsContextKey, creds)
+}
+
+// Authenticator verifies API keys and JWTs signed with HS256 locally, without calling other services.
+type Authenticator struct {
+	// apiKeys are indexed by the key hashes, so the lookup time doesn't depend on the secret.
+	apiKeys map[[sha256.Size]byte]*Principal
+	// jwtKeys are HMAC secrets by key id.
+	jwtKeys map[string][]byte
+	now     func() time.Time
+}
+
+// NewAuthenticator returns an authenticator accepting the API keys of the principals
+// and JWTs signed with any of the keys, the key id of the JWT header selects the key if it's set.
+// A JWT must have the "sub" claim with the principal id,
+// the "role" and the "accounts" claims set the role and the accounts of the principal.
+func NewAuthenticator(apiKeys map[string]*Principal, jwtKeys map[string][]byte) *Authenticator {
+	a := &Authenticator{apiKeys: map[[sha256.Size]byte]*Principal{}, jwtKeys: jwtKeys, now: time.Now}
+	for key, principal := range apiKeys {
+		a.apiKeys[sha256.Sum256([]byte(key))] = principal
+	}
+	return a
+}
+
+// authenticate returns UnauthenticatedErr if the credentials are missing or invalid.
+func (a *Authenticator) authenticate(creds *credentials) (*Principal, error) {
+	switch {
+	case creds == nil:
+	case creds.apiKey != "":
+		if principal := a.apiKeys[sha256.Sum256([]byte(creds.apiKey))]; principal != nil {
+			return principal, nil
+		}
+	case creds.token != "":
+		return a.verifyToken(creds.token)
+	}
+	return nil, UnauthenticatedErr
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyId     string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Subject   string   `json:"sub"`
+	Role      string   `json:"role"`
+	Accounts  []string `json:"accounts"`
+	ExpiresAt *int64   `json:"exp"`
+	NotBefore *int64   `json:"nbf"`
+}
+
+// verifyToken requires the subject and the expiration time claims, tokens without expiration would never expire.
+func (a *Authenticator) verifyToken(token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, UnauthenticatedErr
+	}
+	header := &jwtHeader{}
+	if decodeJWTPart(parts[0], header) != nil || header.Algorithm != "HS256" {
+		return nil, UnauthenticatedErr
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, UnauthenticatedErr
+	}
+	if !a.validSignature(header.KeyId, parts[0]+"."+parts[1], signature) {
+		return nil, UnauthenticatedErr
+	}
+	claims := &jwtClaims{}
+	if decodeJWTPart(parts[1], claims) != nil || claims.Subject == "" {
+		return nil, UnauthenticatedErr
+	}
+	now := a.now().Unix()
+	if claims.ExpiresAt == nil || now >= *claims.ExpiresAt || claims.NotBefore != nil && now < *claims.NotBefore {
+		return nil, UnauthenticatedErr
+	}
+	return &Principal{Id: claims.Subject, Role: claims.Role, Accounts: claims.Accounts}, nil
+}
+
+// validSignature checks the signature against the key with the id or against all keys if the id is empty.
+func (a *Authenticator) validSignature(keyId, signed string, signature []byte) bool {
+	for id, key := range a.jwtKeys {
+		if keyId != "" && id != keyId {
+			continue
+		}
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		if hmac.Equal(mac.Sum(nil), signature) {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// sourceAccountsRequest is implemented by requests moving money out of accounts,
+// the principal must own all of them.
+type sourceAccountsRequest interface {
+	sourceAccounts() []string
+}
+
+// makeAuthenticationMiddleware authenticates the credentials the transport has put into the context
+// and puts the principal into the context instead.
+// It returns AccountNotOwnedErr if the principal tries to move money out of an account it doesn't own.
+// Nil authenticator disables authentication.
+func makeAuthenticationMiddleware(authenticator *Authenticator) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		if authenticator == nil {
+			return next
+		}
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			creds, _ := ctx.Value(credentialsContextKey).(*credentials)
+			principal, err := authenticator.authenticate(creds)
+			if err != nil {
+				return nil, err
+			}
+			if req, ok := request.(sourceAccountsRequest); ok {
+				for _, accountId := range req.sourceAccounts() {
+					if !principal.Owns(accountId) {
+						return nil, AccountNotOwnedErr
+					}
+				}
+			}
+			return next(context.WithValue(ctx, principalContextKey, principal), request)
+		}
+	}
+}
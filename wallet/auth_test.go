@@ -0,0 +1,115 @@
+package wallet
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/georgysavva/generic-wallet/tracing"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signToken(t *testing.T, header, claims map[string]interface{}, key string) string {
+	var parts []string
+	for _, part := range []map[string]interface{}{header, claims} {
+		b, err := json.Marshal(part)
+		assert.Equal(t, nil, err)
+		parts = append(parts, base64.RawURLEncoding.EncodeToString(b))
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(strings.Join(parts, ".")))
+	return strings.Join(parts, ".") + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestAuthenticate(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	alice := &Principal{Id: "alice", Accounts: []string{"alice"}}
+	authenticator := NewAuthenticator(
+		map[string]*Principal{"alice-key": alice}, map[string][]byte{"k1": []byte("secret1"), "k2": []byte("secret2")},
+	)
+	authenticator.now = func() time.Time { return now }
+	claims := map[string]interface{}{"sub": "bob", "accounts": []string{"bob"}, "exp": now.Add(time.Hour).Unix()}
+
+	principal, err := authenticator.authenticate(&credentials{apiKey: "alice-key"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, alice, principal)
+	_, err = authenticator.authenticate(&credentials{apiKey: "unknown-key"})
+	assert.Equal(t, UnauthenticatedErr, err)
+	_, err = authenticator.authenticate(&credentials{})
+	assert.Equal(t, UnauthenticatedErr, err)
+
+	token := signToken(t, map[string]interface{}{"alg": "HS256", "kid": "k2"}, claims, "secret2")
+	principal, err = authenticator.authenticate(&credentials{token: token})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, &Principal{Id: "bob", Accounts: []string{"bob"}}, principal)
+	token = signToken(t, map[string]interface{}{"alg": "HS256"}, claims, "secret1")
+	_, err = authenticator.authenticate(&credentials{token: token})
+	assert.Equal(t, nil, err)
+
+	invalidTokens := []string{
+		"not-a-token",
+		signToken(t, map[string]interface{}{"alg": "HS256", "kid": "k1"}, claims, "secret2"),
+		signToken(t, map[string]interface{}{"alg": "HS256"}, claims, "wrong-secret"),
+		signToken(t, map[string]interface{}{"alg": "none"}, claims, "secret1"),
+		signToken(t, map[string]interface{}{"alg": "HS256"}, map[string]interface{}{"accounts": []string{"bob"}}, "secret1"),
+		signToken(t,
+			map[string]interface{}{"alg": "HS256"},
+			map[string]interface{}{"sub": "bob", "exp": now.Add(-time.Second).Unix()},
+			"secret1",
+		),
+		signToken(t,
+			map[string]interface{}{"alg": "HS256"},
+			map[string]interface{}{"sub": "bob", "nbf": now.Add(time.Minute).Unix(), "exp": now.Add(time.Hour).Unix()},
+			"secret1",
+		),
+		signToken(t, map[string]interface{}{"alg": "HS256"}, map[string]interface{}{"sub": "bob"}, "secret1"),
+	}
+	for _, token := range invalidTokens {
+		_, err = authenticator.authenticate(&credentials{token: token})
+		assert.Equal(t, UnauthenticatedErr, err, token)
+	}
+}
+
+func TestMakeHandler_Authentication(t *testing.T) {
+	s := instantiateServiceForTests()
+	authenticator := NewAuthenticator(
+		map[string]*Principal{"alice-key": {Id: "alice", Accounts: []string{"alice"}}}, nil,
+	)
+	handler := MakeHandler(s, authenticator, RateLimits{}, tracing.NewTracer(nil), kitlog.NewNopLogger())
+	sendPayment := func(apiKey, fromAccountId string) (int, string) {
+		form := url.Values{"from_account": {fromAccountId}, "to_account": {"mark"}, "amount": {"10"}}
+		r := httptest.NewRequest("POST", "/wallet/v1/payments", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if apiKey != "" {
+			r.Header.Set(apiKeyHeader, apiKey)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		var body struct {
+			Error struct {
+				Code string `json:"code"`
+			} `json:"error"`
+		}
+		json.NewDecoder(w.Body).Decode(&body)
+		return w.Code, body.Error.Code
+	}
+
+	code, errorCode := sendPayment("", "alice")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, unauthenticatedErrCode, errorCode)
+	code, errorCode = sendPayment("alice-key", "bob")
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, accountNotOwnedErrCode, errorCode)
+	code, _ = sendPayment("alice-key", "alice")
+	assert.Equal(t, http.StatusCreated, code)
+	bobAccount, _ := s.GetAccount(context.Background(), "bob")
+	assert.Equal(t, "100", bobAccount.Balance.String())
+}
@@ -0,0 +1,434 @@
+package wallet
+
+import (
+	"context"
+	"github.com/georgysavva/generic-wallet/account"
+	"github.com/georgysavva/generic-wallet/hold"
+	"github.com/georgysavva/generic-wallet/ledger"
+	"github.com/georgysavva/generic-wallet/money"
+	"github.com/georgysavva/generic-wallet/payment"
+	"github.com/georgysavva/generic-wallet/schedule"
+	"github.com/georgysavva/generic-wallet/standing"
+	"github.com/pkg/errors"
+	"sort"
+	"time"
+)
+
+const (
+	// CustomerRole can move money out of its own accounts and see only them.
+	CustomerRole = "customer"
+	// OperatorRole can see all accounts and payments and manage accounts.
+	OperatorRole = "operator"
+)
+
+const (
+	SendPaymentsPermission   = "send_payments"
+	ViewAllPermission        = "view_all"
+	ManageAccountsPermission = "manage_accounts"
+)
+
+var rolePermissions = map[string][]string{
+	CustomerRole: {SendPaymentsPermission},
+	OperatorRole: {SendPaymentsPermission, ViewAllPermission, ManageAccountsPermission},
+}
+
+// Can returns true if the role of the principal has the permission.
+func (p *Principal) Can(permission string) bool {
+	role := p.Role
+	if role == "" {
+		role = CustomerRole
+	}
+	for _, rolePermission := range rolePermissions[role] {
+		if rolePermission == permission {
+			return true
+		}
+	}
+	return false
+}
+
+var ForbiddenErr = errors.New("operation isn't allowed for the caller")
+
+type authorizationService struct {
+	Service
+}
+
+// NewAuthorizationService returns a Service checking that the principal of the context
+// is allowed to perform the operation. Principals without ViewAllPermission see only their own accounts.
+// Calls without a principal in the context aren't restricted, e.g. calls of the workers.
+func NewAuthorizationService(s Service) Service {
+	return &authorizationService{s}
+}
+
+// authorize returns the principal of the context or nil if there is none.
+// It returns ForbiddenErr if the principal doesn't have the permission.
+func authorize(ctx context.Context, permission string) (*Principal, error) {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, nil
+	}
+	if !principal.Can(permission) {
+		return nil, ForbiddenErr
+	}
+	return principal, nil
+}
+
+// authorizeSending returns an error if the principal of the context can't move money out of the account.
+func authorizeSending(ctx context.Context, fromAccountId string) error {
+	principal, err := authorize(ctx, SendPaymentsPermission)
+	if err != nil || principal == nil {
+		return err
+	}
+	if !principal.Owns(fromAccountId) {
+		return AccountNotOwnedErr
+	}
+	return nil
+}
+
+// canView returns true if the principal of the context can see data of any of the accounts.
+func canView(ctx context.Context, accountIds ...string) bool {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok || principal.Can(ViewAllPermission) {
+		return true
+	}
+	for _, accountId := range accountIds {
+		if principal.Owns(accountId) {
+			return true
+		}
+	}
+	return false
+}
+
+// viewedAccount returns the account the principal of the context lists data of.
+// Empty accountId means all accounts, it's replaced with the only account of a principal
+// without ViewAllPermission.
+func viewedAccount(ctx context.Context, accountId string) (string, error) {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok || principal.Can(ViewAllPermission) {
+		return accountId, nil
+	}
+	if accountId == "" && len(principal.Accounts) == 1 {
+		return principal.Accounts[0], nil
+	}
+	if accountId == "" || !principal.Owns(accountId) {
+		return "", ForbiddenErr
+	}
+	return accountId, nil
+}
+
+func (s *authorizationService) SendPayment(
+	ctx context.Context, fromAccountId, toAccountId string, amount money.Amount, idempotencyKey string,
+) (*payment.Transfer, error) {
+	if err := authorizeSending(ctx, fromAccountId); err != nil {
+		return nil, err
+	}
+	return s.Service.SendPayment(ctx, fromAccountId, toAccountId, amount, idempotencyKey)
+}
+
+func (s *authorizationService) SendBatchPayment(
+	ctx context.Context, payments []*BatchPayment,
+) ([]*payment.Transfer, error) {
+	for _, p := range payments {
+		if err := authorizeSending(ctx, p.FromAccountId); err != nil {
+			return nil, err
+		}
+	}
+	return s.Service.SendBatchPayment(ctx, payments)
+}
+
+func (s *authorizationService) AuthorizePayment(
+	ctx context.Context, fromAccountId, toAccountId string, amount money.Amount, expiresIn time.Duration,
+) (*hold.Hold, error) {
+	if err := authorizeSending(ctx, fromAccountId); err != nil {
+		return nil, err
+	}
+	return s.Service.AuthorizePayment(ctx, fromAccountId, toAccountId, amount, expiresIn)
+}
+
+func (s *authorizationService) GetHold(ctx context.Context, holdId string) (*hold.Hold, error) {
+	h, err := s.Service.GetHold(ctx, holdId)
+	if err != nil {
+		return nil, err
+	}
+	if !canView(ctx, h.FromAccountId, h.ToAccountId) {
+		return nil, ForbiddenErr
+	}
+	return h, nil
+}
+
+// authorizeHold returns an error if the principal of the context can't capture or void the hold.
+// Both the payer and the payee can do it.
+func (s *authorizationService) authorizeHold(ctx context.Context, holdId string) error {
+	if _, ok := PrincipalFromContext(ctx); !ok {
+		return nil
+	}
+	_, err := s.GetHold(ctx, holdId)
+	return err
+}
+
+func (s *authorizationService) CapturePayment(
+	ctx context.Context, holdId string, amount *money.Amount,
+) (*hold.Hold, *payment.Transfer, error) {
+	if err := s.authorizeHold(ctx, holdId); err != nil {
+		return nil, nil, err
+	}
+	return s.Service.CapturePayment(ctx, holdId, amount)
+}
+
+func (s *authorizationService) VoidPayment(ctx context.Context, holdId string) (*hold.Hold, error) {
+	if err := s.authorizeHold(ctx, holdId); err != nil {
+		return nil, err
+	}
+	return s.Service.VoidPayment(ctx, holdId)
+}
+
+func (s *authorizationService) SchedulePayment(
+	ctx context.Context, fromAccountId, toAccountId string, amount money.Amount, executeAt time.Time,
+) (*schedule.Payment, error) {
+	if err := authorizeSending(ctx, fromAccountId); err != nil {
+		return nil, err
+	}
+	return s.Service.SchedulePayment(ctx, fromAccountId, toAccountId, amount, executeAt)
+}
+
+func (s *authorizationService) GetScheduledPayment(ctx context.Context, paymentId string) (*schedule.Payment, error) {
+	p, err := s.Service.GetScheduledPayment(ctx, paymentId)
+	if err != nil {
+		return nil, err
+	}
+	if !canView(ctx, p.FromAccountId, p.ToAccountId) {
+		return nil, ForbiddenErr
+	}
+	return p, nil
+}
+
+func (s *authorizationService) GetScheduledPayments(
+	ctx context.Context, accountId string, offset, limit *int,
+) ([]*schedule.Payment, int, error) {
+	accountId, err := viewedAccount(ctx, accountId)
+	if err != nil {
+		return nil, 0, err
+	}
+	return s.Service.GetScheduledPayments(ctx, accountId, offset, limit)
+}
+
+// CancelScheduledPayment can be called only by the payer, the payee can't cancel the payment.
+func (s *authorizationService) CancelScheduledPayment(
+	ctx context.Context, paymentId string,
+) (*schedule.Payment, error) {
+	if _, ok := PrincipalFromContext(ctx); ok {
+		p, err := s.GetScheduledPayment(ctx, paymentId)
+		if err != nil {
+			return nil, err
+		}
+		if !canView(ctx, p.FromAccountId) {
+			return nil, AccountNotOwnedErr
+		}
+	}
+	return s.Service.CancelScheduledPayment(ctx, paymentId)
+}
+
+func (s *authorizationService) CreateStandingOrder(
+	ctx context.Context, fromAccountId, toAccountId string, amount money.Amount, rule standing.Rule,
+) (*standing.Order, error) {
+	if err := authorizeSending(ctx, fromAccountId); err != nil {
+		return nil, err
+	}
+	return s.Service.CreateStandingOrder(ctx, fromAccountId, toAccountId, amount, rule)
+}
+
+func (s *authorizationService) GetStandingOrder(ctx context.Context, orderId string) (*standing.Order, error) {
+	order, err := s.Service.GetStandingOrder(ctx, orderId)
+	if err != nil {
+		return nil, err
+	}
+	if !canView(ctx, order.FromAccountId, order.ToAccountId) {
+		return nil, ForbiddenErr
+	}
+	return order, nil
+}
+
+func (s *authorizationService) GetStandingOrders(
+	ctx context.Context, accountId string, offset, limit *int,
+) ([]*standing.Order, int, error) {
+	accountId, err := viewedAccount(ctx, accountId)
+	if err != nil {
+		return nil, 0, err
+	}
+	return s.Service.GetStandingOrders(ctx, accountId, offset, limit)
+}
+
+// CancelStandingOrder can be called only by the payer, the payee can't cancel the order.
+func (s *authorizationService) CancelStandingOrder(ctx context.Context, orderId string) (*standing.Order, error) {
+	if _, ok := PrincipalFromContext(ctx); ok {
+		order, err := s.GetStandingOrder(ctx, orderId)
+		if err != nil {
+			return nil, err
+		}
+		if !canView(ctx, order.FromAccountId) {
+			return nil, AccountNotOwnedErr
+		}
+	}
+	return s.Service.CancelStandingOrder(ctx, orderId)
+}
+
+func (s *authorizationService) GetPayment(ctx context.Context, paymentId int64) (*payment.Payment, error) {
+	p, err := s.Service.GetPayment(ctx, paymentId)
+	if err != nil {
+		return nil, err
+	}
+	if !canView(ctx, p.AccountId) {
+		return nil, ForbiddenErr
+	}
+	return p, nil
+}
+
+// RefundPayment can be called only by the destination of the transfer, since the refund moves money out of it.
+func (s *authorizationService) RefundPayment(
+	ctx context.Context, paymentId int64, amount *money.Amount,
+) (*payment.Transfer, error) {
+	if _, ok := PrincipalFromContext(ctx); ok {
+		p, err := s.GetPayment(ctx, paymentId)
+		if err != nil {
+			return nil, err
+		}
+		destinationId := p.AccountId
+		if p.Direction == payment.OutgoingDirection {
+			destinationId = p.ToAccountId
+		}
+		if err := authorizeSending(ctx, destinationId); err != nil {
+			return nil, err
+		}
+	}
+	return s.Service.RefundPayment(ctx, paymentId, amount)
+}
+
+func (s *authorizationService) GetAllPayments(
+	ctx context.Context, filter payment.Filter, sort payment.Sort, pagination Pagination,
+) ([]*payment.Payment, *Page, error) {
+	principal, ok := PrincipalFromContext(ctx)
+	if ok && !principal.Can(ViewAllPermission) {
+		if filter.AccountId != "" && !principal.Owns(filter.AccountId) {
+			return nil, nil, ForbiddenErr
+		}
+		if len(principal.Accounts) == 0 {
+			totalNumber := 0
+			return []*payment.Payment{}, &Page{TotalNumber: &totalNumber}, nil
+		}
+		filter.AccountIds = principal.Accounts
+	}
+	return s.Service.GetAllPayments(ctx, filter, sort, pagination)
+}
+
+// GetAllAccounts returns only the accounts of a principal without ViewAllPermission,
+// they are paginated by offset, since a principal owns few accounts.
+func (s *authorizationService) GetAllAccounts(
+	ctx context.Context, pagination Pagination,
+) ([]*account.Account, *Page, error) {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok || principal.Can(ViewAllPermission) {
+		return s.Service.GetAllAccounts(ctx, pagination)
+	}
+	if pagination.Cursor != "" {
+		return nil, nil, &IncorrectInputData{"'cursor' pagination parameter isn't supported for the caller"}
+	}
+	pagination, err := prepareListPagination(pagination)
+	if err != nil {
+		return nil, nil, err
+	}
+	accountRecords := make([]*account.Account, 0, len(principal.Accounts))
+	for _, accountId := range principal.Accounts {
+		accountRecord, err := s.Service.GetAccount(ctx, accountId)
+		if err == AccountNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		accountRecords = append(accountRecords, accountRecord)
+	}
+	sort.Slice(accountRecords, func(i, j int) bool { return accountRecords[i].Id < accountRecords[j].Id })
+	totalNumber := len(accountRecords)
+	start := 0
+	if pagination.Offset != nil {
+		start = *pagination.Offset
+	}
+	if start > totalNumber {
+		start = totalNumber
+	}
+	end := start + *pagination.Limit
+	if end > totalNumber {
+		end = totalNumber
+	}
+	return accountRecords[start:end], &Page{TotalNumber: &totalNumber}, nil
+}
+
+func (s *authorizationService) GetAccountPayments(
+	ctx context.Context, accountId string, offset, limit *int,
+) ([]*payment.Payment, int, error) {
+	if !canView(ctx, accountId) {
+		return nil, 0, ForbiddenErr
+	}
+	return s.Service.GetAccountPayments(ctx, accountId, offset, limit)
+}
+
+func (s *authorizationService) CreateAccount(ctx context.Context, accountId, currency string) (*account.Account, error) {
+	if _, err := authorize(ctx, ManageAccountsPermission); err != nil {
+		return nil, err
+	}
+	return s.Service.CreateAccount(ctx, accountId, currency)
+}
+
+func (s *authorizationService) GetAccount(ctx context.Context, accountId string) (*account.Account, error) {
+	if !canView(ctx, accountId) {
+		return nil, ForbiddenErr
+	}
+	return s.Service.GetAccount(ctx, accountId)
+}
+
+func (s *authorizationService) CloseAccount(ctx context.Context, accountId string) (*account.Account, error) {
+	if _, err := authorize(ctx, ManageAccountsPermission); err != nil {
+		return nil, err
+	}
+	return s.Service.CloseAccount(ctx, accountId)
+}
+
+func (s *authorizationService) SetAccountStatus(
+	ctx context.Context, accountId, status, reason string,
+) (*account.Account, error) {
+	if _, err := authorize(ctx, ManageAccountsPermission); err != nil {
+		return nil, err
+	}
+	return s.Service.SetAccountStatus(ctx, accountId, status, reason)
+}
+
+func (s *authorizationService) SetOverdraftLimit(
+	ctx context.Context, accountId string, limit money.Amount,
+) (*account.Account, error) {
+	if _, err := authorize(ctx, ManageAccountsPermission); err != nil {
+		return nil, err
+	}
+	return s.Service.SetOverdraftLimit(ctx, accountId, limit)
+}
+
+func (s *authorizationService) SetSpendingLimits(
+	ctx context.Context, accountId string, limits account.SpendingLimits,
+) (*account.Account, error) {
+	if _, err := authorize(ctx, ManageAccountsPermission); err != nil {
+		return nil, err
+	}
+	return s.Service.SetSpendingLimits(ctx, accountId, limits)
+}
+
+func (s *authorizationService) SetAccountTier(ctx context.Context, accountId, tier string) (*account.Account, error) {
+	if _, err := authorize(ctx, ManageAccountsPermission); err != nil {
+		return nil, err
+	}
+	return s.Service.SetAccountTier(ctx, accountId, tier)
+}
+
+func (s *authorizationService) GetLedgerDiscrepancies(ctx context.Context) ([]*ledger.Discrepancy, error) {
+	if _, err := authorize(ctx, ViewAllPermission); err != nil {
+		return nil, err
+	}
+	return s.Service.GetLedgerDiscrepancies(ctx)
+}
@@ -0,0 +1,65 @@
+package wallet
+
+import (
+	"context"
+	"github.com/georgysavva/generic-wallet/money"
+	"github.com/georgysavva/generic-wallet/payment"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestAuthorizationService(t *testing.T) {
+	s := NewAuthorizationService(instantiateServiceForTests())
+	alice := context.WithValue(
+		context.Background(), principalContextKey, &Principal{Id: "alice", Accounts: []string{"alice"}},
+	)
+	operator := context.WithValue(
+		context.Background(), principalContextKey, &Principal{Id: "support", Role: OperatorRole},
+	)
+
+	_, err := s.SendPayment(alice, "bob", "alice", money.MustParse("10"), "")
+	assert.Equal(t, AccountNotOwnedErr, err)
+	transfer, err := s.SendPayment(alice, "alice", "bob", money.MustParse("10"), "")
+	assert.Equal(t, nil, err)
+	_, err = s.SendPayment(context.Background(), "bob", "mark", money.MustParse("10"), "")
+	assert.Equal(t, nil, err)
+
+	_, err = s.GetAccount(alice, "bob")
+	assert.Equal(t, ForbiddenErr, err)
+	_, err = s.GetAccount(operator, "bob")
+	assert.Equal(t, nil, err)
+	accounts, page, err := s.GetAllAccounts(alice, Pagination{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(accounts))
+	assert.Equal(t, "alice", accounts[0].Id)
+	assert.Equal(t, 1, *page.TotalNumber)
+	accounts, _, err = s.GetAllAccounts(operator, Pagination{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 5, len(accounts))
+
+	payments, page, err := s.GetAllPayments(alice, payment.Filter{}, payment.Sort{}, Pagination{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, *page.TotalNumber)
+	assert.Equal(t, "alice", payments[0].AccountId)
+	_, _, err = s.GetAllPayments(alice, payment.Filter{AccountId: "bob"}, payment.Sort{}, Pagination{})
+	assert.Equal(t, ForbiddenErr, err)
+	_, page, err = s.GetAllPayments(operator, payment.Filter{}, payment.Sort{}, Pagination{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 4, *page.TotalNumber)
+
+	bobIncoming, _, err := s.GetAccountPayments(operator, "bob", nil, nil)
+	assert.Equal(t, nil, err)
+	_, err = s.GetPayment(alice, bobIncoming[0].Id)
+	assert.Equal(t, ForbiddenErr, err)
+	_, err = s.RefundPayment(alice, transfer.Payments[0].Id, nil)
+	assert.Equal(t, AccountNotOwnedErr, err)
+
+	_, err = s.CreateAccount(alice, "eve", "USD")
+	assert.Equal(t, ForbiddenErr, err)
+	_, err = s.SetAccountTier(alice, "alice", "premium")
+	assert.Equal(t, ForbiddenErr, err)
+	_, err = s.CreateAccount(operator, "eve", "USD")
+	assert.Equal(t, nil, err)
+	_, err = s.GetLedgerDiscrepancies(alice)
+	assert.Equal(t, ForbiddenErr, err)
+}
@@ -3,56 +3,406 @@ package wallet
 import (
 	"context"
 	"github.com/georgysavva/generic-wallet/account"
+	"github.com/georgysavva/generic-wallet/hold"
+	"github.com/georgysavva/generic-wallet/ledger"
+	"github.com/georgysavva/generic-wallet/money"
 	"github.com/georgysavva/generic-wallet/payment"
+	"github.com/georgysavva/generic-wallet/schedule"
+	"github.com/georgysavva/generic-wallet/standing"
 	"github.com/go-kit/kit/endpoint"
+	"time"
 )
 
 type sendPaymentRequest struct {
-	FromAccountId string
-	ToAccountId   string
-	Amount        float64
+	FromAccountId  string
+	ToAccountId    string
+	Amount         money.Amount
+	IdempotencyKey string
+}
+
+func (r *sendPaymentRequest) sourceAccounts() []string {
+	return []string{r.FromAccountId}
 }
 
 type sendPaymentResponse struct {
-	Ok bool `json:"ok"`
+	Ok       bool              `json:"ok"`
+	Transfer *payment.Transfer `json:"transfer"`
 }
 
 func makeSendPaymentEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(*sendPaymentRequest)
-		err := s.SendPayment(ctx, req.FromAccountId, req.ToAccountId, req.Amount)
+		transfer, err := s.SendPayment(ctx, req.FromAccountId, req.ToAccountId, req.Amount, req.IdempotencyKey)
+		if err != nil {
+			return nil, err
+		}
+		return &sendPaymentResponse{Ok: true, Transfer: transfer}, nil
+	}
+}
+
+type sendBatchPaymentRequest struct {
+	Payments []*BatchPayment `json:"payments"`
+}
+
+func (r *sendBatchPaymentRequest) sourceAccounts() []string {
+	var accountIds []string
+	for _, p := range r.Payments {
+		accountIds = append(accountIds, p.FromAccountId)
+	}
+	return accountIds
+}
+
+type sendBatchPaymentResponse struct {
+	Ok        bool                `json:"ok"`
+	Transfers []*payment.Transfer `json:"transfers"`
+}
+
+func makeSendBatchPaymentEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*sendBatchPaymentRequest)
+		transfers, err := s.SendBatchPayment(ctx, req.Payments)
+		if err != nil {
+			return nil, err
+		}
+		return &sendBatchPaymentResponse{Ok: true, Transfers: transfers}, nil
+	}
+}
+
+type authorizePaymentRequest struct {
+	FromAccountId string
+	ToAccountId   string
+	Amount        money.Amount
+	ExpiresIn     time.Duration
+}
+
+func (r *authorizePaymentRequest) sourceAccounts() []string {
+	return []string{r.FromAccountId}
+}
+
+type authorizePaymentResponse struct {
+	Ok   bool       `json:"ok"`
+	Hold *hold.Hold `json:"hold"`
+}
+
+type voidPaymentResponse struct {
+	Ok   bool       `json:"ok"`
+	Hold *hold.Hold `json:"hold"`
+}
+
+func makeAuthorizePaymentEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*authorizePaymentRequest)
+		holdRecord, err := s.AuthorizePayment(ctx, req.FromAccountId, req.ToAccountId, req.Amount, req.ExpiresIn)
+		if err != nil {
+			return nil, err
+		}
+		return &authorizePaymentResponse{Ok: true, Hold: holdRecord}, nil
+	}
+}
+
+type holdRequest struct {
+	HoldId string
+}
+
+func makeGetHoldEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*holdRequest)
+		holdRecord, err := s.GetHold(ctx, req.HoldId)
+		if err != nil {
+			return nil, err
+		}
+		return holdRecord, nil
+	}
+}
+
+type capturePaymentRequest struct {
+	HoldId string
+	// Amount is nil for a full capture.
+	Amount *money.Amount
+}
+
+type capturePaymentResponse struct {
+	Ok       bool              `json:"ok"`
+	Hold     *hold.Hold        `json:"hold"`
+	Transfer *payment.Transfer `json:"transfer"`
+}
+
+func makeCapturePaymentEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*capturePaymentRequest)
+		holdRecord, transfer, err := s.CapturePayment(ctx, req.HoldId, req.Amount)
+		if err != nil {
+			return nil, err
+		}
+		return &capturePaymentResponse{Ok: true, Hold: holdRecord, Transfer: transfer}, nil
+	}
+}
+
+func makeVoidPaymentEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*holdRequest)
+		holdRecord, err := s.VoidPayment(ctx, req.HoldId)
+		if err != nil {
+			return nil, err
+		}
+		return &voidPaymentResponse{Ok: true, Hold: holdRecord}, nil
+	}
+}
+
+type schedulePaymentRequest struct {
+	FromAccountId string
+	ToAccountId   string
+	Amount        money.Amount
+	ExecuteAt     time.Time
+}
+
+func (r *schedulePaymentRequest) sourceAccounts() []string {
+	return []string{r.FromAccountId}
+}
+
+type schedulePaymentResponse struct {
+	Ok               bool              `json:"ok"`
+	ScheduledPayment *schedule.Payment `json:"scheduled_payment"`
+}
+
+func makeSchedulePaymentEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*schedulePaymentRequest)
+		scheduledPayment, err := s.SchedulePayment(ctx, req.FromAccountId, req.ToAccountId, req.Amount, req.ExecuteAt)
+		if err != nil {
+			return nil, err
+		}
+		return &schedulePaymentResponse{Ok: true, ScheduledPayment: scheduledPayment}, nil
+	}
+}
+
+type scheduledPaymentRequest struct {
+	PaymentId string
+}
+
+func makeGetScheduledPaymentEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*scheduledPaymentRequest)
+		scheduledPayment, err := s.GetScheduledPayment(ctx, req.PaymentId)
+		if err != nil {
+			return nil, err
+		}
+		return scheduledPayment, nil
+	}
+}
+
+func makeCancelScheduledPaymentEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*scheduledPaymentRequest)
+		scheduledPayment, err := s.CancelScheduledPayment(ctx, req.PaymentId)
+		if err != nil {
+			return nil, err
+		}
+		return scheduledPayment, nil
+	}
+}
+
+type getScheduledPaymentsRequest struct {
+	*paginationRequest
+	AccountId string
+}
+
+type getScheduledPaymentsResponse struct {
+	Results []*schedule.Payment `json:"results"`
+	pageResponse
+}
+
+func makeGetScheduledPaymentsEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*getScheduledPaymentsRequest)
+		scheduledPayments, totalNumber, err := s.GetScheduledPayments(ctx, req.AccountId, req.Offset, req.Limit)
+		if err != nil {
+			return nil, err
+		}
+		if scheduledPayments == nil {
+			scheduledPayments = []*schedule.Payment{}
+		}
+		return &getScheduledPaymentsResponse{
+			Results: scheduledPayments, pageResponse: pageResponse{TotalNumber: &totalNumber},
+		}, nil
+	}
+}
+
+type createStandingOrderRequest struct {
+	FromAccountId string
+	ToAccountId   string
+	Amount        money.Amount
+	Rule          standing.Rule
+}
+
+func (r *createStandingOrderRequest) sourceAccounts() []string {
+	return []string{r.FromAccountId}
+}
+
+type createStandingOrderResponse struct {
+	Ok            bool            `json:"ok"`
+	StandingOrder *standing.Order `json:"standing_order"`
+}
+
+func makeCreateStandingOrderEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*createStandingOrderRequest)
+		order, err := s.CreateStandingOrder(ctx, req.FromAccountId, req.ToAccountId, req.Amount, req.Rule)
+		if err != nil {
+			return nil, err
+		}
+		return &createStandingOrderResponse{Ok: true, StandingOrder: order}, nil
+	}
+}
+
+type standingOrderRequest struct {
+	OrderId string
+}
+
+func makeGetStandingOrderEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*standingOrderRequest)
+		order, err := s.GetStandingOrder(ctx, req.OrderId)
 		if err != nil {
 			return nil, err
 		}
-		return &sendPaymentResponse{Ok: true}, nil
+		return order, nil
+	}
+}
+
+func makeCancelStandingOrderEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*standingOrderRequest)
+		order, err := s.CancelStandingOrder(ctx, req.OrderId)
+		if err != nil {
+			return nil, err
+		}
+		return order, nil
+	}
+}
+
+type getStandingOrdersRequest struct {
+	*paginationRequest
+	AccountId string
+}
+
+type getStandingOrdersResponse struct {
+	Results []*standing.Order `json:"results"`
+	pageResponse
+}
+
+func makeGetStandingOrdersEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*getStandingOrdersRequest)
+		orders, totalNumber, err := s.GetStandingOrders(ctx, req.AccountId, req.Offset, req.Limit)
+		if err != nil {
+			return nil, err
+		}
+		if orders == nil {
+			orders = []*standing.Order{}
+		}
+		return &getStandingOrdersResponse{Results: orders, pageResponse: pageResponse{TotalNumber: &totalNumber}}, nil
+	}
+}
+
+type getPaymentRequest struct {
+	PaymentId int64
+}
+
+func makeGetPaymentEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*getPaymentRequest)
+		paymentRecord, err := s.GetPayment(ctx, req.PaymentId)
+		if err != nil {
+			return nil, err
+		}
+		return paymentRecord, nil
+	}
+}
+
+type refundPaymentRequest struct {
+	PaymentId int64
+	// Amount is nil for a full refund.
+	Amount *money.Amount
+}
+
+type refundPaymentResponse struct {
+	Ok     bool              `json:"ok"`
+	Refund *payment.Transfer `json:"refund"`
+}
+
+func makeRefundPaymentEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*refundPaymentRequest)
+		refund, err := s.RefundPayment(ctx, req.PaymentId, req.Amount)
+		if err != nil {
+			return nil, err
+		}
+		return &refundPaymentResponse{Ok: true, Refund: refund}, nil
 	}
 }
 
 type paginationRequest struct {
 	Offset *int
 	Limit  *int
+	Cursor string
+}
+
+// pageResponse is embedded into responses with lists.
+type pageResponse struct {
+	// TotalNumber is omitted in cursor pagination mode.
+	TotalNumber *int   `json:"total_number,omitempty"`
+	NextCursor  string `json:"next_cursor,omitempty"`
+	PrevCursor  string `json:"prev_cursor,omitempty"`
+}
+
+func makePageResponse(page *Page) pageResponse {
+	return pageResponse{TotalNumber: page.TotalNumber, NextCursor: page.NextCursor, PrevCursor: page.PrevCursor}
 }
 
 type getAllPaymentsRequest struct {
 	*paginationRequest
+	Filter payment.Filter
+	Sort   payment.Sort
 }
 
 type getAllPaymentsResponse struct {
-	Results     []*payment.Payment `json:"results"`
-	TotalNumber int                `json:"total_number"`
+	Results []*payment.Payment `json:"results"`
+	pageResponse
 }
 
 func makeGetAllPaymentsEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(*getAllPaymentsRequest)
-		payments, totalNumber, err := s.GetAllPayments(ctx, req.Offset, req.Limit)
+		payments, page, err := s.GetAllPayments(
+			ctx, req.Filter, req.Sort, Pagination{Offset: req.Offset, Limit: req.Limit, Cursor: req.Cursor},
+		)
+		if err != nil {
+			return nil, err
+		}
+		if payments == nil {
+			payments = []*payment.Payment{}
+		}
+		return &getAllPaymentsResponse{Results: payments, pageResponse: makePageResponse(page)}, nil
+	}
+}
+
+type getAccountPaymentsRequest struct {
+	*paginationRequest
+	AccountId string
+}
+
+func makeGetAccountPaymentsEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*getAccountPaymentsRequest)
+		payments, totalNumber, err := s.GetAccountPayments(ctx, req.AccountId, req.Offset, req.Limit)
 		if err != nil {
 			return nil, err
 		}
 		if payments == nil {
 			payments = []*payment.Payment{}
 		}
-		return &getAllPaymentsResponse{Results: payments, TotalNumber: totalNumber}, nil
+		return &getAllPaymentsResponse{Results: payments, pageResponse: pageResponse{TotalNumber: &totalNumber}}, nil
 	}
 }
 
@@ -61,20 +411,152 @@ type getAllAccountsRequest struct {
 }
 
 type getAllAccountsResponse struct {
-	Results     []*account.Account `json:"results"`
-	TotalNumber int                `json:"total_number"`
+	Results []*account.Account `json:"results"`
+	pageResponse
 }
 
 func makeGetAllAccountsEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(*getAllAccountsRequest)
-		accounts, totalNumber, err := s.GetAllAccounts(ctx, req.Offset, req.Limit)
+		accounts, page, err := s.GetAllAccounts(
+			ctx, Pagination{Offset: req.Offset, Limit: req.Limit, Cursor: req.Cursor},
+		)
 		if err != nil {
 			return nil, err
 		}
 		if accounts == nil {
 			accounts = []*account.Account{}
 		}
-		return &getAllAccountsResponse{Results: accounts, TotalNumber: totalNumber}, nil
+		return &getAllAccountsResponse{Results: accounts, pageResponse: makePageResponse(page)}, nil
+	}
+}
+
+type createAccountRequest struct {
+	AccountId string
+	Currency  string
+}
+
+type createAccountResponse struct {
+	*account.Account
+}
+
+func makeCreateAccountEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*createAccountRequest)
+		accountRecord, err := s.CreateAccount(ctx, req.AccountId, req.Currency)
+		if err != nil {
+			return nil, err
+		}
+		return &createAccountResponse{Account: accountRecord}, nil
+	}
+}
+
+type accountRequest struct {
+	AccountId string
+}
+
+func makeGetAccountEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*accountRequest)
+		accountRecord, err := s.GetAccount(ctx, req.AccountId)
+		if err != nil {
+			return nil, err
+		}
+		return accountRecord, nil
+	}
+}
+
+func makeCloseAccountEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*accountRequest)
+		accountRecord, err := s.CloseAccount(ctx, req.AccountId)
+		if err != nil {
+			return nil, err
+		}
+		return accountRecord, nil
+	}
+}
+
+type setAccountStatusRequest struct {
+	AccountId string
+	Status    string
+	Reason    string
+}
+
+func makeSetAccountStatusEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*setAccountStatusRequest)
+		accountRecord, err := s.SetAccountStatus(ctx, req.AccountId, req.Status, req.Reason)
+		if err != nil {
+			return nil, err
+		}
+		return accountRecord, nil
+	}
+}
+
+type setOverdraftLimitRequest struct {
+	AccountId string
+	Limit     money.Amount
+}
+
+func makeSetOverdraftLimitEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*setOverdraftLimitRequest)
+		accountRecord, err := s.SetOverdraftLimit(ctx, req.AccountId, req.Limit)
+		if err != nil {
+			return nil, err
+		}
+		return accountRecord, nil
+	}
+}
+
+type setSpendingLimitsRequest struct {
+	AccountId string
+	Limits    account.SpendingLimits
+}
+
+func makeSetSpendingLimitsEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*setSpendingLimitsRequest)
+		accountRecord, err := s.SetSpendingLimits(ctx, req.AccountId, req.Limits)
+		if err != nil {
+			return nil, err
+		}
+		return accountRecord, nil
+	}
+}
+
+type setAccountTierRequest struct {
+	AccountId string
+	Tier      string
+}
+
+func makeSetAccountTierEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*setAccountTierRequest)
+		accountRecord, err := s.SetAccountTier(ctx, req.AccountId, req.Tier)
+		if err != nil {
+			return nil, err
+		}
+		return accountRecord, nil
+	}
+}
+
+type getLedgerDiscrepanciesResponse struct {
+	// Ok is true if all account balances match the ledger.
+	Ok            bool                  `json:"ok"`
+	Discrepancies []*ledger.Discrepancy `json:"discrepancies"`
+}
+
+func makeGetLedgerDiscrepanciesEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		discrepancies, err := s.GetLedgerDiscrepancies(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if discrepancies == nil {
+			discrepancies = []*ledger.Discrepancy{}
+		}
+		return &getLedgerDiscrepanciesResponse{Ok: len(discrepancies) == 0, Discrepancies: discrepancies}, nil
 	}
 }
@@ -0,0 +1,262 @@
+package wallet
+
+import (
+	"context"
+	"github.com/georgysavva/generic-wallet/account"
+	"github.com/georgysavva/generic-wallet/hold"
+	"github.com/georgysavva/generic-wallet/ledger"
+	"github.com/georgysavva/generic-wallet/metrics"
+	"github.com/georgysavva/generic-wallet/money"
+	"github.com/georgysavva/generic-wallet/payment"
+	"github.com/georgysavva/generic-wallet/schedule"
+	"github.com/georgysavva/generic-wallet/standing"
+	"time"
+)
+
+// successCode is the code label of requests, which haven't failed.
+const successCode = "OK"
+
+type instrumentingService struct {
+	requestCount   *metrics.Counter
+	requestLatency *metrics.Histogram
+	paymentVolume  *metrics.Counter
+	Service
+}
+
+// NewInstrumentingService returns an instance of a Service recording metrics of the calls into the registry:
+// the number of calls by the method and the error code, their latency and the amount of money sent by currency.
+func NewInstrumentingService(registry *metrics.Registry, s Service) Service {
+	return &instrumentingService{
+		requestCount: registry.NewCounter(
+			"wallet_requests_total", "Number of wallet service calls by the method and the error code.",
+			"method", "code",
+		),
+		requestLatency: registry.NewHistogram(
+			"wallet_request_duration_seconds", "Latency of wallet service calls in seconds.",
+			metrics.DefaultBuckets, "method",
+		),
+		paymentVolume: registry.NewCounter(
+			"wallet_payment_volume_total", "Amount of money sent in payments by the source currency.",
+			"currency",
+		),
+		Service: s,
+	}
+}
+
+// record counts the call with the API error code of the error, which is successCode if there is no error.
+func (s *instrumentingService) record(method string, begin time.Time, err error) {
+	code := successCode
+	if err != nil {
+		code, _ = errorCodeAndStatus(err)
+	}
+	s.requestCount.Add(1, method, code)
+	s.requestLatency.Observe(time.Since(begin).Seconds(), method)
+}
+
+func (s *instrumentingService) recordVolume(transfers ...*payment.Transfer) {
+	for _, transfer := range transfers {
+		// Replayed transfers have been recorded by the original requests.
+		if transfer == nil || transfer.Replayed {
+			continue
+		}
+		s.paymentVolume.Add(transfer.Amount.Float64(), transfer.Currency)
+	}
+}
+
+func (s *instrumentingService) SendPayment(
+	ctx context.Context, fromAccountId, toAccountId string, amount money.Amount, idempotencyKey string,
+) (transfer *payment.Transfer, err error) {
+	defer func(begin time.Time) {
+		s.record("send_payment", begin, err)
+		if err == nil {
+			s.recordVolume(transfer)
+		}
+	}(time.Now())
+	return s.Service.SendPayment(ctx, fromAccountId, toAccountId, amount, idempotencyKey)
+}
+
+func (s *instrumentingService) SendBatchPayment(
+	ctx context.Context, payments []*BatchPayment,
+) (transfers []*payment.Transfer, err error) {
+	defer func(begin time.Time) {
+		s.record("send_batch_payment", begin, err)
+		if err == nil {
+			s.recordVolume(transfers...)
+		}
+	}(time.Now())
+	return s.Service.SendBatchPayment(ctx, payments)
+}
+
+func (s *instrumentingService) AuthorizePayment(
+	ctx context.Context, fromAccountId, toAccountId string, amount money.Amount, expiresIn time.Duration,
+) (h *hold.Hold, err error) {
+	defer func(begin time.Time) { s.record("authorize_payment", begin, err) }(time.Now())
+	return s.Service.AuthorizePayment(ctx, fromAccountId, toAccountId, amount, expiresIn)
+}
+
+func (s *instrumentingService) GetHold(ctx context.Context, holdId string) (h *hold.Hold, err error) {
+	defer func(begin time.Time) { s.record("get_hold", begin, err) }(time.Now())
+	return s.Service.GetHold(ctx, holdId)
+}
+
+func (s *instrumentingService) CapturePayment(
+	ctx context.Context, holdId string, amount *money.Amount,
+) (h *hold.Hold, transfer *payment.Transfer, err error) {
+	defer func(begin time.Time) {
+		s.record("capture_payment", begin, err)
+		if err == nil {
+			s.recordVolume(transfer)
+		}
+	}(time.Now())
+	return s.Service.CapturePayment(ctx, holdId, amount)
+}
+
+func (s *instrumentingService) VoidPayment(ctx context.Context, holdId string) (h *hold.Hold, err error) {
+	defer func(begin time.Time) { s.record("void_payment", begin, err) }(time.Now())
+	return s.Service.VoidPayment(ctx, holdId)
+}
+
+func (s *instrumentingService) SchedulePayment(
+	ctx context.Context, fromAccountId, toAccountId string, amount money.Amount, executeAt time.Time,
+) (p *schedule.Payment, err error) {
+	defer func(begin time.Time) { s.record("schedule_payment", begin, err) }(time.Now())
+	return s.Service.SchedulePayment(ctx, fromAccountId, toAccountId, amount, executeAt)
+}
+
+func (s *instrumentingService) GetScheduledPayment(
+	ctx context.Context, paymentId string,
+) (p *schedule.Payment, err error) {
+	defer func(begin time.Time) { s.record("get_scheduled_payment", begin, err) }(time.Now())
+	return s.Service.GetScheduledPayment(ctx, paymentId)
+}
+
+func (s *instrumentingService) GetScheduledPayments(
+	ctx context.Context, accountId string, offset, limit *int,
+) (payments []*schedule.Payment, total int, err error) {
+	defer func(begin time.Time) { s.record("get_scheduled_payments", begin, err) }(time.Now())
+	return s.Service.GetScheduledPayments(ctx, accountId, offset, limit)
+}
+
+func (s *instrumentingService) CancelScheduledPayment(
+	ctx context.Context, paymentId string,
+) (p *schedule.Payment, err error) {
+	defer func(begin time.Time) { s.record("cancel_scheduled_payment", begin, err) }(time.Now())
+	return s.Service.CancelScheduledPayment(ctx, paymentId)
+}
+
+func (s *instrumentingService) CreateStandingOrder(
+	ctx context.Context, fromAccountId, toAccountId string, amount money.Amount, rule standing.Rule,
+) (order *standing.Order, err error) {
+	defer func(begin time.Time) { s.record("create_standing_order", begin, err) }(time.Now())
+	return s.Service.CreateStandingOrder(ctx, fromAccountId, toAccountId, amount, rule)
+}
+
+func (s *instrumentingService) GetStandingOrder(
+	ctx context.Context, orderId string,
+) (order *standing.Order, err error) {
+	defer func(begin time.Time) { s.record("get_standing_order", begin, err) }(time.Now())
+	return s.Service.GetStandingOrder(ctx, orderId)
+}
+
+func (s *instrumentingService) GetStandingOrders(
+	ctx context.Context, accountId string, offset, limit *int,
+) (orders []*standing.Order, total int, err error) {
+	defer func(begin time.Time) { s.record("get_standing_orders", begin, err) }(time.Now())
+	return s.Service.GetStandingOrders(ctx, accountId, offset, limit)
+}
+
+func (s *instrumentingService) CancelStandingOrder(
+	ctx context.Context, orderId string,
+) (order *standing.Order, err error) {
+	defer func(begin time.Time) { s.record("cancel_standing_order", begin, err) }(time.Now())
+	return s.Service.CancelStandingOrder(ctx, orderId)
+}
+
+func (s *instrumentingService) GetPayment(ctx context.Context, paymentId int64) (p *payment.Payment, err error) {
+	defer func(begin time.Time) { s.record("get_payment", begin, err) }(time.Now())
+	return s.Service.GetPayment(ctx, paymentId)
+}
+
+func (s *instrumentingService) RefundPayment(
+	ctx context.Context, paymentId int64, amount *money.Amount,
+) (transfer *payment.Transfer, err error) {
+	defer func(begin time.Time) { s.record("refund_payment", begin, err) }(time.Now())
+	return s.Service.RefundPayment(ctx, paymentId, amount)
+}
+
+func (s *instrumentingService) GetAllPayments(
+	ctx context.Context, filter payment.Filter, sort payment.Sort, pagination Pagination,
+) (payments []*payment.Payment, page *Page, err error) {
+	defer func(begin time.Time) { s.record("get_all_payments", begin, err) }(time.Now())
+	return s.Service.GetAllPayments(ctx, filter, sort, pagination)
+}
+
+func (s *instrumentingService) GetAllAccounts(
+	ctx context.Context, pagination Pagination,
+) (accounts []*account.Account, page *Page, err error) {
+	defer func(begin time.Time) { s.record("get_all_accounts", begin, err) }(time.Now())
+	return s.Service.GetAllAccounts(ctx, pagination)
+}
+
+func (s *instrumentingService) GetAccountPayments(
+	ctx context.Context, accountId string, offset, limit *int,
+) (payments []*payment.Payment, total int, err error) {
+	defer func(begin time.Time) { s.record("get_account_payments", begin, err) }(time.Now())
+	return s.Service.GetAccountPayments(ctx, accountId, offset, limit)
+}
+
+func (s *instrumentingService) CreateAccount(
+	ctx context.Context, accountId, currency string,
+) (accountRecord *account.Account, err error) {
+	defer func(begin time.Time) { s.record("create_account", begin, err) }(time.Now())
+	return s.Service.CreateAccount(ctx, accountId, currency)
+}
+
+func (s *instrumentingService) GetAccount(
+	ctx context.Context, accountId string,
+) (accountRecord *account.Account, err error) {
+	defer func(begin time.Time) { s.record("get_account", begin, err) }(time.Now())
+	return s.Service.GetAccount(ctx, accountId)
+}
+
+func (s *instrumentingService) CloseAccount(
+	ctx context.Context, accountId string,
+) (accountRecord *account.Account, err error) {
+	defer func(begin time.Time) { s.record("close_account", begin, err) }(time.Now())
+	return s.Service.CloseAccount(ctx, accountId)
+}
+
+func (s *instrumentingService) SetAccountStatus(
+	ctx context.Context, accountId, status, reason string,
+) (accountRecord *account.Account, err error) {
+	defer func(begin time.Time) { s.record("set_account_status", begin, err) }(time.Now())
+	return s.Service.SetAccountStatus(ctx, accountId, status, reason)
+}
+
+func (s *instrumentingService) SetOverdraftLimit(
+	ctx context.Context, accountId string, limit money.Amount,
+) (accountRecord *account.Account, err error) {
+	defer func(begin time.Time) { s.record("set_overdraft_limit", begin, err) }(time.Now())
+	return s.Service.SetOverdraftLimit(ctx, accountId, limit)
+}
+
+func (s *instrumentingService) SetSpendingLimits(
+	ctx context.Context, accountId string, limits account.SpendingLimits,
+) (accountRecord *account.Account, err error) {
+	defer func(begin time.Time) { s.record("set_spending_limits", begin, err) }(time.Now())
+	return s.Service.SetSpendingLimits(ctx, accountId, limits)
+}
+
+func (s *instrumentingService) SetAccountTier(
+	ctx context.Context, accountId, tier string,
+) (accountRecord *account.Account, err error) {
+	defer func(begin time.Time) { s.record("set_account_tier", begin, err) }(time.Now())
+	return s.Service.SetAccountTier(ctx, accountId, tier)
+}
+
+func (s *instrumentingService) GetLedgerDiscrepancies(
+	ctx context.Context,
+) (discrepancies []*ledger.Discrepancy, err error) {
+	defer func(begin time.Time) { s.record("get_ledger_discrepancies", begin, err) }(time.Now())
+	return s.Service.GetLedgerDiscrepancies(ctx)
+}
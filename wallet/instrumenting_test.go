@@ -0,0 +1,33 @@
+package wallet
+
+import (
+	"context"
+	"github.com/georgysavva/generic-wallet/metrics"
+	"github.com/georgysavva/generic-wallet/money"
+	"github.com/georgysavva/generic-wallet/payment"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInstrumentingService(t *testing.T) {
+	registry := metrics.NewRegistry()
+	s := NewInstrumentingService(registry, instantiateServiceForTests())
+
+	_, err := s.SendPayment(context.Background(), "alice", "bob", money.MustParse("10.5"), "key-1")
+	assert.Equal(t, nil, err)
+	// The retry doesn't add to the payment volume.
+	transfer, err := s.SendPayment(context.Background(), "alice", "bob", money.MustParse("10.5"), "key-1")
+	assert.Equal(t, nil, err)
+	assert.True(t, transfer.Replayed)
+	_, err = s.SendPayment(context.Background(), "alice", "bob", money.MustParse("1000"), "")
+	assert.Equal(t, payment.LowBalanceErr, err)
+
+	w := httptest.NewRecorder()
+	registry.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	assert.Contains(t, body, `wallet_requests_total{method="send_payment",code="OK"} 2`)
+	assert.Contains(t, body, `wallet_requests_total{method="send_payment",code="LOW_BALANCE"} 1`)
+	assert.Contains(t, body, `wallet_request_duration_seconds_count{method="send_payment"} 3`)
+	assert.Contains(t, body, `wallet_payment_volume_total{currency="USD"} 10.5`)
+}
@@ -3,8 +3,14 @@ package wallet
 import (
 	"context"
 	"github.com/georgysavva/generic-wallet/account"
+	"github.com/georgysavva/generic-wallet/hold"
+	"github.com/georgysavva/generic-wallet/ledger"
+	"github.com/georgysavva/generic-wallet/money"
 	"github.com/georgysavva/generic-wallet/payment"
+	"github.com/georgysavva/generic-wallet/schedule"
+	"github.com/georgysavva/generic-wallet/standing"
 	"github.com/go-kit/kit/log"
+	"time"
 )
 
 type loggingService struct {
@@ -17,30 +23,289 @@ func NewLoggingService(logger log.Logger, s Service) Service {
 	return &loggingService{logger, s}
 }
 
-func (s *loggingService) SendPayment(ctx context.Context, fromAccountId, toAccountId string, amount float64) error {
-	s.logger.Log(
+func (s *loggingService) SendPayment(
+	ctx context.Context, fromAccountId, toAccountId string, amount money.Amount, idempotencyKey string,
+) (*payment.Transfer, error) {
+	contextLogger(ctx, s.logger).Log(
 		"method", "send_payment",
 		"from_account", fromAccountId,
 		"to_account", toAccountId,
 		"amount", amount,
+		"idempotency_key", idempotencyKey,
 	)
-	return s.Service.SendPayment(ctx, fromAccountId, toAccountId, amount)
+	return s.Service.SendPayment(ctx, fromAccountId, toAccountId, amount, idempotencyKey)
 }
 
-func (s *loggingService) GetAllPayments(ctx context.Context, offset, limit *int) ([]*payment.Payment, int, error) {
-	s.logger.Log(
-		"method", "get_all_payments",
+func (s *loggingService) SendBatchPayment(
+	ctx context.Context, payments []*BatchPayment,
+) ([]*payment.Transfer, error) {
+	contextLogger(ctx, s.logger).Log(
+		"method", "send_batch_payment",
+		"payments_number", len(payments),
+	)
+	return s.Service.SendBatchPayment(ctx, payments)
+}
+
+func (s *loggingService) AuthorizePayment(
+	ctx context.Context, fromAccountId, toAccountId string, amount money.Amount, expiresIn time.Duration,
+) (*hold.Hold, error) {
+	contextLogger(ctx, s.logger).Log(
+		"method", "authorize_payment",
+		"from_account", fromAccountId,
+		"to_account", toAccountId,
+		"amount", amount,
+		"expires_in", expiresIn,
+	)
+	return s.Service.AuthorizePayment(ctx, fromAccountId, toAccountId, amount, expiresIn)
+}
+
+func (s *loggingService) GetHold(ctx context.Context, holdId string) (*hold.Hold, error) {
+	contextLogger(ctx, s.logger).Log(
+		"method", "get_hold",
+		"hold", holdId,
+	)
+	return s.Service.GetHold(ctx, holdId)
+}
+
+func (s *loggingService) CapturePayment(
+	ctx context.Context, holdId string, amount *money.Amount,
+) (*hold.Hold, *payment.Transfer, error) {
+	contextLogger(ctx, s.logger).Log(
+		"method", "capture_payment",
+		"hold", holdId,
+		"amount", amount,
+	)
+	return s.Service.CapturePayment(ctx, holdId, amount)
+}
+
+func (s *loggingService) VoidPayment(ctx context.Context, holdId string) (*hold.Hold, error) {
+	contextLogger(ctx, s.logger).Log(
+		"method", "void_payment",
+		"hold", holdId,
+	)
+	return s.Service.VoidPayment(ctx, holdId)
+}
+
+func (s *loggingService) SchedulePayment(
+	ctx context.Context, fromAccountId, toAccountId string, amount money.Amount, executeAt time.Time,
+) (*schedule.Payment, error) {
+	contextLogger(ctx, s.logger).Log(
+		"method", "schedule_payment",
+		"from_account", fromAccountId,
+		"to_account", toAccountId,
+		"amount", amount,
+		"execute_at", executeAt,
+	)
+	return s.Service.SchedulePayment(ctx, fromAccountId, toAccountId, amount, executeAt)
+}
+
+func (s *loggingService) GetScheduledPayment(ctx context.Context, paymentId string) (*schedule.Payment, error) {
+	contextLogger(ctx, s.logger).Log(
+		"method", "get_scheduled_payment",
+		"scheduled_payment", paymentId,
+	)
+	return s.Service.GetScheduledPayment(ctx, paymentId)
+}
+
+func (s *loggingService) GetScheduledPayments(
+	ctx context.Context, accountId string, offset, limit *int,
+) ([]*schedule.Payment, int, error) {
+	contextLogger(ctx, s.logger).Log(
+		"method", "get_scheduled_payments",
+		"account", accountId,
+		"offset", offset,
+		"limit", limit,
+	)
+	return s.Service.GetScheduledPayments(ctx, accountId, offset, limit)
+}
+
+func (s *loggingService) CancelScheduledPayment(ctx context.Context, paymentId string) (*schedule.Payment, error) {
+	contextLogger(ctx, s.logger).Log(
+		"method", "cancel_scheduled_payment",
+		"scheduled_payment", paymentId,
+	)
+	return s.Service.CancelScheduledPayment(ctx, paymentId)
+}
+
+func (s *loggingService) CreateStandingOrder(
+	ctx context.Context, fromAccountId, toAccountId string, amount money.Amount, rule standing.Rule,
+) (*standing.Order, error) {
+	contextLogger(ctx, s.logger).Log(
+		"method", "create_standing_order",
+		"from_account", fromAccountId,
+		"to_account", toAccountId,
+		"amount", amount,
+		"frequency", rule.Frequency,
+		"start_at", rule.StartAt,
+		"end_at", rule.EndAt,
+		"count", rule.Count,
+	)
+	return s.Service.CreateStandingOrder(ctx, fromAccountId, toAccountId, amount, rule)
+}
+
+func (s *loggingService) GetStandingOrder(ctx context.Context, orderId string) (*standing.Order, error) {
+	contextLogger(ctx, s.logger).Log(
+		"method", "get_standing_order",
+		"standing_order", orderId,
+	)
+	return s.Service.GetStandingOrder(ctx, orderId)
+}
+
+func (s *loggingService) GetStandingOrders(
+	ctx context.Context, accountId string, offset, limit *int,
+) ([]*standing.Order, int, error) {
+	contextLogger(ctx, s.logger).Log(
+		"method", "get_standing_orders",
+		"account", accountId,
 		"offset", offset,
 		"limit", limit,
 	)
-	return s.Service.GetAllPayments(ctx, offset, limit)
+	return s.Service.GetStandingOrders(ctx, accountId, offset, limit)
+}
+
+func (s *loggingService) CancelStandingOrder(ctx context.Context, orderId string) (*standing.Order, error) {
+	contextLogger(ctx, s.logger).Log(
+		"method", "cancel_standing_order",
+		"standing_order", orderId,
+	)
+	return s.Service.CancelStandingOrder(ctx, orderId)
+}
+
+func (s *loggingService) GetPayment(ctx context.Context, paymentId int64) (*payment.Payment, error) {
+	contextLogger(ctx, s.logger).Log(
+		"method", "get_payment",
+		"payment", paymentId,
+	)
+	return s.Service.GetPayment(ctx, paymentId)
+}
+
+func (s *loggingService) RefundPayment(
+	ctx context.Context, paymentId int64, amount *money.Amount,
+) (*payment.Transfer, error) {
+	contextLogger(ctx, s.logger).Log(
+		"method", "refund_payment",
+		"payment", paymentId,
+		"amount", amount,
+	)
+	return s.Service.RefundPayment(ctx, paymentId, amount)
+}
+
+func (s *loggingService) GetAllPayments(
+	ctx context.Context, filter payment.Filter, sort payment.Sort, pagination Pagination,
+) ([]*payment.Payment, *Page, error) {
+	contextLogger(ctx, s.logger).Log(
+		"method", "get_all_payments",
+		"account", filter.AccountId,
+		"counterparty", filter.CounterpartyId,
+		"direction", filter.Direction,
+		"min_amount", filter.MinAmount,
+		"max_amount", filter.MaxAmount,
+		"created_from", filter.CreatedFrom,
+		"created_to", filter.CreatedTo,
+		"sort", sort.By,
+		"descending", sort.Descending,
+		"offset", pagination.Offset,
+		"limit", pagination.Limit,
+		"cursor", pagination.Cursor,
+	)
+	return s.Service.GetAllPayments(ctx, filter, sort, pagination)
 }
 
-func (s *loggingService) GetAllAccounts(ctx context.Context, offset, limit *int) ([]*account.Account, int, error) {
-	s.logger.Log(
+func (s *loggingService) GetAllAccounts(ctx context.Context, pagination Pagination) ([]*account.Account, *Page, error) {
+	contextLogger(ctx, s.logger).Log(
 		"method", "get_all_accounts",
+		"offset", pagination.Offset,
+		"limit", pagination.Limit,
+		"cursor", pagination.Cursor,
+	)
+	return s.Service.GetAllAccounts(ctx, pagination)
+}
+
+func (s *loggingService) GetAccountPayments(
+	ctx context.Context, accountId string, offset, limit *int,
+) ([]*payment.Payment, int, error) {
+	contextLogger(ctx, s.logger).Log(
+		"method", "get_account_payments",
+		"account", accountId,
 		"offset", offset,
 		"limit", limit,
 	)
-	return s.Service.GetAllAccounts(ctx, offset, limit)
+	return s.Service.GetAccountPayments(ctx, accountId, offset, limit)
+}
+
+func (s *loggingService) CreateAccount(ctx context.Context, accountId, currency string) (*account.Account, error) {
+	contextLogger(ctx, s.logger).Log(
+		"method", "create_account",
+		"account", accountId,
+		"currency", currency,
+	)
+	return s.Service.CreateAccount(ctx, accountId, currency)
+}
+
+func (s *loggingService) GetAccount(ctx context.Context, accountId string) (*account.Account, error) {
+	contextLogger(ctx, s.logger).Log(
+		"method", "get_account",
+		"account", accountId,
+	)
+	return s.Service.GetAccount(ctx, accountId)
+}
+
+func (s *loggingService) CloseAccount(ctx context.Context, accountId string) (*account.Account, error) {
+	contextLogger(ctx, s.logger).Log(
+		"method", "close_account",
+		"account", accountId,
+	)
+	return s.Service.CloseAccount(ctx, accountId)
+}
+
+func (s *loggingService) SetAccountStatus(
+	ctx context.Context, accountId, status, reason string,
+) (*account.Account, error) {
+	contextLogger(ctx, s.logger).Log(
+		"method", "set_account_status",
+		"account", accountId,
+		"status", status,
+		"reason", reason,
+	)
+	return s.Service.SetAccountStatus(ctx, accountId, status, reason)
+}
+
+func (s *loggingService) SetOverdraftLimit(
+	ctx context.Context, accountId string, limit money.Amount,
+) (*account.Account, error) {
+	contextLogger(ctx, s.logger).Log(
+		"method", "set_overdraft_limit",
+		"account", accountId,
+		"limit", limit,
+	)
+	return s.Service.SetOverdraftLimit(ctx, accountId, limit)
+}
+
+func (s *loggingService) SetSpendingLimits(
+	ctx context.Context, accountId string, limits account.SpendingLimits,
+) (*account.Account, error) {
+	contextLogger(ctx, s.logger).Log(
+		"method", "set_spending_limits",
+		"account", accountId,
+		"per_transaction", limits.PerTransaction,
+		"daily", limits.Daily,
+		"monthly", limits.Monthly,
+	)
+	return s.Service.SetSpendingLimits(ctx, accountId, limits)
+}
+
+func (s *loggingService) SetAccountTier(ctx context.Context, accountId, tier string) (*account.Account, error) {
+	contextLogger(ctx, s.logger).Log(
+		"method", "set_account_tier",
+		"account", accountId,
+		"tier", tier,
+	)
+	return s.Service.SetAccountTier(ctx, accountId, tier)
+}
+
+func (s *loggingService) GetLedgerDiscrepancies(ctx context.Context) ([]*ledger.Discrepancy, error) {
+	contextLogger(ctx, s.logger).Log(
+		"method", "get_ledger_discrepancies",
+	)
+	return s.Service.GetLedgerDiscrepancies(ctx)
 }
@@ -0,0 +1,94 @@
+package wallet
+
+import (
+	"context"
+	"github.com/georgysavva/generic-wallet/outbox"
+	"github.com/go-kit/kit/log"
+	"time"
+)
+
+// outboxClaimTimeout must be long enough for a batch of events to be published,
+// claimed events are published again after it passes.
+const outboxClaimTimeout = 5 * time.Minute
+
+// maxOutboxRetryInterval bounds the exponential backoff of the delivery retries.
+const maxOutboxRetryInterval = 10 * time.Minute
+
+// OutboxRelay publishes the events stored in the outbox in their order.
+// Several relays can run at once, e.g. in different service replicas, only one of them publishes at a time.
+// Events are delivered at least once: an event published right before a crash is published again.
+// An event failing maxAttempts times is moved to the dead letters, so it doesn't block the later events forever.
+type OutboxRelay struct {
+	events        outbox.Repository
+	publisher     outbox.Publisher
+	interval      time.Duration
+	batchSize     int
+	retryInterval time.Duration
+	maxAttempts   int
+	claimTimeout  time.Duration
+	logger        log.Logger
+}
+
+func NewOutboxRelay(
+	events outbox.Repository, publisher outbox.Publisher, interval time.Duration, batchSize int,
+	retryInterval time.Duration, maxAttempts int, logger log.Logger,
+) *OutboxRelay {
+	return &OutboxRelay{
+		events:        events,
+		publisher:     publisher,
+		interval:      interval,
+		batchSize:     batchSize,
+		retryInterval: retryInterval,
+		maxAttempts:   maxAttempts,
+		claimTimeout:  outboxClaimTimeout,
+		logger:        logger,
+	}
+}
+
+// Run publishes pending events every interval until the context is done.
+func (r *OutboxRelay) Run(ctx context.Context) {
+	runPeriodically(ctx, r.interval, r.logger, "outbox events", r.PublishPending)
+}
+
+// PublishPending publishes pending events in their order and returns the number of published ones.
+// It stops at the first event that fails to be published, so the later events wait for it to be retried,
+// unless the event has run out of attempts and is moved to the dead letters.
+func (r *OutboxRelay) PublishPending(ctx context.Context) (int, error) {
+	var published int
+	for {
+		claimed, err := r.events.ClaimPending(ctx, r.batchSize, r.claimTimeout)
+		if err != nil {
+			return published, err
+		}
+		for _, event := range claimed {
+			publishErr := r.publisher.Publish(ctx, event)
+			if publishErr != nil && event.Attempts+1 >= r.maxAttempts {
+				r.logger.Log(
+					"msg", "Outbox event is moved to the dead letters", "event", event.Id, "attempts", event.Attempts+1,
+					"err", publishErr,
+				)
+				err = r.events.MarkDead(ctx, event.Id, publishErr)
+				if err != nil {
+					return published, err
+				}
+				continue
+			}
+			if publishErr != nil {
+				retryIn := outbox.Backoff(event.Attempts+1, r.retryInterval, maxOutboxRetryInterval)
+				r.logger.Log(
+					"msg", "Can't publish outbox event", "event", event.Id, "attempts", event.Attempts+1,
+					"retry_in", retryIn, "err", publishErr,
+				)
+				return published, r.events.MarkFailed(ctx, event.Id, publishErr, time.Now().Add(retryIn))
+			}
+			err = r.events.MarkPublished(ctx, event.Id)
+			if err != nil {
+				return published, err
+			}
+			published++
+		}
+		if len(claimed) < r.batchSize {
+			return published, nil
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package wallet
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/georgysavva/generic-wallet/account"
+	"github.com/georgysavva/generic-wallet/inmem_repository"
+	"github.com/georgysavva/generic-wallet/money"
+	"github.com/georgysavva/generic-wallet/outbox"
+	"github.com/georgysavva/generic-wallet/payment"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// publisherForTests records the events it's called with and fails them while err is set.
+type publisherForTests struct {
+	failed    []*outbox.Event
+	published []*outbox.Event
+	err       error
+}
+
+func (p *publisherForTests) Publish(ctx context.Context, event *outbox.Event) error {
+	if p.err != nil {
+		p.failed = append(p.failed, event)
+		return p.err
+	}
+	p.published = append(p.published, event)
+	return nil
+}
+
+func TestOutboxRelay(t *testing.T) {
+	accounts := []*account.Account{
+		{Id: "alice", Balance: money.MustParse("100"), Currency: "USD", Status: account.ActiveStatus},
+		{Id: "bob", Balance: money.MustParse("100"), Currency: "USD", Status: account.ActiveStatus},
+	}
+	repositories := inmem_repository.InstantiateRepositories(accounts, nil)
+	s := newServiceForTests(repositories)
+	ctx := context.Background()
+	publisher := &publisherForTests{}
+	relay := NewOutboxRelay(repositories.Outbox, publisher, time.Second, 1, time.Hour, 10, kitlog.NewNopLogger())
+
+	first, err := s.SendPayment(ctx, "alice", "bob", money.MustParse("10"), "")
+	assert.Equal(t, err, nil)
+	// A rejected payment doesn't emit an event.
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("1000"), "")
+	assert.Equal(t, payment.LowBalanceErr, err)
+	second, _ := s.SendPayment(ctx, "bob", "alice", money.MustParse("5"), "")
+
+	// The first event fails, so neither of them is published until it's retried.
+	publisher.err = errors.New("connection refused")
+	published, err := relay.PublishPending(ctx)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 0, published)
+	assert.Equal(t, 1, len(publisher.failed))
+	failedEvent := publisher.failed[0]
+	assert.Equal(t, 1, failedEvent.Attempts)
+	assert.Equal(t, "connection refused", failedEvent.LastError)
+	publisher.err = nil
+	published, err = relay.PublishPending(ctx)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 0, published)
+
+	// The in-memory repository keeps the stored events, so the retry can be made due in place.
+	failedEvent.NextAttemptAt = time.Now()
+	published, err = relay.PublishPending(ctx)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 2, published)
+
+	assert.Equal(t, 2, len(publisher.published))
+	assert.Equal(t, outbox.PaymentCompletedEvent, publisher.published[0].Type)
+	assert.Equal(t, first.Id, publisher.published[0].Key)
+	assert.Equal(t, second.Id, publisher.published[1].Key)
+	var transfer payment.Transfer
+	assert.Equal(t, nil, json.Unmarshal(publisher.published[1].Payload, &transfer))
+	assert.Equal(t, "bob", transfer.FromAccountId)
+	assert.Equal(t, money.MustParse("5"), transfer.Amount)
+
+	// Published events aren't published again.
+	published, err = relay.PublishPending(ctx)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 0, published)
+}
+
+func TestOutboxRelay_DeadLetters(t *testing.T) {
+	accounts := []*account.Account{
+		{Id: "alice", Balance: money.MustParse("100"), Currency: "USD", Status: account.ActiveStatus},
+		{Id: "bob", Balance: money.MustParse("100"), Currency: "USD", Status: account.ActiveStatus},
+	}
+	repositories := inmem_repository.InstantiateRepositories(accounts, nil)
+	s := newServiceForTests(repositories)
+	ctx := context.Background()
+	publisher := &publisherForTests{err: errors.New("event rejected")}
+	relay := NewOutboxRelay(repositories.Outbox, publisher, time.Second, 10, time.Hour, 2, kitlog.NewNopLogger())
+
+	_, _ = s.SendPayment(ctx, "alice", "bob", money.MustParse("10"), "")
+	second, _ := s.SendPayment(ctx, "bob", "alice", money.MustParse("5"), "")
+	published, err := relay.PublishPending(ctx)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 0, published)
+	deadEvent := publisher.failed[0]
+	deadEvent.NextAttemptAt = time.Now()
+
+	// The first event runs out of attempts and doesn't hold back the second one anymore.
+	published, err = relay.PublishPending(ctx)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 0, published)
+	assert.Equal(t, 2, deadEvent.Attempts)
+	assert.Equal(t, 3, len(publisher.failed))
+	assert.Equal(t, second.Id, publisher.failed[2].Key)
+	publisher.err = nil
+	publisher.failed[2].NextAttemptAt = time.Now()
+	published, err = relay.PublishPending(ctx)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 1, published)
+	assert.Equal(t, second.Id, publisher.published[0].Key)
+
+	published, err = relay.PublishPending(ctx)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 0, published)
+}
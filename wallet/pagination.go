@@ -0,0 +1,112 @@
+package wallet
+
+import (
+	"encoding/base64"
+	"encoding/json"
+)
+
+const (
+	// Names of lists, a cursor can be used only with the list it was issued for.
+	paymentsList = "payments"
+	accountsList = "accounts"
+)
+
+// Pagination selects a page of a list either by offset or by a cursor returned with a previous page.
+// Cursor pagination doesn't skip or repeat items when new items are inserted into the list.
+type Pagination struct {
+	Offset *int
+	Limit  *int
+	Cursor string
+}
+
+// Page describes a returned page of a list.
+type Page struct {
+	// TotalNumber is counted only in offset mode, it's nil in cursor mode.
+	TotalNumber *int
+	// NextCursor and PrevCursor are empty if there is no next or previous page.
+	NextCursor string
+	PrevCursor string
+}
+
+// cursor is encoded into an opaque token, so clients don't depend on its structure.
+// It points to an item of the list, the page contains items either after or before it.
+type cursor struct {
+	List   string `json:"l"`
+	After  string `json:"a,omitempty"`
+	Before string `json:"b,omitempty"`
+}
+
+func encodeCursor(c *cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(token, list string) (*cursor, error) {
+	invalidCursorErr := &IncorrectInputData{"'cursor' pagination parameter is invalid"}
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, invalidCursorErr
+	}
+	c := &cursor{}
+	err = json.Unmarshal(b, c)
+	if err != nil || c.List != list || (c.After == "") == (c.Before == "") {
+		return nil, invalidCursorErr
+	}
+	return c, nil
+}
+
+// prepareListPagination validates pagination and fills in the defaults.
+func prepareListPagination(pagination Pagination) (Pagination, error) {
+	if pagination.Cursor != "" && pagination.Offset != nil {
+		return Pagination{}, &IncorrectInputData{"'offset' and 'cursor' pagination parameters can't be used together"}
+	}
+	offset, limit, err := preparePagination(pagination.Offset, pagination.Limit)
+	if err != nil {
+		return Pagination{}, err
+	}
+	return Pagination{Offset: offset, Limit: limit, Cursor: pagination.Cursor}, nil
+}
+
+// offsetPage returns cursors pointing to the neighbours of a page fetched by offset.
+// keys identify the fetched items in the list order.
+func offsetPage(list string, keys []string, offset *int, totalNumber int) *Page {
+	page := &Page{TotalNumber: &totalNumber}
+	if len(keys) == 0 {
+		return page
+	}
+	if offset != nil && *offset > 0 {
+		page.PrevCursor = encodeCursor(&cursor{List: list, Before: keys[0]})
+	}
+	pageEnd := len(keys)
+	if offset != nil {
+		pageEnd += *offset
+	}
+	if pageEnd < totalNumber {
+		page.NextCursor = encodeCursor(&cursor{List: list, After: keys[len(keys)-1]})
+	}
+	return page
+}
+
+// cursorPage handles items fetched by cursor. Repositories are asked for limit+1 items,
+// the extra item tells if there are more items in the direction of the cursor and isn't returned.
+// It returns bounds of the page within the fetched items and cursors pointing to its neighbours.
+func cursorPage(list string, c *cursor, keys []string, limit int) (int, int, *Page) {
+	page := &Page{}
+	start, end := 0, len(keys)
+	hasMore := len(keys) > limit
+	if hasMore && c.After != "" {
+		end = limit
+	} else if hasMore {
+		start = len(keys) - limit
+	}
+	if start == end {
+		return start, end, page
+	}
+	if c.Before == "" || hasMore {
+		page.PrevCursor = encodeCursor(&cursor{List: list, Before: keys[start]})
+	}
+	if c.After == "" || hasMore {
+		page.NextCursor = encodeCursor(&cursor{List: list, After: keys[end-1]})
+	}
+	return start, end, page
+}
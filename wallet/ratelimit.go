@@ -0,0 +1,180 @@
+package wallet
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-kit/kit/endpoint"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimitedError is returned when the client has exceeded its request rate.
+type RateLimitedError struct {
+	// RetryAfter is the time until the client can make the next request.
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("too many requests, retry after %s", e.RetryAfter)
+}
+
+// RateLimits are applied to the API requests of every client, nil limiters don't limit the requests.
+type RateLimits struct {
+	// Reads limit requests getting data.
+	Reads *RateLimiter
+	// Writes limit requests sending payments and changing data.
+	Writes *RateLimiter
+	// AuthFailures limit requests failing authentication per IP address, so credentials can't be brute forced.
+	// It's applied before authentication, an address is blocked after it has failed too often.
+	AuthFailures *RateLimiter
+}
+
+// RateLimiter limits request rates with a token bucket per client.
+type RateLimiter struct {
+	// perSecond is the rate the buckets are refilled with.
+	perSecond float64
+	burst     float64
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	// Full buckets are the same as missing ones, so they are removed from time to time to free the memory.
+	lastCleanup time.Time
+	now         func() time.Time
+}
+
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// NewRateLimiter returns a limiter allowing each client perSecond requests on average
+// and up to burst requests at once.
+func NewRateLimiter(perSecond float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		perSecond: perSecond, burst: float64(burst), buckets: map[string]*tokenBucket{}, now: time.Now,
+	}
+}
+
+// allow takes a token from the bucket of the client.
+// It returns the time until the next token if the bucket is empty.
+func (l *RateLimiter) allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	bucket := l.refill(client)
+	if bucket.tokens < 1 {
+		return false, l.nextTokenIn(bucket)
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// check is like allow, but it doesn't take the token.
+func (l *RateLimiter) check(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	bucket := l.refill(client)
+	if bucket.tokens < 1 {
+		return false, l.nextTokenIn(bucket)
+	}
+	return true, 0
+}
+
+// refill returns the bucket of the client with the tokens added since its last update.
+func (l *RateLimiter) refill(client string) *tokenBucket {
+	now := l.now()
+	l.cleanup(now)
+	bucket := l.buckets[client]
+	if bucket == nil {
+		bucket = &tokenBucket{tokens: l.burst, updatedAt: now}
+		l.buckets[client] = bucket
+	}
+	bucket.tokens += now.Sub(bucket.updatedAt).Seconds() * l.perSecond
+	if bucket.tokens > l.burst {
+		bucket.tokens = l.burst
+	}
+	bucket.updatedAt = now
+	return bucket
+}
+
+func (l *RateLimiter) nextTokenIn(bucket *tokenBucket) time.Duration {
+	return time.Duration((1 - bucket.tokens) / l.perSecond * float64(time.Second))
+}
+
+// cleanup removes the buckets, which have been refilled completely, once per refill time.
+func (l *RateLimiter) cleanup(now time.Time) {
+	refillTime := time.Duration(l.burst / l.perSecond * float64(time.Second))
+	if now.Sub(l.lastCleanup) < refillTime {
+		return
+	}
+	for client, bucket := range l.buckets {
+		if now.Sub(bucket.updatedAt) >= refillTime {
+			delete(l.buckets, client)
+		}
+	}
+	l.lastCleanup = now
+}
+
+// clientAddressToContext puts the IP address of the client into the context,
+// it identifies clients sending requests without authentication.
+func clientAddressToContext(ctx context.Context, r *http.Request) context.Context {
+	address, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		address = r.RemoteAddr
+	}
+	return context.WithValue(ctx, clientAddressContextKey, address)
+}
+
+// client returns the identity of the client sending the request:
+// the authenticated principal or the IP address if authentication is disabled.
+func client(ctx context.Context) string {
+	if principal, ok := PrincipalFromContext(ctx); ok {
+		return "principal:" + principal.Id
+	}
+	return clientAddress(ctx)
+}
+
+func clientAddress(ctx context.Context) string {
+	address, _ := ctx.Value(clientAddressContextKey).(string)
+	return "address:" + address
+}
+
+// makeRateLimitingMiddleware returns *RateLimitedError if the client has exceeded the limit.
+// It must be applied after authentication, so requests are limited per principal.
+// Nil limiter doesn't limit requests.
+func makeRateLimitingMiddleware(limiter *RateLimiter) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		if limiter == nil {
+			return next
+		}
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if ok, retryAfter := limiter.allow(client(ctx)); !ok {
+				return nil, &RateLimitedError{RetryAfter: retryAfter}
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// makeAuthFailuresLimitingMiddleware returns *RateLimitedError if the IP address of the client
+// has failed authentication too often. It must be applied before authentication,
+// only requests failing it take tokens, so clients sharing an address aren't limited by each other's requests.
+// Nil limiter doesn't limit requests.
+func makeAuthFailuresLimitingMiddleware(limiter *RateLimiter) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		if limiter == nil {
+			return next
+		}
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			address := clientAddress(ctx)
+			if ok, retryAfter := limiter.check(address); !ok {
+				return nil, &RateLimitedError{RetryAfter: retryAfter}
+			}
+			response, err := next(ctx, request)
+			if err == UnauthenticatedErr {
+				limiter.allow(address)
+			}
+			return response, err
+		}
+	}
+}
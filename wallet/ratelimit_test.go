@@ -0,0 +1,91 @@
+package wallet
+
+import (
+	"encoding/json"
+	"github.com/georgysavva/generic-wallet/tracing"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(2, 3)
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		ok, _ := limiter.allow("alice")
+		assert.True(t, ok)
+	}
+	ok, retryAfter := limiter.allow("alice")
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, retryAfter)
+	ok, _ = limiter.allow("bob")
+	assert.True(t, ok)
+
+	now = now.Add(500 * time.Millisecond)
+	ok, _ = limiter.allow("alice")
+	assert.True(t, ok)
+	ok, _ = limiter.allow("alice")
+	assert.False(t, ok)
+
+	now = now.Add(time.Hour)
+	ok, _ = limiter.allow("alice")
+	assert.True(t, ok)
+	assert.Equal(t, 1, len(limiter.buckets))
+}
+
+func TestMakeHandler_RateLimiting(t *testing.T) {
+	s := instantiateServiceForTests()
+	rateLimits := RateLimits{Reads: NewRateLimiter(1, 1)}
+	handler := MakeHandler(s, nil, rateLimits, tracing.NewTracer(nil), kitlog.NewNopLogger())
+	getAccount := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/wallet/v1/accounts/alice", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, getAccount("10.0.0.1:1234").Code)
+	w := getAccount("10.0.0.1:5678")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	json.NewDecoder(w.Body).Decode(&body)
+	assert.Equal(t, rateLimitedErrCode, body.Error.Code)
+	assert.Equal(t, http.StatusOK, getAccount("10.0.0.2:1234").Code)
+}
+
+func TestMakeHandler_AuthFailuresRateLimiting(t *testing.T) {
+	s := instantiateServiceForTests()
+	authenticator := NewAuthenticator(map[string]*Principal{"alice-key": {Id: "alice", Accounts: []string{"alice"}}}, nil)
+	rateLimits := RateLimits{AuthFailures: NewRateLimiter(1, 2)}
+	handler := MakeHandler(s, authenticator, rateLimits, tracing.NewTracer(nil), kitlog.NewNopLogger())
+	getAccount := func(remoteAddr, apiKey string) int {
+		r := httptest.NewRequest("GET", "/wallet/v1/accounts/alice", nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set(apiKeyHeader, apiKey)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// Successful requests don't count.
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, getAccount("10.0.0.1:1234", "alice-key"))
+	}
+	assert.Equal(t, http.StatusUnauthorized, getAccount("10.0.0.1:1234", "guess-1"))
+	assert.Equal(t, http.StatusUnauthorized, getAccount("10.0.0.1:1234", "guess-2"))
+	// The address is blocked even with valid credentials until the bucket refills.
+	assert.Equal(t, http.StatusTooManyRequests, getAccount("10.0.0.1:1234", "guess-3"))
+	assert.Equal(t, http.StatusTooManyRequests, getAccount("10.0.0.1:1234", "alice-key"))
+	assert.Equal(t, http.StatusUnauthorized, getAccount("10.0.0.2:1234", "guess-1"))
+}
@@ -0,0 +1,126 @@
+package wallet
+
+import (
+	"context"
+	"github.com/georgysavva/generic-wallet/payment"
+	"github.com/georgysavva/generic-wallet/schedule"
+	"github.com/go-kit/kit/log"
+	"time"
+)
+
+// scheduledPaymentClaimTimeout must be long enough for a payment to be sent,
+// a claimed payment is executed again after it passes.
+const scheduledPaymentClaimTimeout = 5 * time.Minute
+
+// ScheduledPaymentsWorker sends due scheduled payments through the service.
+// Several workers can run at once, e.g. in different service replicas,
+// each payment is claimed by only one of them.
+type ScheduledPaymentsWorker struct {
+	service      Service
+	scheduled    schedule.Repository
+	interval     time.Duration
+	batchSize    int
+	claimTimeout time.Duration
+	logger       log.Logger
+}
+
+func NewScheduledPaymentsWorker(
+	s Service, scheduled schedule.Repository, interval time.Duration, batchSize int, logger log.Logger,
+) *ScheduledPaymentsWorker {
+	return &ScheduledPaymentsWorker{
+		service:      s,
+		scheduled:    scheduled,
+		interval:     interval,
+		batchSize:    batchSize,
+		claimTimeout: scheduledPaymentClaimTimeout,
+		logger:       logger,
+	}
+}
+
+// Run executes due payments every interval until the context is done.
+func (w *ScheduledPaymentsWorker) Run(ctx context.Context) {
+	runPeriodically(ctx, w.interval, w.logger, "scheduled payments", w.ExecuteDue)
+}
+
+// ExecuteDue sends all due payments and returns the number of executed and failed ones.
+// A payment is sent with its own idempotency key, so it isn't sent twice if it's claimed again.
+func (w *ScheduledPaymentsWorker) ExecuteDue(ctx context.Context) (int, error) {
+	var finished int
+	for {
+		claimed, err := w.scheduled.ClaimDue(ctx, w.batchSize, w.claimTimeout)
+		if err != nil {
+			return finished, err
+		}
+		for _, scheduledPayment := range claimed {
+			transfer, err := w.service.SendPayment(
+				ctx, scheduledPayment.FromAccountId, scheduledPayment.ToAccountId, scheduledPayment.Amount,
+				scheduledPayment.IdempotencyKey(),
+			)
+			if err != nil && !isPaymentRejection(err) {
+				// The payment stays claimed and it's retried after the claim timeout.
+				w.logger.Log("msg", "Can't send scheduled payment", "scheduled_payment", scheduledPayment.Id, "err", err)
+				continue
+			}
+			if err != nil {
+				scheduledPayment.Status, scheduledPayment.FailureReason = schedule.FailedStatus, err.Error()
+			} else {
+				scheduledPayment.Status, scheduledPayment.TransferId = schedule.ExecutedStatus, transfer.Id
+			}
+			err = w.scheduled.Finish(ctx, scheduledPayment)
+			if err == schedule.AlreadyFinishedErr {
+				// The claim has timed out and another worker has finished the payment, the rest of the batch
+				// is still claimed by us.
+				w.logger.Log("msg", "Scheduled payment has already been finished", "scheduled_payment", scheduledPayment.Id)
+				continue
+			}
+			if err != nil {
+				return finished, err
+			}
+			finished++
+		}
+		if len(claimed) < w.batchSize {
+			return finished, nil
+		}
+	}
+}
+
+// runPeriodically calls execute every interval until the context is done and logs the results.
+// The execute function returns the number of processed items.
+func runPeriodically(
+	ctx context.Context, interval time.Duration, logger log.Logger, items string,
+	execute func(ctx context.Context) (int, error),
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		processed, err := execute(ctx)
+		if err != nil {
+			logger.Log("msg", "Can't process "+items, "err", err)
+		}
+		if processed > 0 {
+			logger.Log("msg", "Processed "+items, "count", processed)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// isPaymentRejection returns true if the payment can't be sent because of the accounts or the payment itself,
+// rather than because of a temporary failure.
+// A reused idempotency key isn't a rejection, since clients can't use the internal keys of the workers.
+func isPaymentRejection(err error) bool {
+	switch err.(type) {
+	case *IncorrectInputData, *DifferentCurrenciesError, *ExchangeRateNotFoundError, *payment.LimitExceededError:
+		return true
+	}
+	switch err {
+	case payment.LowBalanceErr, payment.FromAccountClosedErr, payment.ToAccountClosedErr,
+		payment.FromAccountFrozenErr, payment.FromAccountSuspendedErr, payment.ToAccountSuspendedErr,
+		FromAccountNotFound, ToAccountNotFound:
+		return true
+	}
+	return false
+}
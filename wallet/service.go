@@ -2,92 +2,1097 @@ package wallet
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"github.com/georgysavva/generic-wallet/account"
+	"github.com/georgysavva/generic-wallet/fee"
+	"github.com/georgysavva/generic-wallet/fx"
+	"github.com/georgysavva/generic-wallet/hold"
+	"github.com/georgysavva/generic-wallet/idempotency"
+	"github.com/georgysavva/generic-wallet/ledger"
+	"github.com/georgysavva/generic-wallet/money"
 	"github.com/georgysavva/generic-wallet/payment"
+	"github.com/georgysavva/generic-wallet/schedule"
+	"github.com/georgysavva/generic-wallet/standing"
 	"github.com/pkg/errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
 	defaultPaginationLimit = 50
+	defaultHoldExpiration  = 7 * 24 * time.Hour
+	maxHoldExpiration      = 30 * 24 * time.Hour
+	maxBatchSize           = 1000
 )
 
+var accountIdRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+var accountTierRegexp = accountIdRegexp
+
+// sameCurrencyRate is the exchange rate of transfers between accounts with the same currency.
+var sameCurrencyRate = money.MustParseRate("1")
+
 type Service interface {
-	SendPayment(ctx context.Context, fromAccountId, toAccountId string, amount float64) error
-	GetAllPayments(ctx context.Context, offset, limit *int) ([]*payment.Payment, int, error)
-	GetAllAccounts(ctx context.Context, offset, limit *int) ([]*account.Account, int, error)
+	// SendPayment transfers the amount between accounts and returns the created transfer.
+	// The amount is in the source account currency,
+	// it's converted to the destination account currency if the service has an exchange rates provider.
+	// If idempotencyKey isn't empty, retries with the same key don't transfer the amount again
+	// and get the originally created transfer. Keys are scoped by the caller, so different callers can use the same key.
+	SendPayment(
+		ctx context.Context, fromAccountId, toAccountId string, amount money.Amount, idempotencyKey string,
+	) (*payment.Transfer, error)
+	// SendBatchPayment transfers the amounts of all the payments or none of them and returns the created transfers.
+	// Each payment is validated like SendPayment does, *BatchError reports the payments that make the batch fail.
+	SendBatchPayment(ctx context.Context, payments []*BatchPayment) ([]*payment.Transfer, error)
+	// AuthorizePayment places a hold reserving the amount with its fee of the source account for a payment
+	// to the destination account. The hold expires after expiresIn, zero expiresIn means the default expiration.
+	AuthorizePayment(
+		ctx context.Context, fromAccountId, toAccountId string, amount money.Amount, expiresIn time.Duration,
+	) (*hold.Hold, error)
+	GetHold(ctx context.Context, holdId string) (*hold.Hold, error)
+	// CapturePayment transfers the held amount, or its part, and releases the rest of the hold.
+	// Nil amount captures the whole held amount.
+	CapturePayment(ctx context.Context, holdId string, amount *money.Amount) (*hold.Hold, *payment.Transfer, error)
+	// VoidPayment releases the hold without transferring anything.
+	VoidPayment(ctx context.Context, holdId string) (*hold.Hold, error)
+	// SchedulePayment creates a payment, which is sent at executeAt by ScheduledPaymentsWorker.
+	SchedulePayment(
+		ctx context.Context, fromAccountId, toAccountId string, amount money.Amount, executeAt time.Time,
+	) (*schedule.Payment, error)
+	GetScheduledPayment(ctx context.Context, paymentId string) (*schedule.Payment, error)
+	// GetScheduledPayments returns scheduled payments from or to the account and their total number,
+	// empty accountId means payments of all accounts.
+	GetScheduledPayments(
+		ctx context.Context, accountId string, offset, limit *int,
+	) ([]*schedule.Payment, int, error)
+	// CancelScheduledPayment cancels a payment, which hasn't been executed yet.
+	CancelScheduledPayment(ctx context.Context, paymentId string) (*schedule.Payment, error)
+	// CreateStandingOrder creates an order, which sends the payment at every occurrence of the rule.
+	// Zero StartAt of the rule means now, StandingOrdersWorker sends the payments.
+	CreateStandingOrder(
+		ctx context.Context, fromAccountId, toAccountId string, amount money.Amount, rule standing.Rule,
+	) (*standing.Order, error)
+	GetStandingOrder(ctx context.Context, orderId string) (*standing.Order, error)
+	// GetStandingOrders returns standing orders from or to the account and their total number,
+	// empty accountId means orders of all accounts.
+	GetStandingOrders(ctx context.Context, accountId string, offset, limit *int) ([]*standing.Order, int, error)
+	// CancelStandingOrder stops an active order, the payments sent so far stay in place.
+	CancelStandingOrder(ctx context.Context, orderId string) (*standing.Order, error)
+	GetPayment(ctx context.Context, paymentId int64) (*payment.Payment, error)
+	// RefundPayment moves the amount of the payment transfer, or its part, back and returns the refund.
+	// The amount is in the source currency of the transfer, nil amount refunds everything not refunded yet.
+	RefundPayment(ctx context.Context, paymentId int64, amount *money.Amount) (*payment.Transfer, error)
+	// GetAllPayments returns payments matching the filter in the sort order.
+	GetAllPayments(
+		ctx context.Context, filter payment.Filter, sort payment.Sort, pagination Pagination,
+	) ([]*payment.Payment, *Page, error)
+	GetAllAccounts(ctx context.Context, pagination Pagination) ([]*account.Account, *Page, error)
+	// GetAccountPayments returns payments of a single account and their total number.
+	GetAccountPayments(ctx context.Context, accountId string, offset, limit *int) ([]*payment.Payment, int, error)
+	CreateAccount(ctx context.Context, accountId, currency string) (*account.Account, error)
+	GetAccount(ctx context.Context, accountId string) (*account.Account, error)
+	// CloseAccount closes the account, so it can't send or receive payments anymore.
+	// Only accounts with zero balance can be closed.
+	CloseAccount(ctx context.Context, accountId string) (*account.Account, error)
+	// SetAccountStatus freezes, suspends or reactivates the account, the reason is stored with the status.
+	// Closed accounts can't change their status.
+	SetAccountStatus(ctx context.Context, accountId, status, reason string) (*account.Account, error)
+	// SetOverdraftLimit allows the account to spend money down to the negated limit.
+	SetOverdraftLimit(ctx context.Context, accountId string, limit money.Amount) (*account.Account, error)
+	// SetSpendingLimits replaces the limits of the account, the unset ones fall back to the currency defaults.
+	SetSpendingLimits(ctx context.Context, accountId string, limits account.SpendingLimits) (*account.Account, error)
+	// SetAccountTier changes the tier selecting the fees of the account.
+	SetAccountTier(ctx context.Context, accountId, tier string) (*account.Account, error)
+	// GetLedgerDiscrepancies returns accounts whose balance doesn't match their ledger postings,
+	// it's empty unless the balances have been corrupted.
+	GetLedgerDiscrepancies(ctx context.Context) ([]*ledger.Discrepancy, error)
 }
 
 type service struct {
-	payments payment.Repository
-	accounts account.Repository
+	payments    payment.Repository
+	accounts    account.Repository
+	idempotency idempotency.Repository
+	ledger      ledger.Repository
+	holds       hold.Repository
+	scheduled   schedule.Repository
+	standing    standing.Repository
+	rates       fx.Provider
+	// spendingLimits are the default limits of accounts by currency.
+	spendingLimits map[string]*account.SpendingLimits
+	fees           fee.Schedule
+}
+
+// NewService creates the wallet service.
+// If rates is nil, payments between accounts with different currencies are rejected.
+// spendingLimits are the default limits by currency for the accounts that don't set their own.
+// fees are charged on payments, refunds are free.
+func NewService(
+	payments payment.Repository, accounts account.Repository, idempotencyRecords idempotency.Repository,
+	ledgerRecords ledger.Repository, holds hold.Repository, scheduled schedule.Repository,
+	standingOrders standing.Repository, rates fx.Provider, spendingLimits map[string]*account.SpendingLimits,
+	fees fee.Schedule,
+) Service {
+	return &service{
+		payments:       payments,
+		accounts:       accounts,
+		idempotency:    idempotencyRecords,
+		ledger:         ledgerRecords,
+		holds:          holds,
+		scheduled:      scheduled,
+		standing:       standingOrders,
+		rates:          rates,
+		spendingLimits: spendingLimits,
+		fees:           fees,
+	}
+}
+
+func (s *service) SendPayment(
+	ctx context.Context, fromAccountId, toAccountId string, amount money.Amount, idempotencyKey string,
+) (*payment.Transfer, error) {
+	var fingerprint string
+	scope := idempotencyScope(ctx)
+	if idempotencyKey != "" {
+		// A retried request must get the original response even if the accounts have changed since then,
+		// so the key is checked before anything else.
+		fingerprint = idempotency.Fingerprint("send_payment", fromAccountId, toAccountId, amount.String())
+		transfer, err := s.replayIdempotentRequest(ctx, scope, idempotencyKey, fingerprint)
+		if err != nil || transfer != nil {
+			return transfer, err
+		}
+	}
+	fromAccount, toAccount, err := s.getPaymentAccounts(ctx, fromAccountId, toAccountId, amount)
+	if err != nil {
+		return nil, err
+	}
+	transfer, err := s.newTransfer(ctx, fromAccount, toAccount, amount)
+	if err != nil {
+		return nil, err
+	}
+	var idempotencyRecord *idempotency.Record
+	if idempotencyKey != "" {
+		idempotencyRecord = &idempotency.Record{Scope: scope, Key: idempotencyKey, Fingerprint: fingerprint}
+	}
+	err = s.payments.Save(ctx, transfer, idempotencyRecord)
+	if err == idempotency.KeyAlreadyUsedErr {
+		// A concurrent request with the same key has been completed first.
+		return s.replayIdempotentRequest(ctx, scope, idempotencyKey, fingerprint)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return transfer, nil
+}
+
+func (s *service) SendBatchPayment(ctx context.Context, payments []*BatchPayment) ([]*payment.Transfer, error) {
+	if len(payments) == 0 || len(payments) > maxBatchSize {
+		return nil, &IncorrectInputData{fmt.Sprintf("batch must have from 1 to %d payments", maxBatchSize)}
+	}
+	transfers := make([]*payment.Transfer, len(payments))
+	batchErr := &BatchError{}
+	for i, p := range payments {
+		fromAccount, toAccount, err := s.getPaymentAccounts(ctx, p.FromAccountId, p.ToAccountId, p.Amount)
+		if err == nil {
+			transfers[i], err = s.newTransfer(ctx, fromAccount, toAccount, p.Amount)
+		}
+		if err != nil {
+			if !isPaymentRejection(err) {
+				return nil, err
+			}
+			batchErr.Items = append(batchErr.Items, &payment.BatchItemError{Index: i, Err: err})
+		}
+	}
+	if len(batchErr.Items) > 0 {
+		return nil, batchErr
+	}
+	err := s.payments.SaveBatch(ctx, transfers)
+	if err != nil {
+		if itemErr, ok := err.(*payment.BatchItemError); ok {
+			return nil, &BatchError{Items: []*payment.BatchItemError{itemErr}}
+		}
+		return nil, err
+	}
+	return transfers, nil
+}
+
+func (s *service) AuthorizePayment(
+	ctx context.Context, fromAccountId, toAccountId string, amount money.Amount, expiresIn time.Duration,
+) (*hold.Hold, error) {
+	if expiresIn < 0 || expiresIn > maxHoldExpiration {
+		return nil, &IncorrectInputData{fmt.Sprintf("hold expiration can't exceed %s", maxHoldExpiration)}
+	}
+	if expiresIn == 0 {
+		expiresIn = defaultHoldExpiration
+	}
+	fromAccount, _, err := s.getPaymentAccounts(ctx, fromAccountId, toAccountId, amount)
+	if err != nil {
+		return nil, err
+	}
+	// The fee is fixed now, so the money reserved by the hold covers the capture.
+	holdFee, err := s.fees.Fee(fromAccount.Currency, fromAccount.Tier, amount)
+	if err != nil {
+		return nil, &IncorrectInputData{err.Error()}
+	}
+	spendingLimits := fromAccount.SpendingLimits.WithDefaults(s.spendingLimits[fromAccount.Currency])
+	holdId, err := generateId()
+	if err != nil {
+		return nil, err
+	}
+	holdRecord := &hold.Hold{
+		Id:             holdId,
+		FromAccountId:  fromAccountId,
+		ToAccountId:    toAccountId,
+		Amount:         amount,
+		Fee:            holdFee,
+		Status:         hold.ActiveStatus,
+		SpendingLimits: &spendingLimits,
+		ExpiresAt:      time.Now().Add(expiresIn),
+	}
+	err = s.holds.Create(ctx, holdRecord)
+	if err != nil {
+		return nil, err
+	}
+	return holdRecord, nil
+}
+
+func (s *service) GetHold(ctx context.Context, holdId string) (*hold.Hold, error) {
+	holdRecord, err := s.holds.Get(ctx, holdId)
+	if err != nil {
+		return nil, err
+	}
+	if holdRecord == nil {
+		return nil, HoldNotFound
+	}
+	return holdRecord, nil
+}
+
+func (s *service) CapturePayment(
+	ctx context.Context, holdId string, amount *money.Amount,
+) (*hold.Hold, *payment.Transfer, error) {
+	holdRecord, err := s.GetHold(ctx, holdId)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The status is checked again by the repository, checking it here saves fetching an exchange rate.
+	switch holdRecord.Status {
+	case hold.ActiveStatus:
+	case hold.ExpiredStatus:
+		return nil, nil, hold.ExpiredErr
+	default:
+		return nil, nil, hold.NotActiveErr
+	}
+	if amount == nil {
+		amount = &holdRecord.Amount
+	}
+	if amount.Cmp(holdRecord.Amount) > 0 {
+		return nil, nil, hold.CaptureExceedsAmountErr
+	}
+	fromAccount, toAccount, err := s.getPaymentAccounts(ctx, holdRecord.FromAccountId, holdRecord.ToAccountId, *amount)
+	if err != nil {
+		return nil, nil, err
+	}
+	transfer, err := s.newTransfer(ctx, fromAccount, toAccount, *amount)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The fee of a partial capture is charged on the captured amount, but it never exceeds the reserved fee,
+	// even if the fees have changed since the authorization.
+	if transfer.Fee.Cmp(holdRecord.Fee) > 0 {
+		transfer.Fee = holdRecord.Fee
+	}
+	holdRecord, err = s.holds.Capture(ctx, holdId, transfer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if holdRecord == nil {
+		return nil, nil, HoldNotFound
+	}
+	return holdRecord, transfer, nil
+}
+
+func (s *service) VoidPayment(ctx context.Context, holdId string) (*hold.Hold, error) {
+	holdRecord, err := s.holds.Void(ctx, holdId)
+	if err != nil {
+		return nil, err
+	}
+	if holdRecord == nil {
+		return nil, HoldNotFound
+	}
+	return holdRecord, nil
+}
+
+func (s *service) SchedulePayment(
+	ctx context.Context, fromAccountId, toAccountId string, amount money.Amount, executeAt time.Time,
+) (*schedule.Payment, error) {
+	if !executeAt.After(time.Now()) {
+		return nil, &IncorrectInputData{"execution time of a scheduled payment must be in the future"}
+	}
+	// The accounts are checked again when the payment is executed.
+	_, _, err := s.getPaymentAccounts(ctx, fromAccountId, toAccountId, amount)
+	if err != nil {
+		return nil, err
+	}
+	paymentId, err := generateId()
+	if err != nil {
+		return nil, err
+	}
+	scheduledPayment := &schedule.Payment{
+		Id:            paymentId,
+		FromAccountId: fromAccountId,
+		ToAccountId:   toAccountId,
+		Amount:        amount,
+		ExecuteAt:     executeAt,
+		Status:        schedule.PendingStatus,
+	}
+	err = s.scheduled.Create(ctx, scheduledPayment)
+	if err != nil {
+		return nil, err
+	}
+	return scheduledPayment, nil
 }
 
-func NewService(payments payment.Repository, accounts account.Repository) Service {
-	return &service{payments: payments, accounts: accounts}
+func (s *service) GetScheduledPayment(ctx context.Context, paymentId string) (*schedule.Payment, error) {
+	scheduledPayment, err := s.scheduled.Get(ctx, paymentId)
+	if err != nil {
+		return nil, err
+	}
+	if scheduledPayment == nil {
+		return nil, ScheduledPaymentNotFound
+	}
+	return scheduledPayment, nil
 }
 
-func (s *service) SendPayment(ctx context.Context, fromAccountId, toAccountId string, amount float64) error {
-	// Assumption: Account can't be deleted.
+func (s *service) GetScheduledPayments(
+	ctx context.Context, accountId string, offset, limit *int,
+) ([]*schedule.Payment, int, error) {
+	offset, limit, err := preparePagination(offset, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	scheduledPayments, err := s.scheduled.GetAll(ctx, accountId, offset, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	total, err := s.scheduled.CountAll(ctx, accountId)
+	if err != nil {
+		return nil, 0, err
+	}
+	return scheduledPayments, total, nil
+}
+
+func (s *service) CancelScheduledPayment(ctx context.Context, paymentId string) (*schedule.Payment, error) {
+	scheduledPayment, err := s.scheduled.Cancel(ctx, paymentId)
+	if err != nil {
+		return nil, err
+	}
+	if scheduledPayment == nil {
+		return nil, ScheduledPaymentNotFound
+	}
+	return scheduledPayment, nil
+}
+
+func (s *service) CreateStandingOrder(
+	ctx context.Context, fromAccountId, toAccountId string, amount money.Amount, rule standing.Rule,
+) (*standing.Order, error) {
+	if !standing.IsValidFrequency(rule.Frequency) {
+		return nil, &IncorrectInputData{fmt.Sprintf(
+			"frequency must be one of %s, %s or %s",
+			standing.DailyFrequency, standing.WeeklyFrequency, standing.MonthlyFrequency,
+		)}
+	}
+	now := time.Now()
+	if rule.StartAt.IsZero() {
+		rule.StartAt = now
+	} else if rule.StartAt.Before(now) {
+		return nil, &IncorrectInputData{"start time of a standing order can't be in the past"}
+	}
+	if rule.EndAt != nil && rule.EndAt.Before(rule.StartAt) {
+		return nil, &IncorrectInputData{"end time of a standing order can't be before its start time"}
+	}
+	if rule.Count != nil && *rule.Count <= 0 {
+		return nil, &IncorrectInputData{"number of occurrences of a standing order must be greater than 0"}
+	}
+	// The accounts are checked again at every occurrence.
+	_, _, err := s.getPaymentAccounts(ctx, fromAccountId, toAccountId, amount)
+	if err != nil {
+		return nil, err
+	}
+	orderId, err := generateId()
+	if err != nil {
+		return nil, err
+	}
+	order := &standing.Order{
+		Id:            orderId,
+		FromAccountId: fromAccountId,
+		ToAccountId:   toAccountId,
+		Amount:        amount,
+		Rule:          rule,
+		NextRunAt:     rule.StartAt,
+		Status:        standing.ActiveStatus,
+	}
+	err = s.standing.Create(ctx, order)
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+func (s *service) GetStandingOrder(ctx context.Context, orderId string) (*standing.Order, error) {
+	order, err := s.standing.Get(ctx, orderId)
+	if err != nil {
+		return nil, err
+	}
+	if order == nil {
+		return nil, StandingOrderNotFound
+	}
+	return order, nil
+}
+
+func (s *service) GetStandingOrders(
+	ctx context.Context, accountId string, offset, limit *int,
+) ([]*standing.Order, int, error) {
+	offset, limit, err := preparePagination(offset, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	orders, err := s.standing.GetAll(ctx, accountId, offset, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	total, err := s.standing.CountAll(ctx, accountId)
+	if err != nil {
+		return nil, 0, err
+	}
+	return orders, total, nil
+}
+
+func (s *service) CancelStandingOrder(ctx context.Context, orderId string) (*standing.Order, error) {
+	order, err := s.standing.Cancel(ctx, orderId)
+	if err != nil {
+		return nil, err
+	}
+	if order == nil {
+		return nil, StandingOrderNotFound
+	}
+	return order, nil
+}
+
+// getPaymentAccounts validates a payment of the amount between the accounts and returns the accounts.
+func (s *service) getPaymentAccounts(
+	ctx context.Context, fromAccountId, toAccountId string, amount money.Amount,
+) (*account.Account, *account.Account, error) {
+	// Assumption: Account can't be deleted, it can only be closed.
 	// Assumption: Account currency can't be changed.
 	if fromAccountId == toAccountId {
-		return &IncorrectInputData{"source account and destination account are the same"}
+		return nil, nil, &IncorrectInputData{"source account and destination account are the same"}
 	}
-	if amount <= 0 {
-		return &IncorrectInputData{"payment amount must be greater than 0"}
+	if amount.Sign() <= 0 {
+		return nil, nil, &IncorrectInputData{"payment amount must be greater than 0"}
 	}
 	fromAccount, err := s.accounts.Get(ctx, fromAccountId)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	if fromAccount == nil {
-		return FromAccountNotFound
+		return nil, nil, FromAccountNotFound
 	}
 	toAccount, err := s.accounts.Get(ctx, toAccountId)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	if toAccount == nil {
-		return ToAccountNotFound
+		return nil, nil, ToAccountNotFound
 	}
-	if fromAccount.Currency != toAccount.Currency {
-		return &DifferentCurrenciesError{fromAccount.Currency, toAccount.Currency}
+	err = payment.CheckFromAccountStatus(fromAccount.Status)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = payment.CheckToAccountStatus(toAccount.Status)
+	if err != nil {
+		return nil, nil, err
+	}
+	if fromAccount.Currency != toAccount.Currency && s.rates == nil {
+		return nil, nil, &DifferentCurrenciesError{fromAccount.Currency, toAccount.Currency}
+	}
+	err = checkDecimals(amount, fromAccount.Currency, "payment amount")
+	if err != nil {
+		return nil, nil, err
+	}
+	return fromAccount, toAccount, nil
+}
+
+// newTransfer returns a transfer of the amount between the accounts converted at the current exchange rate
+// with the fee of the source account.
+func (s *service) newTransfer(
+	ctx context.Context, fromAccount, toAccount *account.Account, amount money.Amount,
+) (*payment.Transfer, error) {
+	exchangeRate, destinationAmount, err := s.exchange(ctx, amount, fromAccount.Currency, toAccount.Currency)
+	if err != nil {
+		return nil, err
+	}
+	transferFee, err := s.fees.Fee(fromAccount.Currency, fromAccount.Tier, amount)
+	if err != nil {
+		return nil, &IncorrectInputData{err.Error()}
+	}
+	transferId, err := generateId()
+	if err != nil {
+		return nil, err
 	}
-	err = s.payments.Save(ctx, fromAccountId, toAccountId, amount)
-	return err
+	spendingLimits := fromAccount.SpendingLimits.WithDefaults(s.spendingLimits[fromAccount.Currency])
+	return &payment.Transfer{
+		Id:                  transferId,
+		FromAccountId:       fromAccount.Id,
+		ToAccountId:         toAccount.Id,
+		Amount:              amount,
+		Currency:            fromAccount.Currency,
+		DestinationAmount:   destinationAmount,
+		DestinationCurrency: toAccount.Currency,
+		ExchangeRate:        exchangeRate,
+		Fee:                 transferFee,
+		SpendingLimits:      &spendingLimits,
+	}, nil
 }
 
-func (s *service) GetAllPayments(ctx context.Context, offset, limit *int) ([]*payment.Payment, int, error) {
+// exchange converts the amount to the destination currency at the current exchange rate
+// and returns the rate and the converted amount.
+func (s *service) exchange(
+	ctx context.Context, amount money.Amount, fromCurrency, toCurrency string,
+) (money.Rate, money.Amount, error) {
+	if fromCurrency == toCurrency {
+		return sameCurrencyRate, amount, nil
+	}
+	rate, err := s.rates.Rate(ctx, fromCurrency, toCurrency)
+	if err != nil {
+		if err == fx.RateNotFoundErr {
+			return money.Rate{}, money.Amount{}, &ExchangeRateNotFoundError{fromCurrency, toCurrency}
+		}
+		if providerErr, ok := err.(*fx.ProviderError); ok {
+			return money.Rate{}, money.Amount{}, &ExchangeRateUnavailableError{fromCurrency, toCurrency, providerErr.Err}
+		}
+		return money.Rate{}, money.Amount{}, err
+	}
+	precision, err := currencyPrecision(toCurrency)
+	if err != nil {
+		return money.Rate{}, money.Amount{}, err
+	}
+	converted, err := amount.Exchange(rate, precision)
+	if err != nil {
+		return money.Rate{}, money.Amount{}, &IncorrectInputData{err.Error()}
+	}
+	if converted.Sign() <= 0 {
+		return money.Rate{}, money.Amount{}, &IncorrectInputData{
+			fmt.Sprintf("payment amount is too small to be converted to %s", toCurrency),
+		}
+	}
+	return rate, converted, nil
+}
+
+// currencyPrecision returns IncorrectInputData if the currency isn't supported,
+// amounts in such a currency can't be validated or rounded.
+func currencyPrecision(currency string) (int, error) {
+	precision, ok := money.Precision(currency)
+	if !ok {
+		return 0, &IncorrectInputData{fmt.Sprintf("currency %s isn't supported", currency)}
+	}
+	return precision, nil
+}
+
+// checkDecimals returns IncorrectInputData if the amount has more decimal places than its currency allows.
+func checkDecimals(amount money.Amount, currency, name string) error {
+	precision, err := currencyPrecision(currency)
+	if err != nil {
+		return err
+	}
+	if amount.Decimals() > precision {
+		return &IncorrectInputData{fmt.Sprintf("%s %s can't have more than %d decimal places", currency, name, precision)}
+	}
+	return nil
+}
+
+// idempotencyScope returns the scope of the idempotency keys of the caller, which is the authenticated principal.
+// Requests without a principal, e.g. when authentication is disabled, share the empty scope.
+func idempotencyScope(ctx context.Context) string {
+	if principal, ok := PrincipalFromContext(ctx); ok {
+		return "principal:" + principal.Id
+	}
+	return ""
+}
+
+// replayIdempotentRequest returns the transfer created by a previous request with the same scope and key
+// or nil if there was no such request.
+// It returns IdempotencyKeyReusedErr if the key was used for a request with different parameters.
+func (s *service) replayIdempotentRequest(
+	ctx context.Context, scope, key, fingerprint string,
+) (*payment.Transfer, error) {
+	record, err := s.idempotency.Get(ctx, scope, key)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, nil
+	}
+	if record.Fingerprint != fingerprint {
+		return nil, IdempotencyKeyReusedErr
+	}
+	transfer := &payment.Transfer{}
+	err = json.Unmarshal(record.Response, transfer)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't decode idempotent response")
+	}
+	transfer.Replayed = true
+	return transfer, nil
+}
+
+func (s *service) GetPayment(ctx context.Context, paymentId int64) (*payment.Payment, error) {
+	paymentRecord, err := s.payments.Get(ctx, paymentId)
+	if err != nil {
+		return nil, err
+	}
+	if paymentRecord == nil {
+		return nil, PaymentNotFound
+	}
+	return paymentRecord, nil
+}
+
+func (s *service) RefundPayment(
+	ctx context.Context, paymentId int64, amount *money.Amount,
+) (*payment.Transfer, error) {
+	paymentRecord, err := s.payments.Get(ctx, paymentId)
+	if err != nil {
+		return nil, err
+	}
+	if paymentRecord == nil {
+		return nil, PaymentNotFound
+	}
+	transfer, err := s.payments.GetTransfer(ctx, paymentRecord.TransferId)
+	if err != nil {
+		return nil, err
+	}
+	if transfer == nil {
+		return nil, PaymentNotFound
+	}
+	if transfer.RefundedTransferId != "" {
+		return nil, &IncorrectInputData{"refunds can't be refunded"}
+	}
+	remainingAmount := transfer.Amount.Sub(transfer.RefundedAmount)
+	if amount == nil {
+		amount = &remainingAmount
+		if remainingAmount.IsZero() {
+			return nil, payment.RefundExceedsAmountErr
+		}
+	}
+	if amount.Sign() <= 0 {
+		return nil, &IncorrectInputData{"refund amount must be greater than 0"}
+	}
+	err = checkDecimals(*amount, transfer.Currency, "refund amount")
+	if err != nil {
+		return nil, err
+	}
+	if amount.Cmp(remainingAmount) > 0 {
+		return nil, payment.RefundExceedsAmountErr
+	}
+	destinationAmount, err := refundDestinationAmount(transfer, *amount)
+	if err != nil {
+		return nil, err
+	}
+	refundId, err := generateId()
+	if err != nil {
+		return nil, err
+	}
+	// The refund goes backwards: from the destination account of the transfer to its source account.
+	refund := &payment.Transfer{
+		Id:                  refundId,
+		FromAccountId:       transfer.ToAccountId,
+		ToAccountId:         transfer.FromAccountId,
+		Amount:              destinationAmount,
+		Currency:            transfer.DestinationCurrency,
+		DestinationAmount:   *amount,
+		DestinationCurrency: transfer.Currency,
+		ExchangeRate:        transfer.ExchangeRate,
+		RefundedTransferId:  transfer.Id,
+	}
+	err = s.payments.SaveRefund(ctx, refund)
+	if err != nil {
+		return nil, err
+	}
+	return refund, nil
+}
+
+// refundDestinationAmount returns the part of the transfer destination amount corresponding to the refunded amount.
+// The last refund takes all the destination amount left, so a fully refunded transfer is reverted exactly
+// regardless of rounding.
+func refundDestinationAmount(transfer *payment.Transfer, amount money.Amount) (money.Amount, error) {
+	remainingDestinationAmount := transfer.DestinationAmount.Sub(transfer.RefundedDestinationAmount)
+	if amount.Cmp(transfer.Amount.Sub(transfer.RefundedAmount)) == 0 {
+		return remainingDestinationAmount, nil
+	}
+	if transfer.Currency == transfer.DestinationCurrency {
+		return amount, nil
+	}
+	precision, err := currencyPrecision(transfer.DestinationCurrency)
+	if err != nil {
+		return money.Amount{}, err
+	}
+	destinationAmount, err := amount.Exchange(transfer.ExchangeRate, precision)
+	if err != nil {
+		return money.Amount{}, &IncorrectInputData{err.Error()}
+	}
+	if destinationAmount.Cmp(remainingDestinationAmount) > 0 {
+		destinationAmount = remainingDestinationAmount
+	}
+	if destinationAmount.Sign() <= 0 {
+		return money.Amount{}, &IncorrectInputData{
+			fmt.Sprintf("refund amount is too small to be converted to %s", transfer.DestinationCurrency),
+		}
+	}
+	return destinationAmount, nil
+}
+
+func (s *service) GetAllPayments(
+	ctx context.Context, filter payment.Filter, sort payment.Sort, pagination Pagination,
+) ([]*payment.Payment, *Page, error) {
+	err := validatePaymentsFilter(filter, sort)
+	if err != nil {
+		return nil, nil, err
+	}
+	pagination, err = prepareListPagination(pagination)
+	if err != nil {
+		return nil, nil, err
+	}
+	list := paymentsListName(filter, sort)
+	if pagination.Cursor == "" {
+		paymentRecords, err := s.payments.GetAll(ctx, filter, sort, pagination.Offset, pagination.Limit)
+		if err != nil {
+			return nil, nil, err
+		}
+		paymentsTotal, err := s.payments.CountAll(ctx, filter)
+		if err != nil {
+			return nil, nil, err
+		}
+		return paymentRecords, offsetPage(list, paymentKeys(paymentRecords, sort), pagination.Offset, paymentsTotal), nil
+	}
+
+	c, err := decodeCursor(pagination.Cursor, list)
+	if err != nil {
+		return nil, nil, err
+	}
+	cursorKey := c.After
+	if cursorKey == "" {
+		cursorKey = c.Before
+	}
+	position, err := parsePaymentKey(cursorKey, sort)
+	if err != nil {
+		return nil, nil, err
+	}
+	var paymentRecords []*payment.Payment
+	if c.After != "" {
+		paymentRecords, err = s.payments.GetAllAfter(ctx, filter, sort, position, *pagination.Limit+1)
+	} else {
+		paymentRecords, err = s.payments.GetAllBefore(ctx, filter, sort, position, *pagination.Limit+1)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	start, end, page := cursorPage(list, c, paymentKeys(paymentRecords, sort), *pagination.Limit)
+	return paymentRecords[start:end], page, nil
+}
+
+func validatePaymentsFilter(filter payment.Filter, sort payment.Sort) error {
+	if filter.Direction != "" && filter.Direction != payment.OutgoingDirection &&
+		filter.Direction != payment.IncomingDirection {
+		return &IncorrectInputData{fmt.Sprintf(
+			"payment direction must be either %s or %s", payment.OutgoingDirection, payment.IncomingDirection,
+		)}
+	}
+	if filter.MinAmount != nil && filter.MaxAmount != nil && filter.MinAmount.Cmp(*filter.MaxAmount) > 0 {
+		return &IncorrectInputData{"minimal amount must not be greater than maximal amount"}
+	}
+	if filter.CreatedFrom != nil && filter.CreatedTo != nil && !filter.CreatedFrom.Before(*filter.CreatedTo) {
+		return &IncorrectInputData{"start of the creation time range must be before its end"}
+	}
+	if sort.By != payment.SortById && sort.By != payment.SortByTime && sort.By != payment.SortByAmount {
+		return &IncorrectInputData{fmt.Sprintf(
+			"payments can be sorted either by %s or by %s", payment.SortByTime, payment.SortByAmount,
+		)}
+	}
+	return nil
+}
+
+// paymentsListName returns the name of the payments list selected by the filter in the sort order.
+// Cursors contain sort values and point into the filtered list, so they are valid only for the same filter and sort.
+// The filter is hashed to keep cursors short.
+func paymentsListName(filter payment.Filter, sort payment.Sort) string {
+	encodedFilter, _ := json.Marshal(filter)
+	filterHash := sha256.Sum256(encodedFilter)
+	return fmt.Sprintf("%s:%s:%t:%x", paymentsList, sort.By, sort.Descending, filterHash[:8])
+}
+
+// paymentKeys returns cursor keys of the payments, a key consists of the id and the sort value.
+func paymentKeys(payments []*payment.Payment, sort payment.Sort) []string {
+	keys := make([]string, len(payments))
+	for i, p := range payments {
+		keys[i] = strconv.FormatInt(p.Id, 10)
+		switch sort.By {
+		case payment.SortByTime:
+			keys[i] += " " + p.CreatedAt.Format(time.RFC3339Nano)
+		case payment.SortByAmount:
+			keys[i] += " " + p.Amount.String()
+		}
+	}
+	return keys
+}
+
+// parsePaymentKey returns the payment position encoded into the cursor key.
+func parsePaymentKey(key string, sort payment.Sort) (*payment.Payment, error) {
+	invalidCursorErr := &IncorrectInputData{"'cursor' pagination parameter is invalid"}
+	parts := strings.SplitN(key, " ", 2)
+	if (len(parts) == 2) != (sort.By != payment.SortById) {
+		return nil, invalidCursorErr
+	}
+	position := &payment.Payment{}
+	var err error
+	position.Id, err = strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, invalidCursorErr
+	}
+	switch sort.By {
+	case payment.SortByTime:
+		position.CreatedAt, err = time.Parse(time.RFC3339Nano, parts[1])
+	case payment.SortByAmount:
+		position.Amount, err = money.Parse(parts[1])
+	}
+	if err != nil {
+		return nil, invalidCursorErr
+	}
+	return position, nil
+}
+
+func (s *service) GetAllAccounts(ctx context.Context, pagination Pagination) ([]*account.Account, *Page, error) {
+	pagination, err := prepareListPagination(pagination)
+	if err != nil {
+		return nil, nil, err
+	}
+	if pagination.Cursor == "" {
+		accountRecords, err := s.accounts.GetAll(ctx, pagination.Offset, pagination.Limit)
+		if err != nil {
+			return nil, nil, err
+		}
+		accountsTotal, err := s.accounts.CountAll(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		return accountRecords, offsetPage(accountsList, accountKeys(accountRecords), pagination.Offset, accountsTotal), nil
+	}
+
+	c, err := decodeCursor(pagination.Cursor, accountsList)
+	if err != nil {
+		return nil, nil, err
+	}
+	var accountRecords []*account.Account
+	if c.After != "" {
+		accountRecords, err = s.accounts.GetAllAfter(ctx, c.After, *pagination.Limit+1)
+	} else {
+		accountRecords, err = s.accounts.GetAllBefore(ctx, c.Before, *pagination.Limit+1)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	start, end, page := cursorPage(accountsList, c, accountKeys(accountRecords), *pagination.Limit)
+	return accountRecords[start:end], page, nil
+}
+
+func accountKeys(accounts []*account.Account) []string {
+	keys := make([]string, len(accounts))
+	for i, a := range accounts {
+		keys[i] = a.Id
+	}
+	return keys
+}
+
+func (s *service) GetAccountPayments(
+	ctx context.Context, accountId string, offset, limit *int,
+) ([]*payment.Payment, int, error) {
 	offset, limit, err := preparePagination(offset, limit)
 	if err != nil {
 		return nil, 0, err
 	}
-	paymentRecords, err := s.payments.GetAll(ctx, offset, limit)
+	accountRecord, err := s.accounts.Get(ctx, accountId)
 	if err != nil {
 		return nil, 0, err
 	}
-	paymentsTotal, err := s.payments.CountAll(ctx)
+	if accountRecord == nil {
+		return nil, 0, AccountNotFound
+	}
+	filter := payment.Filter{AccountId: accountId}
+	paymentRecords, err := s.payments.GetAll(ctx, filter, payment.Sort{}, offset, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	paymentsTotal, err := s.payments.CountAll(ctx, filter)
 	if err != nil {
 		return nil, 0, err
 	}
 	return paymentRecords, paymentsTotal, nil
 }
 
-func (s *service) GetAllAccounts(ctx context.Context, offset, limit *int) ([]*account.Account, int, error) {
-	offset, limit, err := preparePagination(offset, limit)
+func (s *service) CreateAccount(ctx context.Context, accountId, currency string) (*account.Account, error) {
+	if !accountIdRegexp.MatchString(accountId) {
+		return nil, &IncorrectInputData{
+			"account id must be 1-64 characters long and contain only latin letters, digits, '_' and '-'",
+		}
+	}
+	if _, ok := money.Precision(currency); !ok {
+		return nil, &IncorrectInputData{fmt.Sprintf("currency %s isn't supported", currency)}
+	}
+	accountRecord := &account.Account{
+		Id: accountId, Currency: currency, Status: account.ActiveStatus, Tier: account.StandardTier,
+	}
+	err := s.accounts.Create(ctx, accountRecord)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
+	}
+	return accountRecord, nil
+}
+
+func (s *service) GetAccount(ctx context.Context, accountId string) (*account.Account, error) {
+	accountRecord, err := s.accounts.Get(ctx, accountId)
+	if err != nil {
+		return nil, err
 	}
-	accountRecords, err := s.accounts.GetAll(ctx, offset, limit)
+	if accountRecord == nil {
+		return nil, AccountNotFound
+	}
+	return accountRecord, nil
+}
+
+func (s *service) CloseAccount(ctx context.Context, accountId string) (*account.Account, error) {
+	accountRecord, err := s.accounts.Close(ctx, accountId)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
+	}
+	if accountRecord == nil {
+		return nil, AccountNotFound
+	}
+	return accountRecord, nil
+}
+
+func (s *service) SetAccountStatus(
+	ctx context.Context, accountId, status, reason string,
+) (*account.Account, error) {
+	switch status {
+	case account.ActiveStatus, account.FrozenStatus, account.SuspendedStatus:
+	default:
+		return nil, &IncorrectInputData{fmt.Sprintf(
+			"account status must be one of %s, %s or %s, use account closing to close it",
+			account.ActiveStatus, account.FrozenStatus, account.SuspendedStatus,
+		)}
 	}
-	accountsTotal, err := s.accounts.CountAll(ctx)
+	if strings.TrimSpace(reason) == "" {
+		return nil, &IncorrectInputData{"reason of the account status change is required"}
+	}
+	accountRecord, err := s.accounts.SetStatus(ctx, accountId, status, reason)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
+	}
+	if accountRecord == nil {
+		return nil, AccountNotFound
 	}
-	return accountRecords, accountsTotal, nil
+	return accountRecord, nil
 }
+
+func (s *service) SetOverdraftLimit(
+	ctx context.Context, accountId string, limit money.Amount,
+) (*account.Account, error) {
+	if limit.Sign() < 0 {
+		return nil, &IncorrectInputData{"overdraft limit can't be negative"}
+	}
+	accountRecord, err := s.accounts.Get(ctx, accountId)
+	if err != nil {
+		return nil, err
+	}
+	if accountRecord == nil {
+		return nil, AccountNotFound
+	}
+	err = checkDecimals(limit, accountRecord.Currency, "overdraft limit")
+	if err != nil {
+		return nil, err
+	}
+	accountRecord, err = s.accounts.SetOverdraftLimit(ctx, accountId, limit)
+	if err != nil {
+		return nil, err
+	}
+	if accountRecord == nil {
+		return nil, AccountNotFound
+	}
+	return accountRecord, nil
+}
+
+func (s *service) SetSpendingLimits(
+	ctx context.Context, accountId string, limits account.SpendingLimits,
+) (*account.Account, error) {
+	accountRecord, err := s.accounts.Get(ctx, accountId)
+	if err != nil {
+		return nil, err
+	}
+	if accountRecord == nil {
+		return nil, AccountNotFound
+	}
+	for _, limit := range []*money.Amount{limits.PerTransaction, limits.Daily, limits.Monthly} {
+		if limit == nil {
+			continue
+		}
+		if limit.Sign() < 0 {
+			return nil, &IncorrectInputData{"spending limits can't be negative"}
+		}
+		err = checkDecimals(*limit, accountRecord.Currency, "spending limits")
+		if err != nil {
+			return nil, err
+		}
+	}
+	accountRecord, err = s.accounts.SetSpendingLimits(ctx, accountId, limits)
+	if err != nil {
+		return nil, err
+	}
+	if accountRecord == nil {
+		return nil, AccountNotFound
+	}
+	return accountRecord, nil
+}
+
+func (s *service) SetAccountTier(ctx context.Context, accountId, tier string) (*account.Account, error) {
+	if !accountTierRegexp.MatchString(tier) {
+		return nil, &IncorrectInputData{
+			"account tier must be 1-64 characters long and contain only latin letters, digits, '_' and '-'",
+		}
+	}
+	accountRecord, err := s.accounts.SetTier(ctx, accountId, tier)
+	if err != nil {
+		return nil, err
+	}
+	if accountRecord == nil {
+		return nil, AccountNotFound
+	}
+	return accountRecord, nil
+}
+
+func (s *service) GetLedgerDiscrepancies(ctx context.Context) ([]*ledger.Discrepancy, error) {
+	return s.ledger.GetDiscrepancies(ctx)
+}
+
+// generateId returns a random 128-bit identifier in hex.
+func generateId() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
 func preparePagination(offset, limit *int) (*int, *int, error) {
 	if offset != nil && *offset < 0 {
 		return nil, nil, &IncorrectInputData{"'offset'pagination parameter must be >= 0"}
@@ -103,8 +1108,30 @@ func preparePagination(offset, limit *int) (*int, *int, error) {
 	return offset, limit, nil
 }
 
+var AccountNotFound = errors.New("account not found")
+var PaymentNotFound = errors.New("payment not found")
+var HoldNotFound = errors.New("hold not found")
+var ScheduledPaymentNotFound = errors.New("scheduled payment not found")
+var StandingOrderNotFound = errors.New("standing order not found")
 var FromAccountNotFound = errors.New("source account not found")
 var ToAccountNotFound = errors.New("destination account not found")
+var IdempotencyKeyReusedErr = errors.New("idempotency key has already been used for a request with different parameters")
+
+// BatchPayment is a payment of a batch, the amount is in the source account currency.
+type BatchPayment struct {
+	FromAccountId string       `json:"from_account"`
+	ToAccountId   string       `json:"to_account"`
+	Amount        money.Amount `json:"amount"`
+}
+
+// BatchError reports the payments that make a batch fail, none of the batch payments are sent.
+type BatchError struct {
+	Items []*payment.BatchItemError
+}
+
+func (e *BatchError) Error() string {
+	return "batch payment has been rejected, none of its payments have been sent"
+}
 
 type IncorrectInputData struct {
 	Details string
@@ -125,3 +1152,24 @@ func (e *DifferentCurrenciesError) Error() string {
 		e.FromAccountCurrency, e.ToAccountCurrency,
 	)
 }
+
+type ExchangeRateNotFoundError struct {
+	FromCurrency string
+	ToCurrency   string
+}
+
+func (e *ExchangeRateNotFoundError) Error() string {
+	return fmt.Sprintf("exchange rate from %s to %s isn't available", e.FromCurrency, e.ToCurrency)
+}
+
+// ExchangeRateUnavailableError means that the rates service failed to provide a usable rate,
+// the payment can be retried later.
+type ExchangeRateUnavailableError struct {
+	FromCurrency string
+	ToCurrency   string
+	Err          error
+}
+
+func (e *ExchangeRateUnavailableError) Error() string {
+	return fmt.Sprintf("exchange rate from %s to %s can't be fetched: %v", e.FromCurrency, e.ToCurrency, e.Err)
+}
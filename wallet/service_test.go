@@ -2,23 +2,76 @@ package wallet
 
 import (
 	"context"
+	"fmt"
 	"github.com/georgysavva/generic-wallet/account"
+	"github.com/georgysavva/generic-wallet/fee"
+	"github.com/georgysavva/generic-wallet/fx"
+	"github.com/georgysavva/generic-wallet/hold"
 	"github.com/georgysavva/generic-wallet/inmem_repository"
+	"github.com/georgysavva/generic-wallet/ledger"
+	"github.com/georgysavva/generic-wallet/money"
 	"github.com/georgysavva/generic-wallet/payment"
+	"github.com/georgysavva/generic-wallet/schedule"
+	"github.com/georgysavva/generic-wallet/standing"
+	kitlog "github.com/go-kit/kit/log"
 	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func instantiateServiceForTests() *service {
 	accounts := []*account.Account{
-		{Id: "alice", Balance: 100.0, Currency: "USD"},
-		{Id: "bob", Balance: 100.0, Currency: "USD"},
-		{Id: "mark", Balance: 100.0, Currency: "USD"},
-		{Id: "john", Balance: 100.0, Currency: "USD"},
-		{Id: "kate_in_europe", Balance: 100.0, Currency: "EUR"},
+		{Id: "alice", Balance: money.MustParse("100"), Currency: "USD", Status: account.ActiveStatus},
+		{Id: "bob", Balance: money.MustParse("100"), Currency: "USD", Status: account.ActiveStatus},
+		{Id: "mark", Balance: money.MustParse("100"), Currency: "USD", Status: account.ActiveStatus},
+		{Id: "john", Balance: money.MustParse("100"), Currency: "USD", Status: account.ActiveStatus},
+		{Id: "kate_in_europe", Balance: money.MustParse("100"), Currency: "EUR", Status: account.ActiveStatus},
 	}
-	accountsRepo, paymentsRepo := inmem_repository.InstantiateRepositories(accounts, nil)
-	return &service{payments: paymentsRepo, accounts: accountsRepo}
+	return newServiceForTests(inmem_repository.InstantiateRepositories(accounts, nil))
+}
+
+func newServiceForTests(repositories *inmem_repository.Repositories) *service {
+	return &service{
+		payments:    repositories.Payments,
+		accounts:    repositories.Accounts,
+		idempotency: repositories.Idempotency,
+		ledger:      repositories.Ledger,
+		holds:       repositories.Holds,
+		scheduled:   repositories.Scheduled,
+		standing:    repositories.Standing,
+	}
+}
+
+func amountPtr(s string) *money.Amount {
+	amount := money.MustParse(s)
+	return &amount
+}
+
+// withoutGeneratedFields returns copies of the payments with ids and timestamps reset,
+// so they can be compared with the expected payments.
+func withoutGeneratedFields(payments []*payment.Payment) []*payment.Payment {
+	var result []*payment.Payment
+	for _, p := range payments {
+		paymentCopy := *p
+		paymentCopy.Id = 0
+		paymentCopy.TransferId = ""
+		paymentCopy.CreatedAt = time.Time{}
+		result = append(result, &paymentCopy)
+	}
+	return result
+}
+
+// withSameCurrencyAmounts fills in the source and destination amounts and the exchange rate
+// of the expected payments between accounts with the same currency.
+func withSameCurrencyAmounts(payments []*payment.Payment) []*payment.Payment {
+	for _, p := range payments {
+		p.SourceAmount = p.Amount
+		p.DestinationAmount = p.Amount
+		p.ExchangeRate = money.MustParseRate("1")
+	}
+	return payments
 }
 
 func TestSendPayment_Single(t *testing.T) {
@@ -26,19 +79,19 @@ func TestSendPayment_Single(t *testing.T) {
 	ctx := context.Background()
 	var err error
 
-	err = s.SendPayment(ctx, "alice", "bob", 20.0)
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("20"), "")
 	assert.Equal(t, err, nil)
 	fromAccount, _ := s.accounts.Get(ctx, "alice")
 	toAccount, _ := s.accounts.Get(ctx, "bob")
-	assert.Equal(t, fromAccount.Balance, 80.0)
-	assert.Equal(t, toAccount.Balance, 120.0)
-	paymentsList, totalPayments, _ := s.GetAllPayments(ctx, nil, nil)
-	expectedPayments := []*payment.Payment{
-		{AccountId: "alice", ToAccountId: "bob", Amount: 20.0, Direction: payment.OutgoingDirection},
-		{AccountId: "bob", FromAccountId: "alice", Amount: 20.0, Direction: payment.IncomingDirection},
-	}
-	assert.Equal(t, expectedPayments, paymentsList)
-	assert.Equal(t, 2, totalPayments)
+	assert.Equal(t, fromAccount.Balance, money.MustParse("80"))
+	assert.Equal(t, toAccount.Balance, money.MustParse("120"))
+	paymentsList, paymentsPage, _ := s.GetAllPayments(ctx, payment.Filter{}, payment.Sort{}, Pagination{})
+	expectedPayments := withSameCurrencyAmounts([]*payment.Payment{
+		{AccountId: "alice", ToAccountId: "bob", Amount: money.MustParse("20"), Direction: payment.OutgoingDirection},
+		{AccountId: "bob", FromAccountId: "alice", Amount: money.MustParse("20"), Direction: payment.IncomingDirection},
+	})
+	assert.Equal(t, expectedPayments, withoutGeneratedFields(paymentsList))
+	assert.Equal(t, 2, *paymentsPage.TotalNumber)
 }
 
 func TestSendPayment_Bunch(t *testing.T) {
@@ -46,31 +99,31 @@ func TestSendPayment_Bunch(t *testing.T) {
 	ctx := context.Background()
 	var err error
 
-	err = s.SendPayment(ctx, "alice", "bob", 20.0)
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("20"), "")
 	assert.Equal(t, err, nil)
-	err = s.SendPayment(ctx, "alice", "john", 30.0)
+	_, err = s.SendPayment(ctx, "alice", "john", money.MustParse("30"), "")
 	assert.Equal(t, err, nil)
-	err = s.SendPayment(ctx, "mark", "bob", 40.0)
+	_, err = s.SendPayment(ctx, "mark", "bob", money.MustParse("40"), "")
 	assert.Equal(t, err, nil)
 	aliceAccount, _ := s.accounts.Get(ctx, "alice")
 	bobAccount, _ := s.accounts.Get(ctx, "bob")
 	johnAccount, _ := s.accounts.Get(ctx, "john")
 	markAccount, _ := s.accounts.Get(ctx, "mark")
-	assert.Equal(t, aliceAccount.Balance, 50.0)
-	assert.Equal(t, bobAccount.Balance, 160.0)
-	assert.Equal(t, johnAccount.Balance, 130.0)
-	assert.Equal(t, markAccount.Balance, 60.0)
-	paymentsList, totalPayments, _ := s.GetAllPayments(ctx, nil, nil)
-	expectedPayments := []*payment.Payment{
-		{AccountId: "alice", ToAccountId: "bob", Amount: 20.0, Direction: payment.OutgoingDirection},
-		{AccountId: "bob", FromAccountId: "alice", Amount: 20.0, Direction: payment.IncomingDirection},
-		{AccountId: "alice", ToAccountId: "john", Amount: 30.0, Direction: payment.OutgoingDirection},
-		{AccountId: "john", FromAccountId: "alice", Amount: 30.0, Direction: payment.IncomingDirection},
-		{AccountId: "mark", ToAccountId: "bob", Amount: 40.0, Direction: payment.OutgoingDirection},
-		{AccountId: "bob", FromAccountId: "mark", Amount: 40.0, Direction: payment.IncomingDirection},
-	}
-	assert.Equal(t, expectedPayments, paymentsList)
-	assert.Equal(t, 6, totalPayments)
+	assert.Equal(t, aliceAccount.Balance, money.MustParse("50"))
+	assert.Equal(t, bobAccount.Balance, money.MustParse("160"))
+	assert.Equal(t, johnAccount.Balance, money.MustParse("130"))
+	assert.Equal(t, markAccount.Balance, money.MustParse("60"))
+	paymentsList, paymentsPage, _ := s.GetAllPayments(ctx, payment.Filter{}, payment.Sort{}, Pagination{})
+	expectedPayments := withSameCurrencyAmounts([]*payment.Payment{
+		{AccountId: "alice", ToAccountId: "bob", Amount: money.MustParse("20"), Direction: payment.OutgoingDirection},
+		{AccountId: "bob", FromAccountId: "alice", Amount: money.MustParse("20"), Direction: payment.IncomingDirection},
+		{AccountId: "alice", ToAccountId: "john", Amount: money.MustParse("30"), Direction: payment.OutgoingDirection},
+		{AccountId: "john", FromAccountId: "alice", Amount: money.MustParse("30"), Direction: payment.IncomingDirection},
+		{AccountId: "mark", ToAccountId: "bob", Amount: money.MustParse("40"), Direction: payment.OutgoingDirection},
+		{AccountId: "bob", FromAccountId: "mark", Amount: money.MustParse("40"), Direction: payment.IncomingDirection},
+	})
+	assert.Equal(t, expectedPayments, withoutGeneratedFields(paymentsList))
+	assert.Equal(t, 6, *paymentsPage.TotalNumber)
 }
 
 func TestSendPayment_AccountDoesNotExist(t *testing.T) {
@@ -78,21 +131,21 @@ func TestSendPayment_AccountDoesNotExist(t *testing.T) {
 	ctx := context.Background()
 	var err error
 
-	err = s.SendPayment(ctx, "unknown_from_account", "bob", 20.0)
+	_, err = s.SendPayment(ctx, "unknown_from_account", "bob", money.MustParse("20"), "")
 	assert.Equal(t, err, FromAccountNotFound)
 	toAccount, _ := s.accounts.Get(ctx, "bob")
-	assert.Equal(t, toAccount.Balance, 100.0)
-	paymentsList, totalPayments, _ := s.GetAllPayments(ctx, nil, nil)
+	assert.Equal(t, toAccount.Balance, money.MustParse("100"))
+	paymentsList, paymentsPage, _ := s.GetAllPayments(ctx, payment.Filter{}, payment.Sort{}, Pagination{})
 	assert.Equal(t, 0, len(paymentsList))
-	assert.Equal(t, 0, totalPayments)
+	assert.Equal(t, 0, *paymentsPage.TotalNumber)
 
-	err = s.SendPayment(ctx, "alice", "unknown_to_account", 20.0)
+	_, err = s.SendPayment(ctx, "alice", "unknown_to_account", money.MustParse("20"), "")
 	assert.Equal(t, err, ToAccountNotFound)
 	fromAccount, _ := s.accounts.Get(ctx, "alice")
-	assert.Equal(t, fromAccount.Balance, 100.0)
-	paymentsList, totalPayments, _ = s.GetAllPayments(ctx, nil, nil)
+	assert.Equal(t, fromAccount.Balance, money.MustParse("100"))
+	paymentsList, paymentsPage, _ = s.GetAllPayments(ctx, payment.Filter{}, payment.Sort{}, Pagination{})
 	assert.Equal(t, 0, len(paymentsList))
-	assert.Equal(t, 0, totalPayments)
+	assert.Equal(t, 0, *paymentsPage.TotalNumber)
 }
 
 func TestSendPayment_DifferentCurrencies(t *testing.T) {
@@ -100,16 +153,84 @@ func TestSendPayment_DifferentCurrencies(t *testing.T) {
 	ctx := context.Background()
 	var err error
 
-	err = s.SendPayment(ctx, "alice", "kate_in_europe", 20.0)
+	_, err = s.SendPayment(ctx, "alice", "kate_in_europe", money.MustParse("20"), "")
 	_, ok := err.(*DifferentCurrenciesError)
 	assert.Equal(t, true, ok, "DifferentCurrenciesError type assertion")
 	fromAccount, _ := s.accounts.Get(ctx, "alice")
 	toAccount, _ := s.accounts.Get(ctx, "kate_in_europe")
-	assert.Equal(t, fromAccount.Balance, 100.0)
-	assert.Equal(t, toAccount.Balance, 100.0)
-	paymentsList, totalPayments, _ := s.GetAllPayments(ctx, nil, nil)
+	assert.Equal(t, fromAccount.Balance, money.MustParse("100"))
+	assert.Equal(t, toAccount.Balance, money.MustParse("100"))
+	paymentsList, paymentsPage, _ := s.GetAllPayments(ctx, payment.Filter{}, payment.Sort{}, Pagination{})
 	assert.Equal(t, 0, len(paymentsList))
-	assert.Equal(t, 0, totalPayments)
+	assert.Equal(t, 0, *paymentsPage.TotalNumber)
+}
+
+func TestSendPayment_CurrencyExchange(t *testing.T) {
+	s := instantiateServiceForTests()
+	s.rates = fx.NewStaticProvider(map[string]money.Rate{"USD/EUR": money.MustParseRate("0.912345")})
+	ctx := context.Background()
+
+	transfer, err := s.SendPayment(ctx, "alice", "kate_in_europe", money.MustParse("10.01"), "")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, money.MustParse("10.01"), transfer.Amount)
+	assert.Equal(t, money.MustParse("9.13"), transfer.DestinationAmount)
+	assert.Equal(t, money.MustParseRate("0.912345"), transfer.ExchangeRate)
+	fromAccount, _ := s.accounts.Get(ctx, "alice")
+	toAccount, _ := s.accounts.Get(ctx, "kate_in_europe")
+	assert.Equal(t, fromAccount.Balance, money.MustParse("89.99"))
+	assert.Equal(t, toAccount.Balance, money.MustParse("109.13"))
+	paymentsList, _, _ := s.GetAllPayments(ctx, payment.Filter{}, payment.Sort{}, Pagination{})
+	expectedPayments := []*payment.Payment{
+		{
+			AccountId: "alice", ToAccountId: "kate_in_europe", Amount: money.MustParse("10.01"),
+			SourceAmount: money.MustParse("10.01"), DestinationAmount: money.MustParse("9.13"),
+			ExchangeRate: money.MustParseRate("0.912345"), Direction: payment.OutgoingDirection,
+		},
+		{
+			AccountId: "kate_in_europe", FromAccountId: "alice", Amount: money.MustParse("9.13"),
+			SourceAmount: money.MustParse("10.01"), DestinationAmount: money.MustParse("9.13"),
+			ExchangeRate: money.MustParseRate("0.912345"), Direction: payment.IncomingDirection,
+		},
+	}
+	assert.Equal(t, expectedPayments, withoutGeneratedFields(paymentsList))
+
+	// There is no rate for the opposite direction.
+	_, err = s.SendPayment(ctx, "kate_in_europe", "alice", money.MustParse("5"), "")
+	_, ok := err.(*ExchangeRateNotFoundError)
+	assert.Equal(t, true, ok, "ExchangeRateNotFoundError type assertion")
+
+	// 0.01 USD is converted to 0.009 EUR and rounded up to 0.01 EUR.
+	transfer, err = s.SendPayment(ctx, "alice", "kate_in_europe", money.MustParse("0.01"), "")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, money.MustParse("0.01"), transfer.DestinationAmount)
+
+	// 0.01 USD is converted to 0.004 EUR and rounded down to zero.
+	s.rates = fx.NewStaticProvider(map[string]money.Rate{"USD/EUR": money.MustParseRate("0.4")})
+	_, err = s.SendPayment(ctx, "alice", "kate_in_europe", money.MustParse("0.01"), "")
+	_, ok = err.(*IncorrectInputData)
+	assert.Equal(t, true, ok, "IncorrectInputData type assertion")
+	fromAccount, _ = s.accounts.Get(ctx, "alice")
+	assert.Equal(t, fromAccount.Balance, money.MustParse("89.98"))
+}
+
+func TestSendPayment_ExchangeRateUnavailable(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The rate has more decimal places than a rate can be stored with.
+		fmt.Fprint(w, `{"rate": 0.9123456789012}`)
+	}))
+	defer stub.Close()
+	s := instantiateServiceForTests()
+	s.rates = fx.NewHTTPProvider(stub.URL, time.Second)
+	ctx := context.Background()
+
+	_, err := s.SendPayment(ctx, "alice", "kate_in_europe", money.MustParse("10"), "")
+	_, ok := err.(*ExchangeRateUnavailableError)
+	assert.Equal(t, true, ok, "ExchangeRateUnavailableError type assertion")
+	code, status := errorCodeAndStatus(err)
+	assert.Equal(t, exchangeRateUnavailableErrCode, code)
+	assert.Equal(t, http.StatusBadGateway, status)
+	fromAccount, _ := s.accounts.Get(ctx, "alice")
+	assert.Equal(t, fromAccount.Balance, money.MustParse("100"))
 }
 
 func TestSendPayment_LowBalance(t *testing.T) {
@@ -117,13 +238,1207 @@ func TestSendPayment_LowBalance(t *testing.T) {
 	ctx := context.Background()
 	var err error
 
-	err = s.SendPayment(ctx, "alice", "bob", 200.0)
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("200"), "")
 	assert.Equal(t, err, payment.LowBalanceErr)
 	fromAccount, _ := s.accounts.Get(ctx, "alice")
 	toAccount, _ := s.accounts.Get(ctx, "bob")
-	assert.Equal(t, fromAccount.Balance, 100.0)
-	assert.Equal(t, toAccount.Balance, 100.0)
-	paymentsList, totalPayments, _ := s.GetAllPayments(ctx, nil, nil)
+	assert.Equal(t, fromAccount.Balance, money.MustParse("100"))
+	assert.Equal(t, toAccount.Balance, money.MustParse("100"))
+	paymentsList, paymentsPage, _ := s.GetAllPayments(ctx, payment.Filter{}, payment.Sort{}, Pagination{})
+	assert.Equal(t, 0, len(paymentsList))
+	assert.Equal(t, 0, *paymentsPage.TotalNumber)
+}
+
+func TestSendPayment_ExactAmounts(t *testing.T) {
+	s := instantiateServiceForTests()
+	ctx := context.Background()
+	var err error
+
+	for i := 0; i < 10; i++ {
+		_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("0.1"), "")
+		assert.Equal(t, err, nil)
+	}
+	fromAccount, _ := s.accounts.Get(ctx, "alice")
+	toAccount, _ := s.accounts.Get(ctx, "bob")
+	assert.Equal(t, fromAccount.Balance, money.MustParse("99"))
+	assert.Equal(t, toAccount.Balance, money.MustParse("101"))
+}
+
+func TestSendPayment_TooManyDecimals(t *testing.T) {
+	s := instantiateServiceForTests()
+	ctx := context.Background()
+	var err error
+
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("0.001"), "")
+	_, ok := err.(*IncorrectInputData)
+	assert.Equal(t, true, ok, "IncorrectInputData type assertion")
+	fromAccount, _ := s.accounts.Get(ctx, "alice")
+	toAccount, _ := s.accounts.Get(ctx, "bob")
+	assert.Equal(t, fromAccount.Balance, money.MustParse("100"))
+	assert.Equal(t, toAccount.Balance, money.MustParse("100"))
+	paymentsList, paymentsPage, _ := s.GetAllPayments(ctx, payment.Filter{}, payment.Sort{}, Pagination{})
+	assert.Equal(t, 0, len(paymentsList))
+	assert.Equal(t, 0, *paymentsPage.TotalNumber)
+}
+
+func TestSendPayment_UnsupportedCurrency(t *testing.T) {
+	accounts := []*account.Account{
+		{Id: "alice", Balance: money.MustParse("100"), Currency: "USD", Status: account.ActiveStatus},
+		{Id: "legacy", Balance: money.MustParse("100"), Currency: "XYZ", Status: account.ActiveStatus},
+	}
+	s := newServiceForTests(inmem_repository.InstantiateRepositories(accounts, nil))
+	s.rates = fx.NewStaticProvider(map[string]money.Rate{"USD/XYZ": money.MustParseRate("2")})
+	ctx := context.Background()
+
+	// Amounts in an unsupported currency can't be validated, so they are rejected.
+	_, err := s.SendPayment(ctx, "legacy", "alice", money.MustParse("0.0001"), "")
+	assert.Equal(t, &IncorrectInputData{"currency XYZ isn't supported"}, err)
+	_, err = s.SendPayment(ctx, "alice", "legacy", money.MustParse("1"), "")
+	assert.Equal(t, &IncorrectInputData{"currency XYZ isn't supported"}, err)
+	fromAccount, _ := s.accounts.Get(ctx, "alice")
+	assert.Equal(t, fromAccount.Balance, money.MustParse("100"))
+}
+
+func TestSendPayment_IdempotentRetry(t *testing.T) {
+	s := instantiateServiceForTests()
+	ctx := context.Background()
+	var err error
+
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("20"), "key-1")
+	assert.Equal(t, err, nil)
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("20"), "key-1")
+	assert.Equal(t, err, nil)
+	fromAccount, _ := s.accounts.Get(ctx, "alice")
+	toAccount, _ := s.accounts.Get(ctx, "bob")
+	assert.Equal(t, fromAccount.Balance, money.MustParse("80"))
+	assert.Equal(t, toAccount.Balance, money.MustParse("120"))
+	_, paymentsPage, _ := s.GetAllPayments(ctx, payment.Filter{}, payment.Sort{}, Pagination{})
+	assert.Equal(t, 2, *paymentsPage.TotalNumber)
+
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("20"), "key-2")
+	assert.Equal(t, err, nil)
+	fromAccount, _ = s.accounts.Get(ctx, "alice")
+	assert.Equal(t, fromAccount.Balance, money.MustParse("60"))
+}
+
+func TestSendPayment_IdempotencyKeyReused(t *testing.T) {
+	s := instantiateServiceForTests()
+	ctx := context.Background()
+	var err error
+
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("20"), "key-1")
+	assert.Equal(t, err, nil)
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("30"), "key-1")
+	assert.Equal(t, err, IdempotencyKeyReusedErr)
+	_, err = s.SendPayment(ctx, "alice", "john", money.MustParse("20"), "key-1")
+	assert.Equal(t, err, IdempotencyKeyReusedErr)
+	fromAccount, _ := s.accounts.Get(ctx, "alice")
+	assert.Equal(t, fromAccount.Balance, money.MustParse("80"))
+	_, paymentsPage, _ := s.GetAllPayments(ctx, payment.Filter{}, payment.Sort{}, Pagination{})
+	assert.Equal(t, 2, *paymentsPage.TotalNumber)
+}
+
+func TestSendPayment_IdempotencyKeysScopedByCaller(t *testing.T) {
+	s := instantiateServiceForTests()
+	aliceCtx := context.WithValue(context.Background(), principalContextKey, &Principal{Id: "alice"})
+	bobCtx := context.WithValue(context.Background(), principalContextKey, &Principal{Id: "bob"})
+
+	aliceTransfer, err := s.SendPayment(aliceCtx, "alice", "john", money.MustParse("20"), "key-1")
+	assert.Equal(t, err, nil)
+	// Another caller's request with the same key and parameters isn't a retry of the first one.
+	bobTransfer, err := s.SendPayment(bobCtx, "alice", "john", money.MustParse("20"), "key-1")
+	assert.Equal(t, err, nil)
+	assert.NotEqual(t, aliceTransfer.Id, bobTransfer.Id)
+	_, err = s.SendPayment(bobCtx, "bob", "john", money.MustParse("30"), "key-2")
+	assert.Equal(t, err, nil)
+	_, err = s.SendPayment(aliceCtx, "alice", "mark", money.MustParse("10"), "key-2")
+	assert.Equal(t, err, nil)
+	toAccount, _ := s.accounts.Get(aliceCtx, "john")
+	assert.Equal(t, money.MustParse("170"), toAccount.Balance)
+}
+
+func TestSendPayment_IdempotentRetryAfterFailure(t *testing.T) {
+	s := instantiateServiceForTests()
+	ctx := context.Background()
+	var err error
+
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("200"), "key-1")
+	assert.Equal(t, err, payment.LowBalanceErr)
+	_, err = s.SendPayment(ctx, "bob", "alice", money.MustParse("100"), "")
+	assert.Equal(t, err, nil)
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("200"), "key-1")
+	assert.Equal(t, err, nil)
+	fromAccount, _ := s.accounts.Get(ctx, "alice")
+	assert.Equal(t, fromAccount.Balance, money.MustParse("0"))
+}
+
+func TestCreateAccount(t *testing.T) {
+	s := instantiateServiceForTests()
+	ctx := context.Background()
+
+	created, err := s.CreateAccount(ctx, "new_account", "EUR")
+	assert.Equal(t, err, nil)
+	expectedAccount := &account.Account{
+		Id: "new_account", Currency: "EUR", Status: account.ActiveStatus, Tier: account.StandardTier,
+	}
+	assert.Equal(t, expectedAccount, created)
+	fetched, err := s.GetAccount(ctx, "new_account")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, expectedAccount, fetched)
+	_, accountsPage, _ := s.GetAllAccounts(ctx, Pagination{})
+	assert.Equal(t, 6, *accountsPage.TotalNumber)
+
+	_, err = s.CreateAccount(ctx, "alice", "USD")
+	assert.Equal(t, err, account.AlreadyExistsErr)
+	_, err = s.CreateAccount(ctx, "another_account", "XYZ")
+	_, ok := err.(*IncorrectInputData)
+	assert.Equal(t, true, ok, "IncorrectInputData type assertion")
+	_, err = s.CreateAccount(ctx, "bad id", "USD")
+	_, ok = err.(*IncorrectInputData)
+	assert.Equal(t, true, ok, "IncorrectInputData type assertion")
+	_, accountsPage, _ = s.GetAllAccounts(ctx, Pagination{})
+	assert.Equal(t, 6, *accountsPage.TotalNumber)
+}
+
+func TestGetAccount_DoesNotExist(t *testing.T) {
+	s := instantiateServiceForTests()
+	ctx := context.Background()
+
+	_, err := s.GetAccount(ctx, "unknown_account")
+	assert.Equal(t, err, AccountNotFound)
+	_, err = s.CloseAccount(ctx, "unknown_account")
+	assert.Equal(t, err, AccountNotFound)
+}
+
+func TestCloseAccount(t *testing.T) {
+	s := instantiateServiceForTests()
+	ctx := context.Background()
+	var err error
+
+	_, err = s.CloseAccount(ctx, "alice")
+	assert.Equal(t, err, account.NonZeroBalanceErr)
+	aliceAccount, _ := s.GetAccount(ctx, "alice")
+	assert.Equal(t, account.ActiveStatus, aliceAccount.Status)
+
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("100"), "")
+	assert.Equal(t, err, nil)
+	closed, err := s.CloseAccount(ctx, "alice")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, account.ClosedStatus, closed.Status)
+	closed, err = s.CloseAccount(ctx, "alice")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, account.ClosedStatus, closed.Status)
+
+	_, err = s.SendPayment(ctx, "bob", "alice", money.MustParse("10"), "")
+	assert.Equal(t, err, payment.ToAccountClosedErr)
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("10"), "")
+	assert.Equal(t, err, payment.FromAccountClosedErr)
+	bobAccount, _ := s.GetAccount(ctx, "bob")
+	assert.Equal(t, money.MustParse("200"), bobAccount.Balance)
+	_, paymentsPage, _ := s.GetAllPayments(ctx, payment.Filter{}, payment.Sort{}, Pagination{})
+	assert.Equal(t, 2, *paymentsPage.TotalNumber)
+}
+
+func TestSetAccountStatus(t *testing.T) {
+	s := instantiateServiceForTests()
+	ctx := context.Background()
+	var err error
+
+	_, err = s.SetAccountStatus(ctx, "alice", account.ClosedStatus, "fraud")
+	assert.IsType(t, &IncorrectInputData{}, err)
+	_, err = s.SetAccountStatus(ctx, "alice", account.FrozenStatus, "")
+	assert.IsType(t, &IncorrectInputData{}, err)
+	_, err = s.SetAccountStatus(ctx, "unknown", account.FrozenStatus, "fraud")
+	assert.Equal(t, AccountNotFound, err)
+
+	frozen, err := s.SetAccountStatus(ctx, "alice", account.FrozenStatus, "fraud investigation")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, account.FrozenStatus, frozen.Status)
+	assert.Equal(t, "fraud investigation", frozen.StatusReason)
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("10"), "")
+	assert.Equal(t, payment.FromAccountFrozenErr, err)
+	_, err = s.SendPayment(ctx, "bob", "alice", money.MustParse("10"), "")
+	assert.Equal(t, nil, err)
+
+	_, err = s.SetAccountStatus(ctx, "bob", account.SuspendedStatus, "sanctions")
+	assert.Equal(t, nil, err)
+	_, err = s.SendPayment(ctx, "bob", "alice", money.MustParse("10"), "")
+	assert.Equal(t, payment.FromAccountSuspendedErr, err)
+	_, err = s.SetAccountStatus(ctx, "alice", account.ActiveStatus, "investigation is over")
+	assert.Equal(t, nil, err)
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("10"), "")
+	assert.Equal(t, payment.ToAccountSuspendedErr, err)
+
+	_, err = s.SetAccountStatus(ctx, "bob", account.ActiveStatus, "sanctions are lifted")
+	assert.Equal(t, nil, err)
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("10"), "")
+	assert.Equal(t, nil, err)
+	aliceAccount, _ := s.GetAccount(ctx, "alice")
+	assert.Equal(t, money.MustParse("100"), aliceAccount.Balance)
+	bobAccount, _ := s.GetAccount(ctx, "bob")
+	assert.Equal(t, money.MustParse("100"), bobAccount.Balance)
+
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("100"), "")
+	assert.Equal(t, nil, err)
+	_, err = s.CloseAccount(ctx, "alice")
+	assert.Equal(t, nil, err)
+	_, err = s.SetAccountStatus(ctx, "alice", account.ActiveStatus, "reopen")
+	assert.Equal(t, account.ClosedErr, err)
+}
+
+func TestSetOverdraftLimit(t *testing.T) {
+	s := instantiateServiceForTests()
+	ctx := context.Background()
+	var err error
+
+	_, err = s.SetOverdraftLimit(ctx, "alice", money.MustParse("-1"))
+	assert.IsType(t, &IncorrectInputData{}, err)
+	_, err = s.SetOverdraftLimit(ctx, "alice", money.MustParse("0.001"))
+	assert.IsType(t, &IncorrectInputData{}, err)
+	_, err = s.SetOverdraftLimit(ctx, "unknown", money.MustParse("50"))
+	assert.Equal(t, AccountNotFound, err)
+
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("120"), "")
+	assert.Equal(t, payment.LowBalanceErr, err)
+	aliceAccount, err := s.SetOverdraftLimit(ctx, "alice", money.MustParse("50"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, money.MustParse("50"), aliceAccount.OverdraftLimit)
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("120"), "")
+	assert.Equal(t, nil, err)
+	_, err = s.AuthorizePayment(ctx, "alice", "bob", money.MustParse("31"), 0)
+	assert.Equal(t, payment.LowBalanceErr, err)
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("30"), "")
+	assert.Equal(t, nil, err)
+	aliceAccount, _ = s.GetAccount(ctx, "alice")
+	assert.Equal(t, money.MustParse("-50"), aliceAccount.Balance)
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("0.01"), "")
+	assert.Equal(t, payment.LowBalanceErr, err)
+
+	// Lowering the limit doesn't affect the debt, but no more money can be spent.
+	_, err = s.SetOverdraftLimit(ctx, "alice", money.MustParse("0"))
+	assert.Equal(t, nil, err)
+	aliceAccount, _ = s.GetAccount(ctx, "alice")
+	assert.Equal(t, money.MustParse("-50"), aliceAccount.Balance)
+	_, err = s.SendPayment(ctx, "bob", "alice", money.MustParse("60"), "")
+	assert.Equal(t, nil, err)
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("10.01"), "")
+	assert.Equal(t, payment.LowBalanceErr, err)
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("10"), "")
+	assert.Equal(t, nil, err)
+}
+
+func TestSpendingLimits(t *testing.T) {
+	s := instantiateServiceForTests()
+	s.spendingLimits = map[string]*account.SpendingLimits{
+		"USD": {PerTransaction: amountPtr("40"), Daily: amountPtr("50")},
+	}
+	ctx := context.Background()
+	var err error
+
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("45"), "")
+	assert.Equal(t, &payment.LimitExceededError{Limit: payment.PerTransactionLimit, Remaining: money.MustParse("40")}, err)
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("30"), "")
+	assert.Equal(t, nil, err)
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("30"), "")
+	assert.Equal(t, &payment.LimitExceededError{Limit: payment.DailyLimit, Remaining: money.MustParse("20")}, err)
+	_, err = s.SendBatchPayment(ctx, []*BatchPayment{
+		{FromAccountId: "alice", ToAccountId: "bob", Amount: money.MustParse("15")},
+		{FromAccountId: "alice", ToAccountId: "mark", Amount: money.MustParse("15")},
+	})
+	assert.Equal(t, &BatchError{Items: []*payment.BatchItemError{{
+		Index: 1, Err: &payment.LimitExceededError{Limit: payment.DailyLimit, Remaining: money.MustParse("5")},
+	}}}, err)
+	// Refunds aren't limited and don't count as spent money.
+	transfer, err := s.SendPayment(ctx, "bob", "alice", money.MustParse("40"), "")
+	assert.Equal(t, nil, err)
+	_, err = s.RefundPayment(ctx, transfer.Payments[0].Id, nil)
+	assert.Equal(t, nil, err)
+
+	// The limits of the account override the currency defaults.
+	_, err = s.SetSpendingLimits(ctx, "alice", account.SpendingLimits{PerTransaction: amountPtr("-1")})
+	assert.IsType(t, &IncorrectInputData{}, err)
+	aliceAccount, err := s.SetSpendingLimits(ctx, "alice", account.SpendingLimits{Daily: amountPtr("60")})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, account.SpendingLimits{Daily: amountPtr("60")}, aliceAccount.SpendingLimits)
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("30"), "")
+	assert.Equal(t, nil, err)
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("0.01"), "")
+	assert.Equal(t, &payment.LimitExceededError{Limit: payment.DailyLimit, Remaining: money.MustParse("0")}, err)
+}
+
+func TestSpendingLimits_Holds(t *testing.T) {
+	s := instantiateServiceForTests()
+	s.spendingLimits = map[string]*account.SpendingLimits{
+		"USD": {PerTransaction: amountPtr("40"), Daily: amountPtr("50")},
+	}
+	ctx := context.Background()
+
+	_, err := s.SendPayment(ctx, "alice", "bob", money.MustParse("20"), "")
+	assert.Equal(t, nil, err)
+	_, err = s.AuthorizePayment(ctx, "alice", "bob", money.MustParse("45"), 0)
+	assert.Equal(t, &payment.LimitExceededError{Limit: payment.PerTransactionLimit, Remaining: money.MustParse("30")}, err)
+	_, err = s.AuthorizePayment(ctx, "alice", "bob", money.MustParse("35"), 0)
+	assert.Equal(t, &payment.LimitExceededError{Limit: payment.DailyLimit, Remaining: money.MustParse("30")}, err)
+	holdRecord, err := s.AuthorizePayment(ctx, "alice", "bob", money.MustParse("25"), 0)
+	assert.Equal(t, nil, err)
+	// The held amount counts as spent.
+	_, err = s.AuthorizePayment(ctx, "alice", "bob", money.MustParse("10"), 0)
+	assert.Equal(t, &payment.LimitExceededError{Limit: payment.DailyLimit, Remaining: money.MustParse("5")}, err)
+	// Direct payments can't spend the held amount again, so the hold can still be captured.
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("10"), "")
+	assert.Equal(t, &payment.LimitExceededError{Limit: payment.DailyLimit, Remaining: money.MustParse("5")}, err)
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("5"), "")
+	assert.Equal(t, nil, err)
+
+	_, _, err = s.CapturePayment(ctx, holdRecord.Id, nil)
+	assert.Equal(t, nil, err)
+}
+
+func TestSendPayment_Fee(t *testing.T) {
+	s := instantiateServiceForTests()
+	s.fees = fee.Schedule{
+		{Tier: "premium"},
+		{Currency: "USD", Flat: money.MustParse("1"), Rate: money.MustParse("0.1"), Max: amountPtr("5")},
+	}
+	ctx := context.Background()
+
+	transfer, err := s.SendPayment(ctx, "alice", "bob", money.MustParse("20"), "")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, money.MustParse("3"), transfer.Fee)
+	outgoing, incoming := transfer.Payments[0], transfer.Payments[1]
+	assert.Equal(t, money.MustParse("20"), outgoing.Amount)
+	assert.Equal(t, money.MustParse("3"), outgoing.Fee)
+	assert.Equal(t, money.MustParse("20"), incoming.Amount)
+	assert.Equal(t, money.MustParse("0"), incoming.Fee)
+	aliceAccount, _ := s.GetAccount(ctx, "alice")
+	assert.Equal(t, money.MustParse("77"), aliceAccount.Balance)
+	bobAccount, _ := s.GetAccount(ctx, "bob")
+	assert.Equal(t, money.MustParse("120"), bobAccount.Balance)
+	transaction, _ := s.ledger.GetTransaction(ctx, transfer.Id)
+	assert.Equal(t, ledger.RevenueAccount("USD"), transaction.Postings[1].AccountId)
+	assert.Equal(t, money.MustParse("3"), transaction.Postings[1].Amount)
+	discrepancies, _ := s.GetLedgerDiscrepancies(ctx)
+	assert.Equal(t, 0, len(discrepancies))
+
+	// The balance must cover the fee too.
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("73"), "")
+	assert.Equal(t, payment.LowBalanceErr, err)
+	transfer, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("72"), "")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, money.MustParse("5"), transfer.Fee)
+
+	_, err = s.SetAccountTier(ctx, "bob", "premium")
+	assert.Equal(t, nil, err)
+	transfer, err = s.SendPayment(ctx, "bob", "alice", money.MustParse("50"), "")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, money.MustParse("0"), transfer.Fee)
+	refund, err := s.RefundPayment(ctx, transfer.Payments[0].Id, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, money.MustParse("0"), refund.Fee)
+}
+
+func TestGetAccountPayments(t *testing.T) {
+	s := instantiateServiceForTests()
+	ctx := context.Background()
+	var err error
+
+	_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("20"), "")
+	assert.Equal(t, err, nil)
+	_, err = s.SendPayment(ctx, "mark", "john", money.MustParse("30"), "")
+	assert.Equal(t, err, nil)
+	_, err = s.SendPayment(ctx, "bob", "alice", money.MustParse("5"), "")
+	assert.Equal(t, err, nil)
+
+	paymentsList, totalPayments, err := s.GetAccountPayments(ctx, "alice", nil, nil)
+	assert.Equal(t, err, nil)
+	expectedPayments := withSameCurrencyAmounts([]*payment.Payment{
+		{AccountId: "alice", ToAccountId: "bob", Amount: money.MustParse("20"), Direction: payment.OutgoingDirection},
+		{AccountId: "alice", FromAccountId: "bob", Amount: money.MustParse("5"), Direction: payment.IncomingDirection},
+	})
+	assert.Equal(t, expectedPayments, withoutGeneratedFields(paymentsList))
+	assert.Equal(t, 2, totalPayments)
+
+	offset, limit := 1, 1
+	paymentsList, totalPayments, err = s.GetAccountPayments(ctx, "alice", &offset, &limit)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, expectedPayments[1:], withoutGeneratedFields(paymentsList))
+	assert.Equal(t, 2, totalPayments)
+
+	paymentsList, totalPayments, err = s.GetAccountPayments(ctx, "kate_in_europe", nil, nil)
+	assert.Equal(t, err, nil)
 	assert.Equal(t, 0, len(paymentsList))
 	assert.Equal(t, 0, totalPayments)
+
+	_, _, err = s.GetAccountPayments(ctx, "unknown_account", nil, nil)
+	assert.Equal(t, err, AccountNotFound)
+}
+
+func TestSendPayment_Transfer(t *testing.T) {
+	s := instantiateServiceForTests()
+	ctx := context.Background()
+
+	transfer, err := s.SendPayment(ctx, "alice", "bob", money.MustParse("20"), "")
+	assert.Equal(t, err, nil)
+	assert.NotEqual(t, "", transfer.Id)
+	assert.Equal(t, "alice", transfer.FromAccountId)
+	assert.Equal(t, "bob", transfer.ToAccountId)
+	assert.Equal(t, money.MustParse("20"), transfer.Amount)
+	assert.Equal(t, false, transfer.CreatedAt.IsZero())
+	assert.Equal(t, 2, len(transfer.Payments))
+	outgoingPayment, incomingPayment := transfer.Payments[0], transfer.Payments[1]
+	assert.Equal(t, payment.OutgoingDirection, outgoingPayment.Direction)
+	assert.Equal(t, payment.IncomingDirection, incomingPayment.Direction)
+	assert.NotEqual(t, outgoingPayment.Id, incomingPayment.Id)
+	for _, p := range transfer.Payments {
+		assert.Equal(t, transfer.Id, p.TransferId)
+		assert.Equal(t, transfer.CreatedAt, p.CreatedAt)
+		fetched, err := s.GetPayment(ctx, p.Id)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, p, fetched)
+	}
+
+	anotherTransfer, err := s.SendPayment(ctx, "alice", "bob", money.MustParse("20"), "")
+	assert.Equal(t, err, nil)
+	assert.NotEqual(t, transfer.Id, anotherTransfer.Id)
+
+	_, err = s.GetPayment(ctx, 100)
+	assert.Equal(t, err, PaymentNotFound)
+}
+
+func TestSendPayment_IdempotentRetryReturnsTransfer(t *testing.T) {
+	s := instantiateServiceForTests()
+	ctx := context.Background()
+
+	transfer, err := s.SendPayment(ctx, "alice", "bob", money.MustParse("20"), "key-1")
+	assert.Equal(t, err, nil)
+	replayedTransfer, err := s.SendPayment(ctx, "alice", "bob", money.MustParse("20"), "key-1")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, transfer.Id, replayedTransfer.Id)
+	assert.Equal(t, transfer.Payments[0].Id, replayedTransfer.Payments[0].Id)
+	assert.Equal(t, transfer.Payments[1].Id, replayedTransfer.Payments[1].Id)
+	assert.Equal(t, true, transfer.CreatedAt.Equal(replayedTransfer.CreatedAt))
+}
+
+func TestGetAllPayments_Cursor(t *testing.T) {
+	s := instantiateServiceForTests()
+	ctx := context.Background()
+	var err error
+
+	for i := 0; i < 3; i++ {
+		_, err = s.SendPayment(ctx, "alice", "bob", money.MustParse("1"), "")
+		assert.Equal(t, err, nil)
+	}
+	limit := 4
+	firstPage, firstPageInfo, err := s.GetAllPayments(ctx, payment.Filter{}, payment.Sort{}, Pagination{Limit: &limit})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 4, len(firstPage))
+	assert.Equal(t, 6, *firstPageInfo.TotalNumber)
+	assert.Equal(t, "", firstPageInfo.PrevCursor)
+	assert.NotEqual(t, "", firstPageInfo.NextCursor)
+
+	// New payments don't shift the pages in cursor mode.
+	_, err = s.SendPayment(ctx, "mark", "john", money.MustParse("1"), "")
+	assert.Equal(t, err, nil)
+
+	secondPage, secondPageInfo, err := s.GetAllPayments(ctx, payment.Filter{}, payment.Sort{}, Pagination{Limit: &limit, Cursor: firstPageInfo.NextCursor})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 4, len(secondPage))
+	assert.Equal(t, firstPage[3].Id+1, secondPage[0].Id)
+	assert.Equal(t, (*int)(nil), secondPageInfo.TotalNumber)
+	assert.NotEqual(t, "", secondPageInfo.PrevCursor)
+	assert.Equal(t, "", secondPageInfo.NextCursor)
+
+	prevPage, prevPageInfo, err := s.GetAllPayments(ctx, payment.Filter{}, payment.Sort{}, Pagination{Limit: &limit, Cursor: secondPageInfo.PrevCursor})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, firstPage, prevPage)
+	assert.Equal(t, "", prevPageInfo.PrevCursor)
+	assert.NotEqual(t, "", prevPageInfo.NextCursor)
+
+	offset := 1
+	_, _, err = s.GetAllPayments(ctx, payment.Filter{}, payment.Sort{}, Pagination{Offset: &offset, Cursor: firstPageInfo.NextCursor})
+	_, ok := err.(*IncorrectInputData)
+	assert.Equal(t, true, ok, "IncorrectInputData type assertion")
+	_, _, err = s.GetAllPayments(ctx, payment.Filter{}, payment.Sort{}, Pagination{Cursor: "garbage"})
+	_, ok = err.(*IncorrectInputData)
+	assert.Equal(t, true, ok, "IncorrectInputData type assertion")
+
+	// Cursors point into the list selected by the filter, so they can't be used with another filter or sort.
+	filter := payment.Filter{AccountId: "alice"}
+	limit = 1
+	_, filteredPageInfo, err := s.GetAllPayments(ctx, filter, payment.Sort{}, Pagination{Limit: &limit})
+	assert.Equal(t, err, nil)
+	assert.NotEqual(t, "", filteredPageInfo.NextCursor)
+	_, _, err = s.GetAllPayments(ctx, filter, payment.Sort{}, Pagination{Cursor: filteredPageInfo.NextCursor})
+	assert.Equal(t, err, nil)
+	_, _, err = s.GetAllPayments(ctx, payment.Filter{AccountId: "bob"}, payment.Sort{}, Pagination{Cursor: filteredPageInfo.NextCursor})
+	_, ok = err.(*IncorrectInputData)
+	assert.Equal(t, true, ok, "IncorrectInputData type assertion")
+	_, _, err = s.GetAllPayments(ctx, payment.Filter{}, payment.Sort{}, Pagination{Cursor: filteredPageInfo.NextCursor})
+	_, ok = err.(*IncorrectInputData)
+	assert.Equal(t, true, ok, "IncorrectInputData type assertion")
+	_, _, err = s.GetAllPayments(ctx, filter, payment.Sort{Descending: true}, Pagination{Cursor: filteredPageInfo.NextCursor})
+	_, ok = err.(*IncorrectInputData)
+	assert.Equal(t, true, ok, "IncorrectInputData type assertion")
+}
+
+func TestGetAllAccounts_Cursor(t *testing.T) {
+	s := instantiateServiceForTests()
+	ctx := context.Background()
+
+	limit := 2
+	offset := 1
+	accountsList, accountsPage, err := s.GetAllAccounts(ctx, Pagination{Offset: &offset, Limit: &limit})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, []string{"bob", "john"}, accountKeys(accountsList))
+	assert.Equal(t, 5, *accountsPage.TotalNumber)
+
+	accountsList, nextPage, err := s.GetAllAccounts(ctx, Pagination{Limit: &limit, Cursor: accountsPage.NextCursor})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, []string{"kate_in_europe", "mark"}, accountKeys(accountsList))
+	assert.Equal(t, "", nextPage.NextCursor)
+	assert.NotEqual(t, "", nextPage.PrevCursor)
+
+	accountsList, prevPage, err := s.GetAllAccounts(ctx, Pagination{Limit: &limit, Cursor: accountsPage.PrevCursor})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, []string{"alice"}, accountKeys(accountsList))
+	assert.Equal(t, "", prevPage.PrevCursor)
+	assert.NotEqual(t, "", prevPage.NextCursor)
+
+	// Cursors can't be used with other lists.
+	_, _, err = s.GetAllPayments(ctx, payment.Filter{}, payment.Sort{}, Pagination{Cursor: accountsPage.NextCursor})
+	_, ok := err.(*IncorrectInputData)
+	assert.Equal(t, true, ok, "IncorrectInputData type assertion")
+}
+
+func TestSendPayment_Ledger(t *testing.T) {
+	s := instantiateServiceForTests()
+	s.rates = fx.NewStaticProvider(map[string]money.Rate{"USD/EUR": money.MustParseRate("0.9")})
+	ctx := context.Background()
+
+	sameCurrencyTransfer, err := s.SendPayment(ctx, "alice", "bob", money.MustParse("20"), "")
+	assert.Equal(t, err, nil)
+	crossCurrencyTransfer, err := s.SendPayment(ctx, "alice", "kate_in_europe", money.MustParse("10"), "")
+	assert.Equal(t, err, nil)
+
+	transaction, err := s.ledger.GetTransaction(ctx, sameCurrencyTransfer.Id)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 2, len(transaction.Postings))
+	// Payments are the postings of the transfer to regular accounts.
+	for i, p := range sameCurrencyTransfer.Payments {
+		assert.Equal(t, transaction.Postings[i].Id, p.Id)
+	}
+	transaction, err = s.ledger.GetTransaction(ctx, crossCurrencyTransfer.Id)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 4, len(transaction.Postings))
+	assert.Equal(t, 2, len(crossCurrencyTransfer.Payments))
+
+	discrepancies, err := s.GetLedgerDiscrepancies(ctx)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 0, len(discrepancies))
+
+	bobAccount, _ := s.accounts.Get(ctx, "bob")
+	bobAccount.Balance = money.MustParse("1000")
+	discrepancies, err = s.GetLedgerDiscrepancies(ctx)
+	assert.Equal(t, err, nil)
+	expectedDiscrepancies := []*ledger.Discrepancy{
+		{AccountId: "bob", Balance: money.MustParse("1000"), LedgerBalance: money.MustParse("120")},
+	}
+	assert.Equal(t, expectedDiscrepancies, discrepancies)
+}
+
+func TestRefundPayment(t *testing.T) {
+	s := instantiateServiceForTests()
+	ctx := context.Background()
+
+	transfer, err := s.SendPayment(ctx, "alice", "bob", money.MustParse("30"), "")
+	assert.Equal(t, err, nil)
+	outgoingPayment := transfer.Payments[0]
+
+	partialAmount := money.MustParse("10")
+	refund, err := s.RefundPayment(ctx, outgoingPayment.Id, &partialAmount)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, "bob", refund.FromAccountId)
+	assert.Equal(t, "alice", refund.ToAccountId)
+	assert.Equal(t, partialAmount, refund.Amount)
+	assert.Equal(t, transfer.Id, refund.RefundedTransferId)
+	aliceAccount, _ := s.accounts.Get(ctx, "alice")
+	bobAccount, _ := s.accounts.Get(ctx, "bob")
+	assert.Equal(t, money.MustParse("80"), aliceAccount.Balance)
+	assert.Equal(t, money.MustParse("120"), bobAccount.Balance)
+
+	tooBigAmount := money.MustParse("20.01")
+	_, err = s.RefundPayment(ctx, outgoingPayment.Id, &tooBigAmount)
+	assert.Equal(t, payment.RefundExceedsAmountErr, err)
+	_, err = s.RefundPayment(ctx, refund.Payments[0].Id, nil)
+	_, ok := err.(*IncorrectInputData)
+	assert.Equal(t, true, ok, "IncorrectInputData type assertion")
+
+	// Refunding the incoming payment refunds the same transfer, nil amount refunds the rest.
+	refund, err = s.RefundPayment(ctx, transfer.Payments[1].Id, nil)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, money.MustParse("20"), refund.Amount)
+	_, err = s.RefundPayment(ctx, outgoingPayment.Id, nil)
+	assert.Equal(t, payment.RefundExceedsAmountErr, err)
+	aliceAccount, _ = s.accounts.Get(ctx, "alice")
+	bobAccount, _ = s.accounts.Get(ctx, "bob")
+	assert.Equal(t, money.MustParse("100"), aliceAccount.Balance)
+	assert.Equal(t, money.MustParse("100"), bobAccount.Balance)
+
+	// Listings link refunds to the refunded transfer and show the refunded total.
+	paymentsList, _, err := s.GetAllPayments(ctx, payment.Filter{AccountId: "alice"}, payment.Sort{}, Pagination{})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 3, len(paymentsList))
+	assert.Equal(t, money.MustParse("30"), paymentsList[0].RefundedAmount)
+	assert.Equal(t, transfer.Id, paymentsList[1].RefundedTransferId)
+	assert.Equal(t, transfer.Id, paymentsList[2].RefundedTransferId)
+
+	_, err = s.RefundPayment(ctx, 1000, nil)
+	assert.Equal(t, PaymentNotFound, err)
+	discrepancies, _ := s.GetLedgerDiscrepancies(ctx)
+	assert.Equal(t, 0, len(discrepancies))
+}
+
+func TestRefundPayment_InitialPayment(t *testing.T) {
+	s := instantiateServiceWithPaymentsForTests()
+	ctx := context.Background()
+
+	partialAmount := money.MustParse("10")
+	refund, err := s.RefundPayment(ctx, 1, &partialAmount)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, "t1", refund.RefundedTransferId)
+	assert.Equal(t, "bob", refund.FromAccountId)
+	refund, err = s.RefundPayment(ctx, 2, nil)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, money.MustParse("20"), refund.Amount)
+	_, err = s.RefundPayment(ctx, 1, nil)
+	assert.Equal(t, payment.RefundExceedsAmountErr, err)
+	aliceAccount, _ := s.accounts.Get(ctx, "alice")
+	bobAccount, _ := s.accounts.Get(ctx, "bob")
+	assert.Equal(t, money.MustParse("130"), aliceAccount.Balance)
+	assert.Equal(t, money.MustParse("70"), bobAccount.Balance)
+	initialPayment, _ := s.payments.Get(ctx, 1)
+	assert.Equal(t, money.MustParse("30"), initialPayment.RefundedAmount)
+}
+
+func TestRefundPayment_CurrencyExchange(t *testing.T) {
+	s := instantiateServiceForTests()
+	s.rates = fx.NewStaticProvider(map[string]money.Rate{"USD/EUR": money.MustParseRate("0.9")})
+	ctx := context.Background()
+
+	transfer, err := s.SendPayment(ctx, "alice", "kate_in_europe", money.MustParse("10.05"), "")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, money.MustParse("9.05"), transfer.DestinationAmount)
+
+	// 5 USD is 4.5 EUR at the transfer rate.
+	partialAmount := money.MustParse("5")
+	refund, err := s.RefundPayment(ctx, transfer.Payments[0].Id, &partialAmount)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, money.MustParse("4.5"), refund.Amount)
+	assert.Equal(t, "EUR", refund.Currency)
+	assert.Equal(t, money.MustParse("5"), refund.DestinationAmount)
+	assert.Equal(t, "USD", refund.DestinationCurrency)
+
+	// The last refund takes the rest of the destination amount regardless of rounding.
+	refund, err = s.RefundPayment(ctx, transfer.Payments[0].Id, nil)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, money.MustParse("4.55"), refund.Amount)
+	assert.Equal(t, money.MustParse("5.05"), refund.DestinationAmount)
+	aliceAccount, _ := s.accounts.Get(ctx, "alice")
+	kateAccount, _ := s.accounts.Get(ctx, "kate_in_europe")
+	assert.Equal(t, money.MustParse("100"), aliceAccount.Balance)
+	assert.Equal(t, money.MustParse("100"), kateAccount.Balance)
+	discrepancies, _ := s.GetLedgerDiscrepancies(ctx)
+	assert.Equal(t, 0, len(discrepancies))
+}
+
+func instantiateServiceWithPaymentsForTests() *service {
+	accounts := []*account.Account{
+		{Id: "alice", Balance: money.MustParse("100"), Currency: "USD", Status: account.ActiveStatus},
+		{Id: "bob", Balance: money.MustParse("100"), Currency: "USD", Status: account.ActiveStatus},
+		{Id: "mark", Balance: money.MustParse("100"), Currency: "USD", Status: account.ActiveStatus},
+	}
+	day := func(d int) time.Time {
+		return time.Date(2019, 4, d, 12, 0, 0, 0, time.UTC)
+	}
+	payments := withSameCurrencyAmounts([]*payment.Payment{
+		{Id: 1, TransferId: "t1", AccountId: "alice", ToAccountId: "bob", Amount: money.MustParse("30"), Direction: payment.OutgoingDirection, CreatedAt: day(1)},
+		{Id: 2, TransferId: "t1", AccountId: "bob", FromAccountId: "alice", Amount: money.MustParse("30"), Direction: payment.IncomingDirection, CreatedAt: day(1)},
+		{Id: 3, TransferId: "t2", AccountId: "mark", ToAccountId: "alice", Amount: money.MustParse("10"), Direction: payment.OutgoingDirection, CreatedAt: day(3)},
+		{Id: 4, TransferId: "t2", AccountId: "alice", FromAccountId: "mark", Amount: money.MustParse("10"), Direction: payment.IncomingDirection, CreatedAt: day(3)},
+		{Id: 5, TransferId: "t3", AccountId: "alice", ToAccountId: "mark", Amount: money.MustParse("20"), Direction: payment.OutgoingDirection, CreatedAt: day(2)},
+		{Id: 6, TransferId: "t3", AccountId: "mark", FromAccountId: "alice", Amount: money.MustParse("20"), Direction: payment.IncomingDirection, CreatedAt: day(2)},
+	})
+	return newServiceForTests(inmem_repository.InstantiateRepositories(accounts, payments))
+}
+
+func paymentIds(payments []*payment.Payment) []int64 {
+	var ids []int64
+	for _, p := range payments {
+		ids = append(ids, p.Id)
+	}
+	return ids
+}
+
+func TestGetAllPayments_Filter(t *testing.T) {
+	s := instantiateServiceWithPaymentsForTests()
+	ctx := context.Background()
+	minAmount, maxAmount := money.MustParse("15"), money.MustParse("20")
+	createdFrom := time.Date(2019, 4, 2, 0, 0, 0, 0, time.UTC)
+	createdTo := time.Date(2019, 4, 3, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		filter      payment.Filter
+		expectedIds []int64
+	}{
+		{payment.Filter{AccountId: "alice"}, []int64{1, 4, 5}},
+		{payment.Filter{CounterpartyId: "alice"}, []int64{2, 3, 6}},
+		{payment.Filter{AccountId: "alice", Direction: payment.OutgoingDirection}, []int64{1, 5}},
+		{payment.Filter{MinAmount: &minAmount}, []int64{1, 2, 5, 6}},
+		{payment.Filter{MinAmount: &minAmount, MaxAmount: &maxAmount}, []int64{5, 6}},
+		{payment.Filter{CreatedFrom: &createdFrom}, []int64{3, 4, 5, 6}},
+		{payment.Filter{CreatedFrom: &createdFrom, CreatedTo: &createdTo}, []int64{5, 6}},
+		{payment.Filter{AccountId: "bob", Direction: payment.OutgoingDirection}, nil},
+	}
+	for _, c := range cases {
+		paymentsList, paymentsPage, err := s.GetAllPayments(ctx, c.filter, payment.Sort{}, Pagination{})
+		assert.Equal(t, err, nil)
+		assert.Equal(t, c.expectedIds, paymentIds(paymentsList), "%+v", c.filter)
+		assert.Equal(t, len(c.expectedIds), *paymentsPage.TotalNumber, "%+v", c.filter)
+	}
+
+	_, _, err := s.GetAllPayments(ctx, payment.Filter{Direction: "sideways"}, payment.Sort{}, Pagination{})
+	_, ok := err.(*IncorrectInputData)
+	assert.Equal(t, true, ok, "IncorrectInputData type assertion")
+	_, _, err = s.GetAllPayments(
+		ctx, payment.Filter{MinAmount: &maxAmount, MaxAmount: &minAmount}, payment.Sort{}, Pagination{},
+	)
+	_, ok = err.(*IncorrectInputData)
+	assert.Equal(t, true, ok, "IncorrectInputData type assertion")
+}
+
+func TestGetAllPayments_Sort(t *testing.T) {
+	s := instantiateServiceWithPaymentsForTests()
+	ctx := context.Background()
+
+	cases := []struct {
+		sort        payment.Sort
+		expectedIds []int64
+	}{
+		{payment.Sort{}, []int64{1, 2, 3, 4, 5, 6}},
+		{payment.Sort{Descending: true}, []int64{6, 5, 4, 3, 2, 1}},
+		{payment.Sort{By: payment.SortByTime}, []int64{1, 2, 5, 6, 3, 4}},
+		{payment.Sort{By: payment.SortByTime, Descending: true}, []int64{4, 3, 6, 5, 2, 1}},
+		{payment.Sort{By: payment.SortByAmount}, []int64{3, 4, 5, 6, 1, 2}},
+		{payment.Sort{By: payment.SortByAmount, Descending: true}, []int64{2, 1, 6, 5, 4, 3}},
+	}
+	for _, c := range cases {
+		paymentsList, _, err := s.GetAllPayments(ctx, payment.Filter{}, c.sort, Pagination{})
+		assert.Equal(t, err, nil)
+		assert.Equal(t, c.expectedIds, paymentIds(paymentsList), "%+v", c.sort)
+
+		// Walk through the same list with cursors, forwards and backwards.
+		limit := 4
+		firstPage, firstPageInfo, err := s.GetAllPayments(ctx, payment.Filter{}, c.sort, Pagination{Limit: &limit})
+		assert.Equal(t, err, nil)
+		secondPage, secondPageInfo, err := s.GetAllPayments(
+			ctx, payment.Filter{}, c.sort, Pagination{Limit: &limit, Cursor: firstPageInfo.NextCursor},
+		)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, c.expectedIds, append(paymentIds(firstPage), paymentIds(secondPage)...), "%+v", c.sort)
+		prevPage, _, err := s.GetAllPayments(
+			ctx, payment.Filter{}, c.sort, Pagination{Limit: &limit, Cursor: secondPageInfo.PrevCursor},
+		)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, paymentIds(firstPage), paymentIds(prevPage), "%+v", c.sort)
+	}
+
+	_, _, err := s.GetAllPayments(ctx, payment.Filter{}, payment.Sort{By: "color"}, Pagination{})
+	_, ok := err.(*IncorrectInputData)
+	assert.Equal(t, true, ok, "IncorrectInputData type assertion")
+
+	// Cursors are bound to the sort they were issued for.
+	limit := 1
+	_, pageInfo, _ := s.GetAllPayments(ctx, payment.Filter{}, payment.Sort{By: payment.SortByAmount}, Pagination{Limit: &limit})
+	_, _, err = s.GetAllPayments(ctx, payment.Filter{}, payment.Sort{}, Pagination{Cursor: pageInfo.NextCursor})
+	_, ok = err.(*IncorrectInputData)
+	assert.Equal(t, true, ok, "IncorrectInputData type assertion")
+}
+
+func TestAuthorizePayment(t *testing.T) {
+	s := instantiateServiceForTests()
+	ctx := context.Background()
+
+	holdRecord, err := s.AuthorizePayment(ctx, "alice", "bob", money.MustParse("70"), 0)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, hold.ActiveStatus, holdRecord.Status)
+	fromAccount, _ := s.GetAccount(ctx, "alice")
+	assert.Equal(t, money.MustParse("100"), fromAccount.Balance)
+	assert.Equal(t, money.MustParse("30"), fromAccount.AvailableBalance)
+
+	// The reserved money can't be spent by other payments.
+	_, err = s.SendPayment(ctx, "alice", "mark", money.MustParse("40"), "")
+	assert.Equal(t, payment.LowBalanceErr, err)
+	_, err = s.AuthorizePayment(ctx, "alice", "mark", money.MustParse("40"), 0)
+	assert.Equal(t, payment.LowBalanceErr, err)
+
+	_, err = s.AuthorizePayment(ctx, "alice", "bob", money.MustParse("10"), maxHoldExpiration+time.Hour)
+	_, ok := err.(*IncorrectInputData)
+	assert.Equal(t, true, ok, "IncorrectInputData type assertion")
+}
+
+func TestCapturePayment(t *testing.T) {
+	s := instantiateServiceForTests()
+	ctx := context.Background()
+
+	holdRecord, _ := s.AuthorizePayment(ctx, "alice", "bob", money.MustParse("70"), 0)
+	captureAmount := money.MustParse("80")
+	_, _, err := s.CapturePayment(ctx, holdRecord.Id, &captureAmount)
+	assert.Equal(t, hold.CaptureExceedsAmountErr, err)
+
+	// A partial capture releases the rest of the reserved money.
+	captureAmount = money.MustParse("50")
+	holdRecord, transfer, err := s.CapturePayment(ctx, holdRecord.Id, &captureAmount)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, hold.CapturedStatus, holdRecord.Status)
+	assert.Equal(t, captureAmount, holdRecord.CapturedAmount)
+	assert.Equal(t, transfer.Id, holdRecord.TransferId)
+	fromAccount, _ := s.GetAccount(ctx, "alice")
+	toAccount, _ := s.GetAccount(ctx, "bob")
+	assert.Equal(t, money.MustParse("50"), fromAccount.Balance)
+	assert.Equal(t, money.MustParse("50"), fromAccount.AvailableBalance)
+	assert.Equal(t, money.MustParse("150"), toAccount.Balance)
+
+	_, _, err = s.CapturePayment(ctx, holdRecord.Id, nil)
+	assert.Equal(t, hold.NotActiveErr, err)
+	_, err = s.VoidPayment(ctx, holdRecord.Id)
+	assert.Equal(t, hold.NotActiveErr, err)
+	_, _, err = s.CapturePayment(ctx, "unknown", nil)
+	assert.Equal(t, HoldNotFound, err)
+
+	discrepancies, err := s.GetLedgerDiscrepancies(ctx)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 0, len(discrepancies))
+}
+
+func TestCapturePayment_Fee(t *testing.T) {
+	s := instantiateServiceForTests()
+	s.fees = fee.Schedule{{Currency: "USD", Flat: money.MustParse("1"), Rate: money.MustParse("0.1"), Max: amountPtr("5")}}
+	ctx := context.Background()
+
+	// The balance covers the amount, but not the amount with the fee.
+	_, err := s.AuthorizePayment(ctx, "alice", "bob", money.MustParse("98"), 0)
+	assert.Equal(t, payment.LowBalanceErr, err)
+	holdRecord, err := s.AuthorizePayment(ctx, "alice", "bob", money.MustParse("95"), 0)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, money.MustParse("5"), holdRecord.Fee)
+	fromAccount, _ := s.GetAccount(ctx, "alice")
+	assert.Equal(t, money.MustParse("0"), fromAccount.AvailableBalance)
+
+	// The fees have risen since the authorization, the capture charges the reserved fee.
+	s.fees = fee.Schedule{{Currency: "USD", Flat: money.MustParse("10")}}
+	holdRecord, transfer, err := s.CapturePayment(ctx, holdRecord.Id, nil)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, hold.CapturedStatus, holdRecord.Status)
+	assert.Equal(t, money.MustParse("5"), transfer.Fee)
+	fromAccount, _ = s.GetAccount(ctx, "alice")
+	assert.Equal(t, money.MustParse("0"), fromAccount.Balance)
+}
+
+func TestVoidPayment(t *testing.T) {
+	s := instantiateServiceForTests()
+	ctx := context.Background()
+
+	holdRecord, _ := s.AuthorizePayment(ctx, "alice", "bob", money.MustParse("70"), 0)
+	holdRecord, err := s.VoidPayment(ctx, holdRecord.Id)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, hold.VoidedStatus, holdRecord.Status)
+	fromAccount, _ := s.GetAccount(ctx, "alice")
+	assert.Equal(t, money.MustParse("100"), fromAccount.AvailableBalance)
+
+	_, _, err = s.CapturePayment(ctx, holdRecord.Id, nil)
+	assert.Equal(t, hold.NotActiveErr, err)
+}
+
+func TestCapturePayment_Expired(t *testing.T) {
+	s := instantiateServiceForTests()
+	ctx := context.Background()
+
+	holdRecord, _ := s.AuthorizePayment(ctx, "alice", "bob", money.MustParse("70"), 0)
+	// The in-memory repository keeps the created hold, so it can be expired in place.
+	holdRecord.ExpiresAt = time.Now().Add(-time.Second)
+
+	expiredHold, err := s.GetHold(ctx, holdRecord.Id)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, hold.ExpiredStatus, expiredHold.Status)
+	fromAccount, _ := s.GetAccount(ctx, "alice")
+	assert.Equal(t, money.MustParse("100"), fromAccount.AvailableBalance)
+	_, _, err = s.CapturePayment(ctx, holdRecord.Id, nil)
+	assert.Equal(t, hold.ExpiredErr, err)
+	_, err = s.VoidPayment(ctx, holdRecord.Id)
+	assert.Equal(t, hold.ExpiredErr, err)
+}
+
+func TestSchedulePayment(t *testing.T) {
+	s := instantiateServiceForTests()
+	ctx := context.Background()
+	worker := NewScheduledPaymentsWorker(s, s.scheduled, time.Second, 1, kitlog.NewNopLogger())
+
+	executeAt := time.Now().Add(time.Hour)
+	scheduledPayment, err := s.SchedulePayment(ctx, "alice", "bob", money.MustParse("30"), executeAt)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, schedule.PendingStatus, scheduledPayment.Status)
+	lowBalancePayment, _ := s.SchedulePayment(ctx, "alice", "mark", money.MustParse("90"), executeAt)
+	canceledPayment, _ := s.SchedulePayment(ctx, "alice", "john", money.MustParse("10"), executeAt)
+	_, err = s.SchedulePayment(ctx, "alice", "bob", money.MustParse("30"), time.Now().Add(-time.Hour))
+	_, ok := err.(*IncorrectInputData)
+	assert.Equal(t, true, ok, "IncorrectInputData type assertion")
+
+	// Payments aren't executed before their time.
+	executed, err := worker.ExecuteDue(ctx)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 0, executed)
+
+	_, err = s.CancelScheduledPayment(ctx, canceledPayment.Id)
+	assert.Equal(t, err, nil)
+	// The in-memory repository keeps the created payments, so they can be made due in place.
+	for _, p := range []*schedule.Payment{scheduledPayment, lowBalancePayment, canceledPayment} {
+		p.ExecuteAt = time.Now().Add(-time.Second)
+	}
+	executed, err = worker.ExecuteDue(ctx)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 2, executed)
+
+	scheduledPayment, _ = s.GetScheduledPayment(ctx, scheduledPayment.Id)
+	assert.Equal(t, schedule.ExecutedStatus, scheduledPayment.Status)
+	assert.NotEqual(t, "", scheduledPayment.TransferId)
+	lowBalancePayment, _ = s.GetScheduledPayment(ctx, lowBalancePayment.Id)
+	assert.Equal(t, schedule.FailedStatus, lowBalancePayment.Status)
+	assert.Equal(t, payment.LowBalanceErr.Error(), lowBalancePayment.FailureReason)
+	canceledPayment, _ = s.GetScheduledPayment(ctx, canceledPayment.Id)
+	assert.Equal(t, schedule.CanceledStatus, canceledPayment.Status)
+	fromAccount, _ := s.GetAccount(ctx, "alice")
+	assert.Equal(t, money.MustParse("70"), fromAccount.Balance)
+
+	// Executed payments aren't executed again.
+	executed, err = worker.ExecuteDue(ctx)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 0, executed)
+	_, err = s.CancelScheduledPayment(ctx, scheduledPayment.Id)
+	assert.Equal(t, schedule.NotPendingErr, err)
+
+	scheduledPayments, total, err := s.GetScheduledPayments(ctx, "mark", nil, nil)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, []*schedule.Payment{lowBalancePayment}, scheduledPayments)
+	assert.Equal(t, 1, total)
+}
+
+func TestScheduledPaymentsWorker_ClaimedAgain(t *testing.T) {
+	s := instantiateServiceForTests()
+	ctx := context.Background()
+	worker := NewScheduledPaymentsWorker(s, s.scheduled, time.Second, 10, kitlog.NewNopLogger())
+
+	scheduledPayment, _ := s.SchedulePayment(ctx, "alice", "bob", money.MustParse("30"), time.Now().Add(time.Hour))
+	scheduledPayment.ExecuteAt = time.Now().Add(-time.Second)
+	// Another worker has claimed and sent the payment, but crashed before recording the result.
+	claimed, _ := s.scheduled.ClaimDue(ctx, 10, scheduledPaymentClaimTimeout)
+	assert.Equal(t, 1, len(claimed))
+	_, err := s.SendPayment(ctx, "alice", "bob", money.MustParse("30"), scheduledPayment.IdempotencyKey())
+	assert.Equal(t, err, nil)
+
+	// The payment isn't claimed again until the claim times out.
+	executed, err := worker.ExecuteDue(ctx)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 0, executed)
+
+	worker.claimTimeout = 0
+	executed, err = worker.ExecuteDue(ctx)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 1, executed)
+	scheduledPayment, _ = s.GetScheduledPayment(ctx, scheduledPayment.Id)
+	assert.Equal(t, schedule.ExecutedStatus, scheduledPayment.Status)
+	fromAccount, _ := s.GetAccount(ctx, "alice")
+	assert.Equal(t, money.MustParse("70"), fromAccount.Balance)
+}
+
+// concurrentlyFinishedRepository finishes the first claimed payment as another worker would,
+// after the claim has timed out and the payment has been claimed again.
+type concurrentlyFinishedRepository struct {
+	schedule.Repository
+}
+
+func (r *concurrentlyFinishedRepository) ClaimDue(
+	ctx context.Context, limit int, claimTimeout time.Duration,
+) ([]*schedule.Payment, error) {
+	claimed, err := r.Repository.ClaimDue(ctx, limit, claimTimeout)
+	if err != nil || len(claimed) == 0 {
+		return claimed, err
+	}
+	finishedByOther := *claimed[0]
+	finishedByOther.Status = schedule.FailedStatus
+	return claimed, r.Repository.Finish(ctx, &finishedByOther)
+}
+
+func TestScheduledPaymentsWorker_FinishedByAnotherWorker(t *testing.T) {
+	s := instantiateServiceForTests()
+	ctx := context.Background()
+	worker := NewScheduledPaymentsWorker(
+		s, &concurrentlyFinishedRepository{s.scheduled}, time.Second, 10, kitlog.NewNopLogger(),
+	)
+
+	first, _ := s.SchedulePayment(ctx, "alice", "bob", money.MustParse("10"), time.Now().Add(time.Hour))
+	first.ExecuteAt = time.Now().Add(-2 * time.Second)
+	second, _ := s.SchedulePayment(ctx, "alice", "bob", money.MustParse("20"), time.Now().Add(time.Hour))
+	second.ExecuteAt = time.Now().Add(-time.Second)
+
+	// The first payment is left as the other worker has finished it, the rest of the batch is still executed.
+	executed, err := worker.ExecuteDue(ctx)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 1, executed)
+	first, _ = s.GetScheduledPayment(ctx, first.Id)
+	assert.Equal(t, schedule.FailedStatus, first.Status)
+	second, _ = s.GetScheduledPayment(ctx, second.Id)
+	assert.Equal(t, schedule.ExecutedStatus, second.Status)
+}
+
+func TestStandingOrder(t *testing.T) {
+	s := instantiateServiceForTests()
+	ctx := context.Background()
+	retryPolicy := RetryPolicy{MaxAttempts: 2, Interval: time.Hour}
+	worker := NewStandingOrdersWorker(s, s.standing, time.Second, 10, retryPolicy, kitlog.NewNopLogger())
+
+	count := 3
+	rule := standing.Rule{Frequency: standing.WeeklyFrequency, Count: &count}
+	order, err := s.CreateStandingOrder(ctx, "alice", "bob", money.MustParse("40"), rule)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, standing.ActiveStatus, order.Status)
+	assert.Equal(t, order.StartAt, order.NextRunAt)
+
+	_, err = s.CreateStandingOrder(ctx, "alice", "bob", money.MustParse("40"), standing.Rule{Frequency: "yearly"})
+	_, ok := err.(*IncorrectInputData)
+	assert.Equal(t, true, ok, "IncorrectInputData type assertion")
+
+	// The first occurrence is due right away.
+	processed, err := worker.ExecuteDue(ctx)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 1, processed)
+	order, _ = s.GetStandingOrder(ctx, order.Id)
+	assert.Equal(t, 1, order.Occurrences)
+	assert.Equal(t, order.StartAt.AddDate(0, 0, 7), order.NextRunAt)
+	assert.NotEqual(t, "", order.LastTransferId)
+
+	// The order falls behind its schedule as if the worker has been down for two weeks,
+	// so the missed occurrences are paid at once.
+	// The third one fails for a low balance and it's retried later.
+	behindOrder := *order
+	behindOrder.StartAt = time.Now().Add(-15 * 24 * time.Hour)
+	behindOrder.NextRunAt = behindOrder.OccurrenceTime(behindOrder.Occurrences)
+	s.standing.Update(ctx, &behindOrder)
+	processed, err = worker.ExecuteDue(ctx)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 2, processed)
+	order, _ = s.GetStandingOrder(ctx, order.Id)
+	assert.Equal(t, 2, order.Occurrences)
+	assert.Equal(t, 1, order.Attempts)
+	assert.Equal(t, payment.LowBalanceErr.Error(), order.LastFailureReason)
+	fromAccount, _ := s.GetAccount(ctx, "alice")
+	assert.Equal(t, money.MustParse("20"), fromAccount.Balance)
+
+	// The retry fails as well and the occurrence is skipped, which finishes the order.
+	order.NextRunAt = time.Now().Add(-time.Second)
+	s.standing.Update(ctx, order)
+	processed, err = worker.ExecuteDue(ctx)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 1, processed)
+	order, _ = s.GetStandingOrder(ctx, order.Id)
+	assert.Equal(t, standing.FinishedStatus, order.Status)
+	assert.Equal(t, 3, order.Occurrences)
+	assert.Equal(t, 1, order.FailedOccurrences)
+
+	_, err = s.CancelStandingOrder(ctx, order.Id)
+	assert.Equal(t, standing.NotActiveErr, err)
+	orders, total, err := s.GetStandingOrders(ctx, "bob", nil, nil)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, []*standing.Order{order}, orders)
+	assert.Equal(t, 1, total)
+}
+
+func TestStandingOrder_Canceled(t *testing.T) {
+	s := instantiateServiceForTests()
+	ctx := context.Background()
+	worker := NewStandingOrdersWorker(s, s.standing, time.Second, 10, RetryPolicy{MaxAttempts: 1}, kitlog.NewNopLogger())
+
+	order, _ := s.CreateStandingOrder(ctx, "alice", "bob", money.MustParse("40"), standing.Rule{
+		Frequency: standing.DailyFrequency,
+	})
+	order, err := s.CancelStandingOrder(ctx, order.Id)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, standing.CanceledStatus, order.Status)
+
+	processed, err := worker.ExecuteDue(ctx)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 0, processed)
+	fromAccount, _ := s.GetAccount(ctx, "alice")
+	assert.Equal(t, money.MustParse("100"), fromAccount.Balance)
+}
+
+func TestSendBatchPayment(t *testing.T) {
+	s := instantiateServiceForTests()
+	ctx := context.Background()
+
+	// Bob spends the money he receives earlier in the same batch.
+	transfers, err := s.SendBatchPayment(ctx, []*BatchPayment{
+		{FromAccountId: "alice", ToAccountId: "bob", Amount: money.MustParse("60")},
+		{FromAccountId: "bob", ToAccountId: "mark", Amount: money.MustParse("150")},
+		{FromAccountId: "alice", ToAccountId: "john", Amount: money.MustParse("40")},
+	})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 3, len(transfers))
+	for _, c := range []struct {
+		accountId       string
+		expectedBalance money.Amount
+	}{
+		{"alice", money.MustParse("0")},
+		{"bob", money.MustParse("10")},
+		{"mark", money.MustParse("250")},
+		{"john", money.MustParse("140")},
+	} {
+		accountRecord, _ := s.GetAccount(ctx, c.accountId)
+		assert.Equal(t, c.expectedBalance, accountRecord.Balance, c.accountId)
+	}
+	discrepancies, err := s.GetLedgerDiscrepancies(ctx)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 0, len(discrepancies))
+
+	_, err = s.SendBatchPayment(ctx, nil)
+	_, ok := err.(*IncorrectInputData)
+	assert.Equal(t, true, ok, "IncorrectInputData type assertion")
+}
+
+func TestSendBatchPayment_Rejected(t *testing.T) {
+	s := instantiateServiceForTests()
+	ctx := context.Background()
+
+	// All the invalid payments are reported at once.
+	_, err := s.SendBatchPayment(ctx, []*BatchPayment{
+		{FromAccountId: "alice", ToAccountId: "bob", Amount: money.MustParse("10")},
+		{FromAccountId: "alice", ToAccountId: "unknown", Amount: money.MustParse("10")},
+		{FromAccountId: "bob", ToAccountId: "bob", Amount: money.MustParse("10")},
+	})
+	batchErr, ok := err.(*BatchError)
+	assert.Equal(t, true, ok, "BatchError type assertion")
+	assert.Equal(t, 2, len(batchErr.Items))
+	assert.Equal(t, 1, batchErr.Items[0].Index)
+	assert.Equal(t, ToAccountNotFound, batchErr.Items[0].Err)
+	assert.Equal(t, 2, batchErr.Items[1].Index)
+
+	// The payments are valid one by one, but together they exceed the balance, so none of them is sent.
+	_, err = s.SendBatchPayment(ctx, []*BatchPayment{
+		{FromAccountId: "alice", ToAccountId: "bob", Amount: money.MustParse("60")},
+		{FromAccountId: "alice", ToAccountId: "mark", Amount: money.MustParse("60")},
+	})
+	assert.Equal(t, &BatchError{Items: []*payment.BatchItemError{{Index: 1, Err: payment.LowBalanceErr}}}, err)
+	fromAccount, _ := s.GetAccount(ctx, "alice")
+	assert.Equal(t, money.MustParse("100"), fromAccount.Balance)
+	paymentsList, _, _ := s.GetAllPayments(ctx, payment.Filter{}, payment.Sort{}, Pagination{})
+	assert.Equal(t, 0, len(paymentsList))
 }
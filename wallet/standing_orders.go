@@ -0,0 +1,99 @@
+package wallet
+
+import (
+	"context"
+	"github.com/georgysavva/generic-wallet/payment"
+	"github.com/georgysavva/generic-wallet/standing"
+	"github.com/go-kit/kit/log"
+	"time"
+)
+
+// standingOrderClaimTimeout must be long enough for a payment to be sent,
+// a claimed order is processed again after it passes.
+const standingOrderClaimTimeout = 5 * time.Minute
+
+// RetryPolicy defines how the payment of a standing order occurrence is retried
+// if the source account has insufficient balance.
+type RetryPolicy struct {
+	// MaxAttempts includes the first attempt, 1 means no retries.
+	MaxAttempts int
+	Interval    time.Duration
+}
+
+// StandingOrdersWorker sends the payments of due standing order occurrences through the service.
+// Several workers can run at once, e.g. in different service replicas,
+// each order is claimed by only one of them.
+type StandingOrdersWorker struct {
+	service      Service
+	orders       standing.Repository
+	interval     time.Duration
+	batchSize    int
+	retryPolicy  RetryPolicy
+	claimTimeout time.Duration
+	logger       log.Logger
+}
+
+func NewStandingOrdersWorker(
+	s Service, orders standing.Repository, interval time.Duration, batchSize int, retryPolicy RetryPolicy,
+	logger log.Logger,
+) *StandingOrdersWorker {
+	return &StandingOrdersWorker{
+		service:      s,
+		orders:       orders,
+		interval:     interval,
+		batchSize:    batchSize,
+		retryPolicy:  retryPolicy,
+		claimTimeout: standingOrderClaimTimeout,
+		logger:       logger,
+	}
+}
+
+// Run processes due orders every interval until the context is done.
+func (w *StandingOrdersWorker) Run(ctx context.Context) {
+	runPeriodically(ctx, w.interval, w.logger, "standing orders", w.ExecuteDue)
+}
+
+// ExecuteDue processes all due occurrences and returns the number of processed attempts.
+// An occurrence is paid with its own idempotency key, so it isn't paid twice if the order is claimed again.
+// Orders behind their schedule, e.g. after a downtime, get all the missed occurrences paid.
+func (w *StandingOrdersWorker) ExecuteDue(ctx context.Context) (int, error) {
+	var processed int
+	for {
+		claimed, err := w.orders.ClaimDue(ctx, w.batchSize, w.claimTimeout)
+		if err != nil {
+			return processed, err
+		}
+		for _, order := range claimed {
+			transfer, err := w.service.SendPayment(
+				ctx, order.FromAccountId, order.ToAccountId, order.Amount, order.IdempotencyKey(order.Occurrences),
+			)
+			if err != nil && !isPaymentRejection(err) {
+				// The order stays claimed and it's processed again after the claim timeout.
+				w.logger.Log("msg", "Can't send standing order payment", "standing_order", order.Id, "err", err)
+				continue
+			}
+			switch {
+			case err == nil:
+				order.LastTransferId, order.LastFailureReason = transfer.Id, ""
+				order.NextOccurrence()
+			case err == payment.LowBalanceErr && order.Attempts+1 < w.retryPolicy.MaxAttempts:
+				order.Attempts++
+				order.LastFailureReason = err.Error()
+				order.NextRunAt = time.Now().Add(w.retryPolicy.Interval)
+			default:
+				order.FailedOccurrences++
+				order.LastFailureReason = err.Error()
+				order.NextOccurrence()
+			}
+			err = w.orders.Update(ctx, order)
+			if err != nil {
+				return processed, err
+			}
+			processed++
+		}
+		// Orders behind their schedule are due again right after they are processed.
+		if len(claimed) == 0 {
+			return processed, nil
+		}
+	}
+}
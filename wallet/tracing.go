@@ -0,0 +1,106 @@
+package wallet
+
+import (
+	"context"
+	"fmt"
+	"github.com/georgysavva/generic-wallet/money"
+	"github.com/georgysavva/generic-wallet/payment"
+	"github.com/georgysavva/generic-wallet/tracing"
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+const (
+	requestIdHeader   = "X-Request-ID"
+	traceparentHeader = "traceparent"
+)
+
+// requestIdRegexp limits request ids accepted from clients, so they are safe to log and echo.
+var requestIdRegexp = regexp.MustCompile(`^[a-zA-Z0-9._:-]{1,128}$`)
+
+// makeTracingMiddleware accepts the request id and the trace of the client or generates new ones,
+// puts them into the request context and echoes them in the response headers.
+// The request is traced with a span named after its route that fails on server errors.
+func makeTracingMiddleware(tracer *tracing.Tracer) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestId := r.Header.Get(requestIdHeader)
+			if !requestIdRegexp.MatchString(requestId) {
+				requestId = tracing.GenerateRequestId()
+			}
+			var remoteParent *tracing.SpanContext
+			if parent, ok := tracing.ParseTraceparent(r.Header.Get(traceparentHeader)); ok {
+				remoteParent = &parent
+			}
+			name := r.Method
+			if route := mux.CurrentRoute(r); route != nil {
+				if template, err := route.GetPathTemplate(); err == nil {
+					name += " " + template
+				}
+			}
+			ctx, span := tracer.StartServerSpan(tracing.ContextWithRequestId(r.Context(), requestId), name, remoteParent)
+			span.SetAttribute("request_id", requestId)
+			w.Header().Set(requestIdHeader, requestId)
+			w.Header().Set(traceparentHeader, span.Traceparent())
+
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r.WithContext(ctx))
+			span.SetAttribute("http.status_code", strconv.Itoa(sw.status))
+			var spanErr error
+			if sw.status >= http.StatusInternalServerError {
+				spanErr = fmt.Errorf("request failed with status %d", sw.status)
+			}
+			span.Finish(spanErr)
+		})
+	}
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// contextLogger returns the logger with the request id and the trace id of the context,
+// so log entries of a request can be correlated with each other and with its spans.
+func contextLogger(ctx context.Context, logger log.Logger) log.Logger {
+	if requestId := tracing.RequestIdFromContext(ctx); requestId != "" {
+		logger = log.With(logger, "request_id", requestId)
+	}
+	if span := tracing.SpanFromContext(ctx); span != nil {
+		logger = log.With(logger, "trace_id", span.TraceId)
+	}
+	return logger
+}
+
+type tracingService struct {
+	Service
+}
+
+// NewTracingService returns an instance of a Service tracing payments with spans,
+// calls made outside of traced requests aren't traced.
+func NewTracingService(s Service) Service {
+	return &tracingService{s}
+}
+
+func (s *tracingService) SendPayment(
+	ctx context.Context, fromAccountId, toAccountId string, amount money.Amount, idempotencyKey string,
+) (*payment.Transfer, error) {
+	ctx, span := tracing.StartSpan(ctx, "wallet.send_payment")
+	span.SetAttribute("from_account", fromAccountId)
+	span.SetAttribute("to_account", toAccountId)
+	span.SetAttribute("amount", amount.String())
+	transfer, err := s.Service.SendPayment(ctx, fromAccountId, toAccountId, amount, idempotencyKey)
+	if err == nil {
+		span.SetAttribute("transfer_id", transfer.Id)
+	}
+	span.Finish(err)
+	return transfer, err
+}
@@ -0,0 +1,72 @@
+package wallet
+
+import (
+	"encoding/json"
+	"github.com/georgysavva/generic-wallet/tracing"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMakeHandler_Tracing(t *testing.T) {
+	handler := MakeHandler(
+		instantiateServiceForTests(), nil, RateLimits{}, tracing.NewTracer(nil), kitlog.NewNopLogger(),
+	)
+
+	r := httptest.NewRequest("GET", "/wallet/v1/accounts/alice", nil)
+	r.Header.Set(requestIdHeader, "client-request-1")
+	r.Header.Set(traceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "client-request-1", w.Header().Get(requestIdHeader))
+	traceparent, ok := tracing.ParseTraceparent(w.Header().Get(traceparentHeader))
+	assert.True(t, ok)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", traceparent.TraceId)
+	assert.NotEqual(t, "00f067aa0ba902b7", traceparent.SpanId)
+
+	r = httptest.NewRequest("GET", "/wallet/v1/accounts/unknown", nil)
+	r.Header.Set(requestIdHeader, "invalid request id")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	requestId := w.Header().Get(requestIdHeader)
+	assert.Equal(t, 32, len(requestId))
+	assert.True(t, strings.HasPrefix(w.Header().Get(traceparentHeader), "00-"))
+	var body struct {
+		Error struct {
+			RequestId string `json:"request_id"`
+		} `json:"error"`
+	}
+	json.NewDecoder(w.Body).Decode(&body)
+	assert.Equal(t, requestId, body.Error.RequestId)
+}
+
+// spansForTests records the exported spans.
+type spansForTests struct {
+	spans []*tracing.Span
+}
+
+func (e *spansForTests) Export(span *tracing.Span) {
+	e.spans = append(e.spans, span)
+}
+
+func TestTracingMiddleware_FailedRequests(t *testing.T) {
+	exporter := &spansForTests{}
+	middleware := makeTracingMiddleware(tracing.NewTracer(exporter))
+	for _, status := range []int{http.StatusNotFound, http.StatusInternalServerError} {
+		handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/wallet/v1/accounts", nil))
+	}
+
+	assert.Equal(t, 2, len(exporter.spans))
+	// Client errors are expected outcomes, only server errors fail the span.
+	assert.Equal(t, "", exporter.spans[0].Error)
+	assert.Equal(t, "500", exporter.spans[1].Attributes["http.status_code"])
+	assert.Equal(t, "request failed with status 500", exporter.spans[1].Error)
+}
@@ -3,54 +3,279 @@ package wallet
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"github.com/georgysavva/generic-wallet/account"
+	"github.com/georgysavva/generic-wallet/hold"
+	"github.com/georgysavva/generic-wallet/idempotency"
+	"github.com/georgysavva/generic-wallet/money"
 	"github.com/georgysavva/generic-wallet/payment"
+	"github.com/georgysavva/generic-wallet/schedule"
+	"github.com/georgysavva/generic-wallet/standing"
+	"github.com/georgysavva/generic-wallet/tracing"
+	"github.com/go-kit/kit/endpoint"
 	kitlog "github.com/go-kit/kit/log"
 	kithttp "github.com/go-kit/kit/transport/http"
 	"github.com/gorilla/mux"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
 	// API error codes.
-	lowBalanceErrCode          = "LOW_BALANCE"
-	fromAccountNotFoundErrCode = "FROM_ACCOUNT_NOT_FOUND"
-	toAccountNotFoundErrCode   = "TO_ACCOUNT_NOT_FOUND"
-	differentCurrenciesErrCode = "DIFFERENT_CURRENCIES"
-	incorrectRequestErrCode    = "INCORRECT_REQUEST"
-	internalErrorErrCode       = "INTERNAL_ERROR"
+	lowBalanceErrCode                 = "LOW_BALANCE"
+	accountNotFoundErrCode            = "ACCOUNT_NOT_FOUND"
+	paymentNotFoundErrCode            = "PAYMENT_NOT_FOUND"
+	fromAccountNotFoundErrCode        = "FROM_ACCOUNT_NOT_FOUND"
+	toAccountNotFoundErrCode          = "TO_ACCOUNT_NOT_FOUND"
+	accountAlreadyExistsErrCode       = "ACCOUNT_ALREADY_EXISTS"
+	nonZeroBalanceErrCode             = "NON_ZERO_BALANCE"
+	fromAccountClosedErrCode          = "FROM_ACCOUNT_CLOSED"
+	toAccountClosedErrCode            = "TO_ACCOUNT_CLOSED"
+	fromAccountFrozenErrCode          = "FROM_ACCOUNT_FROZEN"
+	fromAccountSuspendedErrCode       = "FROM_ACCOUNT_SUSPENDED"
+	toAccountSuspendedErrCode         = "TO_ACCOUNT_SUSPENDED"
+	accountClosedErrCode              = "ACCOUNT_CLOSED"
+	limitExceededErrCode              = "LIMIT_EXCEEDED"
+	differentCurrenciesErrCode        = "DIFFERENT_CURRENCIES"
+	exchangeRateNotFoundErrCode       = "EXCHANGE_RATE_NOT_FOUND"
+	exchangeRateUnavailableErrCode    = "EXCHANGE_RATE_UNAVAILABLE"
+	idempotencyKeyReusedErrCode       = "IDEMPOTENCY_KEY_REUSED"
+	refundExceedsAmountErrCode        = "REFUND_EXCEEDS_AMOUNT"
+	holdNotFoundErrCode               = "HOLD_NOT_FOUND"
+	holdNotActiveErrCode              = "HOLD_NOT_ACTIVE"
+	holdExpiredErrCode                = "HOLD_EXPIRED"
+	captureExceedsHoldErrCode         = "CAPTURE_EXCEEDS_HOLD"
+	scheduledPaymentNotFoundErrCode   = "SCHEDULED_PAYMENT_NOT_FOUND"
+	scheduledPaymentNotPendingErrCode = "SCHEDULED_PAYMENT_NOT_PENDING"
+	standingOrderNotFoundErrCode      = "STANDING_ORDER_NOT_FOUND"
+	standingOrderNotActiveErrCode     = "STANDING_ORDER_NOT_ACTIVE"
+	batchPaymentRejectedErrCode       = "BATCH_PAYMENT_REJECTED"
+	incorrectRequestErrCode           = "INCORRECT_REQUEST"
+	unauthenticatedErrCode            = "UNAUTHENTICATED"
+	accountNotOwnedErrCode            = "ACCOUNT_NOT_OWNED"
+	forbiddenErrCode                  = "FORBIDDEN"
+	rateLimitedErrCode                = "RATE_LIMITED"
+	internalErrorErrCode              = "INTERNAL_ERROR"
+
+	idempotencyKeyHeader    = "Idempotency-Key"
+	maxIdempotencyKeyLength = 255
 )
 
-func MakeHandler(s Service, logger kitlog.Logger) http.Handler {
+// MakeHandler returns the handler of the API, all requests are authenticated with the authenticator.
+// Nil authenticator disables authentication.
+// Requests getting data are limited by the reads limiter of rateLimits, all other requests by the writes one,
+// requests failing authentication are limited by the auth failures limiter per IP address.
+// Every request gets a request id and a span of the tracer.
+func MakeHandler(
+	s Service, authenticator *Authenticator, rateLimits RateLimits, tracer *tracing.Tracer, logger kitlog.Logger,
+) http.Handler {
 	opts := []kithttp.ServerOption{
-		kithttp.ServerErrorLogger(logger),
-		kithttp.ServerErrorEncoder(encodeError),
+		kithttp.ServerBefore(credentialsToContext, clientAddressToContext),
+		kithttp.ServerErrorEncoder(func(ctx context.Context, err error, w http.ResponseWriter) {
+			contextLogger(ctx, logger).Log("err", err)
+			encodeError(ctx, err, w)
+		}),
 	}
+	authenticate := endpoint.Chain(
+		makeAuthFailuresLimitingMiddleware(rateLimits.AuthFailures), makeAuthenticationMiddleware(authenticator),
+	)
+	read := endpoint.Chain(authenticate, makeRateLimitingMiddleware(rateLimits.Reads))
+	write := endpoint.Chain(authenticate, makeRateLimitingMiddleware(rateLimits.Writes))
 	sendPaymentHandler := kithttp.NewServer(
-		makeSendPaymentEndpoint(s),
+		write(makeSendPaymentEndpoint(s)),
 		decodeSendPaymentRequest,
 		encodeResponse,
 		opts...,
 	)
+	sendBatchPaymentHandler := kithttp.NewServer(
+		write(makeSendBatchPaymentEndpoint(s)),
+		decodeSendBatchPaymentRequest,
+		encodeResponse,
+		opts...,
+	)
 	getAllPaymentsHandler := kithttp.NewServer(
-		makeGetAllPaymentsEndpoint(s),
+		read(makeGetAllPaymentsEndpoint(s)),
 		decodeGetAllPaymentsRequest,
 		encodeResponse,
 		opts...,
 	)
+	getPaymentHandler := kithttp.NewServer(
+		read(makeGetPaymentEndpoint(s)),
+		decodeGetPaymentRequest,
+		encodeResponse,
+		opts...,
+	)
+	refundPaymentHandler := kithttp.NewServer(
+		write(makeRefundPaymentEndpoint(s)),
+		decodeRefundPaymentRequest,
+		encodeResponse,
+		opts...,
+	)
+	authorizePaymentHandler := kithttp.NewServer(
+		write(makeAuthorizePaymentEndpoint(s)),
+		decodeAuthorizePaymentRequest,
+		encodeResponse,
+		opts...,
+	)
+	getHoldHandler := kithttp.NewServer(
+		read(makeGetHoldEndpoint(s)),
+		decodeHoldRequest,
+		encodeResponse,
+		opts...,
+	)
+	capturePaymentHandler := kithttp.NewServer(
+		write(makeCapturePaymentEndpoint(s)),
+		decodeCapturePaymentRequest,
+		encodeResponse,
+		opts...,
+	)
+	voidPaymentHandler := kithttp.NewServer(
+		write(makeVoidPaymentEndpoint(s)),
+		decodeHoldRequest,
+		encodeResponse,
+		opts...,
+	)
+	schedulePaymentHandler := kithttp.NewServer(
+		write(makeSchedulePaymentEndpoint(s)),
+		decodeSchedulePaymentRequest,
+		encodeResponse,
+		opts...,
+	)
+	getScheduledPaymentsHandler := kithttp.NewServer(
+		read(makeGetScheduledPaymentsEndpoint(s)),
+		decodeGetScheduledPaymentsRequest,
+		encodeResponse,
+		opts...,
+	)
+	getScheduledPaymentHandler := kithttp.NewServer(
+		read(makeGetScheduledPaymentEndpoint(s)),
+		decodeScheduledPaymentRequest,
+		encodeResponse,
+		opts...,
+	)
+	cancelScheduledPaymentHandler := kithttp.NewServer(
+		write(makeCancelScheduledPaymentEndpoint(s)),
+		decodeScheduledPaymentRequest,
+		encodeResponse,
+		opts...,
+	)
+	createStandingOrderHandler := kithttp.NewServer(
+		write(makeCreateStandingOrderEndpoint(s)),
+		decodeCreateStandingOrderRequest,
+		encodeResponse,
+		opts...,
+	)
+	getStandingOrdersHandler := kithttp.NewServer(
+		read(makeGetStandingOrdersEndpoint(s)),
+		decodeGetStandingOrdersRequest,
+		encodeResponse,
+		opts...,
+	)
+	getStandingOrderHandler := kithttp.NewServer(
+		read(makeGetStandingOrderEndpoint(s)),
+		decodeStandingOrderRequest,
+		encodeResponse,
+		opts...,
+	)
+	cancelStandingOrderHandler := kithttp.NewServer(
+		write(makeCancelStandingOrderEndpoint(s)),
+		decodeStandingOrderRequest,
+		encodeResponse,
+		opts...,
+	)
 	getAllAccountsHandler := kithttp.NewServer(
-		makeGetAllAccountsEndpoint(s),
+		read(makeGetAllAccountsEndpoint(s)),
 		decodeGetAllAccountsRequest,
 		encodeResponse,
 		opts...,
 	)
+	getAccountPaymentsHandler := kithttp.NewServer(
+		read(makeGetAccountPaymentsEndpoint(s)),
+		decodeGetAccountPaymentsRequest,
+		encodeResponse,
+		opts...,
+	)
+	createAccountHandler := kithttp.NewServer(
+		write(makeCreateAccountEndpoint(s)),
+		decodeCreateAccountRequest,
+		encodeResponse,
+		opts...,
+	)
+	getAccountHandler := kithttp.NewServer(
+		read(makeGetAccountEndpoint(s)),
+		decodeAccountRequest,
+		encodeResponse,
+		opts...,
+	)
+	closeAccountHandler := kithttp.NewServer(
+		write(makeCloseAccountEndpoint(s)),
+		decodeAccountRequest,
+		encodeResponse,
+		opts...,
+	)
+	setAccountStatusHandler := kithttp.NewServer(
+		write(makeSetAccountStatusEndpoint(s)),
+		decodeSetAccountStatusRequest,
+		encodeResponse,
+		opts...,
+	)
+	setOverdraftLimitHandler := kithttp.NewServer(
+		write(makeSetOverdraftLimitEndpoint(s)),
+		decodeSetOverdraftLimitRequest,
+		encodeResponse,
+		opts...,
+	)
+	setSpendingLimitsHandler := kithttp.NewServer(
+		write(makeSetSpendingLimitsEndpoint(s)),
+		decodeSetSpendingLimitsRequest,
+		encodeResponse,
+		opts...,
+	)
+	setAccountTierHandler := kithttp.NewServer(
+		write(makeSetAccountTierEndpoint(s)),
+		decodeSetAccountTierRequest,
+		encodeResponse,
+		opts...,
+	)
+	getLedgerDiscrepanciesHandler := kithttp.NewServer(
+		read(makeGetLedgerDiscrepanciesEndpoint(s)),
+		kithttp.NopRequestDecoder,
+		encodeResponse,
+		opts...,
+	)
 
 	r := mux.NewRouter()
+	r.Use(makeTracingMiddleware(tracer))
 
 	r.Handle("/wallet/v1/payments", sendPaymentHandler).Methods("POST")
 	r.Handle("/wallet/v1/payments", getAllPaymentsHandler).Methods("GET")
+	r.Handle("/wallet/v1/payments/batch", sendBatchPaymentHandler).Methods("POST")
+	r.Handle("/wallet/v1/payments/{id}", getPaymentHandler).Methods("GET")
+	r.Handle("/wallet/v1/payments/{id}/refund", refundPaymentHandler).Methods("POST")
+	r.Handle("/wallet/v1/holds", authorizePaymentHandler).Methods("POST")
+	r.Handle("/wallet/v1/holds/{id}", getHoldHandler).Methods("GET")
+	r.Handle("/wallet/v1/holds/{id}/capture", capturePaymentHandler).Methods("POST")
+	r.Handle("/wallet/v1/holds/{id}/void", voidPaymentHandler).Methods("POST")
+	r.Handle("/wallet/v1/scheduled_payments", schedulePaymentHandler).Methods("POST")
+	r.Handle("/wallet/v1/scheduled_payments", getScheduledPaymentsHandler).Methods("GET")
+	r.Handle("/wallet/v1/scheduled_payments/{id}", getScheduledPaymentHandler).Methods("GET")
+	r.Handle("/wallet/v1/scheduled_payments/{id}/cancel", cancelScheduledPaymentHandler).Methods("POST")
+	r.Handle("/wallet/v1/standing_orders", createStandingOrderHandler).Methods("POST")
+	r.Handle("/wallet/v1/standing_orders", getStandingOrdersHandler).Methods("GET")
+	r.Handle("/wallet/v1/standing_orders/{id}", getStandingOrderHandler).Methods("GET")
+	r.Handle("/wallet/v1/standing_orders/{id}/cancel", cancelStandingOrderHandler).Methods("POST")
 	r.Handle("/wallet/v1/accounts", getAllAccountsHandler).Methods("GET")
+	r.Handle("/wallet/v1/accounts", createAccountHandler).Methods("POST")
+	r.Handle("/wallet/v1/accounts/{id}", getAccountHandler).Methods("GET")
+	r.Handle("/wallet/v1/accounts/{id}/close", closeAccountHandler).Methods("POST")
+	r.Handle("/wallet/v1/accounts/{id}/payments", getAccountPaymentsHandler).Methods("GET")
+	r.Handle("/wallet/v1/ledger/discrepancies", getLedgerDiscrepanciesHandler).Methods("GET")
+	r.Handle("/wallet/v1/admin/accounts/{id}/status", setAccountStatusHandler).Methods("POST")
+	r.Handle("/wallet/v1/admin/accounts/{id}/overdraft_limit", setOverdraftLimitHandler).Methods("POST")
+	r.Handle("/wallet/v1/admin/accounts/{id}/spending_limits", setSpendingLimitsHandler).Methods("POST")
+	r.Handle("/wallet/v1/admin/accounts/{id}/tier", setAccountTierHandler).Methods("POST")
 
 	return r
 }
@@ -70,12 +295,190 @@ func decodeSendPaymentRequest(_ context.Context, r *http.Request) (interface{},
 		return nil, &decodingError{"'from_account' and 'to_account' are required"}
 	}
 
-	amount, err := strconv.ParseFloat(r.PostFormValue("amount"), 64)
+	amount, err := money.Parse(r.PostFormValue("amount"))
+	if err != nil {
+		return nil, &decodingError{"'amount' is required and must have a decimal format"}
+	}
+
+	idempotencyKey := r.Header.Get(idempotencyKeyHeader)
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		return nil, &decodingError{
+			fmt.Sprintf("'%s' header must be at most %d characters long", idempotencyKeyHeader, maxIdempotencyKeyLength),
+		}
+	}
+	if strings.HasPrefix(idempotencyKey, idempotency.InternalKeyPrefix) {
+		return nil, &decodingError{
+			fmt.Sprintf("'%s' header must not start with '%s'", idempotencyKeyHeader, idempotency.InternalKeyPrefix),
+		}
+	}
+
+	return &sendPaymentRequest{
+		FromAccountId: fromAccountId, ToAccountId: toAccountId, Amount: amount, IdempotencyKey: idempotencyKey,
+	}, nil
+}
+
+// decodeSendBatchPaymentRequest expects a JSON body, e.g.
+// {"payments": [{"from_account": "alice", "to_account": "bob", "amount": 10.5}]}.
+func decodeSendBatchPaymentRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	req := &sendBatchPaymentRequest{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		return nil, &decodingError{"request body must be a JSON object with 'payments' list: " + err.Error()}
+	}
+	for i, p := range req.Payments {
+		if p == nil || p.FromAccountId == "" || p.ToAccountId == "" {
+			return nil, &decodingError{fmt.Sprintf("'from_account' and 'to_account' of payment %d are required", i)}
+		}
+	}
+	return req, nil
+}
+
+func decodeAuthorizePaymentRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	fromAccountId := r.PostFormValue("from_account")
+	toAccountId := r.PostFormValue("to_account")
+	if fromAccountId == "" || toAccountId == "" {
+		return nil, &decodingError{"'from_account' and 'to_account' are required"}
+	}
+	amount, err := money.Parse(r.PostFormValue("amount"))
+	if err != nil {
+		return nil, &decodingError{"'amount' is required and must have a decimal format"}
+	}
+	var expiresIn time.Duration
+	if expiresInText := r.PostFormValue("expires_in"); expiresInText != "" {
+		seconds, err := strconv.Atoi(expiresInText)
+		if err != nil {
+			return nil, &decodingError{"'expires_in' must be an int number of seconds"}
+		}
+		expiresIn = time.Duration(seconds) * time.Second
+	}
+	return &authorizePaymentRequest{
+		FromAccountId: fromAccountId, ToAccountId: toAccountId, Amount: amount, ExpiresIn: expiresIn,
+	}, nil
+}
+
+func decodeHoldRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return &holdRequest{HoldId: mux.Vars(r)["id"]}, nil
+}
+
+func decodeCapturePaymentRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	amount, err := decodeOptionalPostAmount(r)
+	if err != nil {
+		return nil, err
+	}
+	return &capturePaymentRequest{HoldId: mux.Vars(r)["id"], Amount: amount}, nil
+}
+
+func decodeSchedulePaymentRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	fromAccountId := r.PostFormValue("from_account")
+	toAccountId := r.PostFormValue("to_account")
+	if fromAccountId == "" || toAccountId == "" {
+		return nil, &decodingError{"'from_account' and 'to_account' are required"}
+	}
+	amount, err := money.Parse(r.PostFormValue("amount"))
+	if err != nil {
+		return nil, &decodingError{"'amount' is required and must have a decimal format"}
+	}
+	executeAt, err := time.Parse(time.RFC3339, r.PostFormValue("execute_at"))
+	if err != nil {
+		return nil, &decodingError{"'execute_at' is required and must have RFC 3339 format"}
+	}
+	return &schedulePaymentRequest{
+		FromAccountId: fromAccountId, ToAccountId: toAccountId, Amount: amount, ExecuteAt: executeAt,
+	}, nil
+}
+
+func decodeScheduledPaymentRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return &scheduledPaymentRequest{PaymentId: mux.Vars(r)["id"]}, nil
+}
+
+func decodeGetScheduledPaymentsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	decoded, err := decodePaginationRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	return &getScheduledPaymentsRequest{paginationRequest: decoded, AccountId: r.FormValue("account")}, nil
+}
+
+func decodeCreateStandingOrderRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	fromAccountId := r.PostFormValue("from_account")
+	toAccountId := r.PostFormValue("to_account")
+	if fromAccountId == "" || toAccountId == "" {
+		return nil, &decodingError{"'from_account' and 'to_account' are required"}
+	}
+	amount, err := money.Parse(r.PostFormValue("amount"))
+	if err != nil {
+		return nil, &decodingError{"'amount' is required and must have a decimal format"}
+	}
+	rule := standing.Rule{Frequency: r.PostFormValue("frequency")}
+	if rule.Frequency == "" {
+		return nil, &decodingError{"'frequency' is required"}
+	}
+	startAt, err := decodeOptionalTime(r, "start_at")
+	if err != nil {
+		return nil, err
+	}
+	if startAt != nil {
+		rule.StartAt = *startAt
+	}
+	rule.EndAt, err = decodeOptionalTime(r, "end_at")
+	if err != nil {
+		return nil, err
+	}
+	if countText := r.PostFormValue("count"); countText != "" {
+		count, err := strconv.Atoi(countText)
+		if err != nil {
+			return nil, &decodingError{"'count' must be an int"}
+		}
+		rule.Count = &count
+	}
+	return &createStandingOrderRequest{
+		FromAccountId: fromAccountId, ToAccountId: toAccountId, Amount: amount, Rule: rule,
+	}, nil
+}
+
+func decodeStandingOrderRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return &standingOrderRequest{OrderId: mux.Vars(r)["id"]}, nil
+}
+
+func decodeGetStandingOrdersRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	decoded, err := decodePaginationRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	return &getStandingOrdersRequest{paginationRequest: decoded, AccountId: r.FormValue("account")}, nil
+}
+
+// decodeOptionalPostAmount returns nil if the amount isn't specified in the request body.
+func decodeOptionalPostAmount(r *http.Request) (*money.Amount, error) {
+	amountText := r.PostFormValue("amount")
+	if amountText == "" {
+		return nil, nil
+	}
+	amount, err := money.Parse(amountText)
+	if err != nil {
+		return nil, &decodingError{"'amount' must have a decimal format"}
+	}
+	return &amount, nil
+}
+
+func decodeGetPaymentRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	paymentId, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
 	if err != nil {
-		return nil, &decodingError{"'amount' is required and must have a float format"}
+		return nil, &decodingError{"payment id must be an int"}
 	}
+	return &getPaymentRequest{PaymentId: paymentId}, nil
+}
 
-	return &sendPaymentRequest{FromAccountId: fromAccountId, ToAccountId: toAccountId, Amount: amount}, nil
+func decodeRefundPaymentRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	paymentId, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return nil, &decodingError{"payment id must be an int"}
+	}
+	amount, err := decodeOptionalPostAmount(r)
+	if err != nil {
+		return nil, err
+	}
+	return &refundPaymentRequest{PaymentId: paymentId, Amount: amount}, nil
 }
 
 func decodePaginationRequest(r *http.Request) (*paginationRequest, error) {
@@ -102,27 +505,161 @@ func decodePaginationRequest(r *http.Request) (*paginationRequest, error) {
 	return decodedReq, nil
 }
 
+func decodeCursorPaginationRequest(r *http.Request) (*paginationRequest, error) {
+	decodedReq, err := decodePaginationRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	decodedReq.Cursor = r.FormValue("cursor")
+	return decodedReq, nil
+}
+
 func decodeGetAllPaymentsRequest(_ context.Context, r *http.Request) (interface{}, error) {
-	decoded, err := decodePaginationRequest(r)
+	decoded, err := decodeCursorPaginationRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	filter := payment.Filter{
+		AccountId:      r.FormValue("account"),
+		CounterpartyId: r.FormValue("counterparty"),
+		Direction:      r.FormValue("direction"),
+	}
+	filter.MinAmount, err = decodeOptionalAmount(r, "min_amount")
 	if err != nil {
 		return nil, err
 	}
-	return &getAllPaymentsRequest{paginationRequest: decoded}, nil
+	filter.MaxAmount, err = decodeOptionalAmount(r, "max_amount")
+	if err != nil {
+		return nil, err
+	}
+	filter.CreatedFrom, err = decodeOptionalTime(r, "created_from")
+	if err != nil {
+		return nil, err
+	}
+	filter.CreatedTo, err = decodeOptionalTime(r, "created_to")
+	if err != nil {
+		return nil, err
+	}
+	sort := payment.Sort{By: r.FormValue("sort")}
+	switch r.FormValue("order") {
+	case "", "asc":
+	case "desc":
+		sort.Descending = true
+	default:
+		return nil, &decodingError{"'order' must be either asc or desc"}
+	}
+	return &getAllPaymentsRequest{paginationRequest: decoded, Filter: filter, Sort: sort}, nil
+}
+
+func decodeOptionalAmount(r *http.Request, name string) (*money.Amount, error) {
+	text := r.FormValue(name)
+	if text == "" {
+		return nil, nil
+	}
+	amount, err := money.Parse(text)
+	if err != nil {
+		return nil, &decodingError{fmt.Sprintf("'%s' must have a decimal format", name)}
+	}
+	return &amount, nil
+}
+
+func decodeOptionalTime(r *http.Request, name string) (*time.Time, error) {
+	text := r.FormValue(name)
+	if text == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, text)
+	if err != nil {
+		return nil, &decodingError{fmt.Sprintf("'%s' must have RFC 3339 format", name)}
+	}
+	return &t, nil
 }
 
 func decodeGetAllAccountsRequest(_ context.Context, r *http.Request) (interface{}, error) {
-	decoded, err := decodePaginationRequest(r)
+	decoded, err := decodeCursorPaginationRequest(r)
 	if err != nil {
 		return nil, err
 	}
 	return &getAllAccountsRequest{paginationRequest: decoded}, nil
 }
 
+func decodeGetAccountPaymentsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	decoded, err := decodePaginationRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	return &getAccountPaymentsRequest{paginationRequest: decoded, AccountId: mux.Vars(r)["id"]}, nil
+}
+
+func decodeCreateAccountRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	accountId := r.PostFormValue("id")
+	currency := r.PostFormValue("currency")
+	if accountId == "" || currency == "" {
+		return nil, &decodingError{"'id' and 'currency' are required"}
+	}
+	return &createAccountRequest{AccountId: accountId, Currency: currency}, nil
+}
+
+func decodeAccountRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return &accountRequest{AccountId: mux.Vars(r)["id"]}, nil
+}
+
+func decodeSetAccountStatusRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	status := r.PostFormValue("status")
+	reason := r.PostFormValue("reason")
+	if status == "" || reason == "" {
+		return nil, &decodingError{"'status' and 'reason' are required"}
+	}
+	return &setAccountStatusRequest{AccountId: mux.Vars(r)["id"], Status: status, Reason: reason}, nil
+}
+
+func decodeSetOverdraftLimitRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	limit, err := money.Parse(r.PostFormValue("limit"))
+	if err != nil {
+		return nil, &decodingError{"'limit' is required and must have a decimal format"}
+	}
+	return &setOverdraftLimitRequest{AccountId: mux.Vars(r)["id"], Limit: limit}, nil
+}
+
+// decodeSetSpendingLimitsRequest leaves the limits missing in the request unset.
+func decodeSetSpendingLimitsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	req := &setSpendingLimitsRequest{AccountId: mux.Vars(r)["id"]}
+	fields := []struct {
+		name  string
+		limit **money.Amount
+	}{
+		{payment.PerTransactionLimit, &req.Limits.PerTransaction},
+		{payment.DailyLimit, &req.Limits.Daily},
+		{payment.MonthlyLimit, &req.Limits.Monthly},
+	}
+	for _, field := range fields {
+		value := r.PostFormValue(field.name)
+		if value == "" {
+			continue
+		}
+		limit, err := money.Parse(value)
+		if err != nil {
+			return nil, &decodingError{fmt.Sprintf("'%s' must have a decimal format", field.name)}
+		}
+		*field.limit = &limit
+	}
+	return req, nil
+}
+
+func decodeSetAccountTierRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	tier := r.PostFormValue("tier")
+	if tier == "" {
+		return nil, &decodingError{"'tier' is required"}
+	}
+	return &setAccountTierRequest{AccountId: mux.Vars(r)["id"], Tier: tier}, nil
+}
+
 func encodeResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	var httpStatusCode int
 	switch response.(type) {
-	case *sendPaymentResponse:
+	case *sendPaymentResponse, *sendBatchPaymentResponse, *refundPaymentResponse, *authorizePaymentResponse, *capturePaymentResponse,
+		*schedulePaymentResponse, *createStandingOrderResponse, *createAccountResponse:
 		httpStatusCode = http.StatusCreated
 	default:
 		httpStatusCode = http.StatusOK
@@ -131,8 +668,51 @@ func encodeResponse(_ context.Context, w http.ResponseWriter, response interface
 	return json.NewEncoder(w).Encode(response)
 }
 
-func encodeError(_ context.Context, err error, w http.ResponseWriter) {
+func encodeError(ctx context.Context, err error, w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if rateLimitedErr, ok := err.(*RateLimitedError); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(rateLimitedErr.RetryAfter)))
+	}
+	errorCode, httpStatusCode := errorCodeAndStatus(err)
+	w.WriteHeader(httpStatusCode)
+	errorBody := map[string]interface{}{
+		"code":    errorCode,
+		"message": errorMessage(err),
+	}
+	if requestId := tracing.RequestIdFromContext(ctx); requestId != "" {
+		errorBody["request_id"] = requestId
+	}
+	if batchErr, ok := err.(*BatchError); ok {
+		var items []map[string]interface{}
+		for _, itemErr := range batchErr.Items {
+			itemCode, _ := errorCodeAndStatus(itemErr.Err)
+			items = append(items, map[string]interface{}{
+				"index":   itemErr.Index,
+				"code":    itemCode,
+				"message": errorMessage(itemErr.Err),
+			})
+		}
+		errorBody["items"] = items
+	}
+	if limitErr, ok := err.(*payment.LimitExceededError); ok {
+		errorBody["limit"] = limitErr.Limit
+		errorBody["remaining"] = limitErr.Remaining
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": errorBody,
+	})
+}
+
+// retryAfterSeconds rounds the duration up to whole seconds, since Retry-After can't be fractional.
+func retryAfterSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+func errorMessage(err error) string {
+	return strings.ToUpper(err.Error()[:1]) + err.Error()[1:] + "."
+}
+
+func errorCodeAndStatus(err error) (string, int) {
 	var errorCode string
 	var httpStatusCode int
 	switch err.(type) {
@@ -142,25 +722,74 @@ func encodeError(_ context.Context, err error, w http.ResponseWriter) {
 		errorCode, httpStatusCode = incorrectRequestErrCode, http.StatusBadRequest
 	case *DifferentCurrenciesError:
 		errorCode, httpStatusCode = differentCurrenciesErrCode, http.StatusConflict
+	case *ExchangeRateNotFoundError:
+		errorCode, httpStatusCode = exchangeRateNotFoundErrCode, http.StatusConflict
+	case *ExchangeRateUnavailableError:
+		errorCode, httpStatusCode = exchangeRateUnavailableErrCode, http.StatusBadGateway
+	case *BatchError:
+		errorCode, httpStatusCode = batchPaymentRejectedErrCode, http.StatusBadRequest
+	case *payment.LimitExceededError:
+		errorCode, httpStatusCode = limitExceededErrCode, http.StatusConflict
+	case *RateLimitedError:
+		errorCode, httpStatusCode = rateLimitedErrCode, http.StatusTooManyRequests
 
 	default:
 		switch err {
 		case payment.LowBalanceErr:
 			errorCode, httpStatusCode = lowBalanceErrCode, http.StatusBadRequest
+		case payment.FromAccountClosedErr:
+			errorCode, httpStatusCode = fromAccountClosedErrCode, http.StatusConflict
+		case payment.ToAccountClosedErr:
+			errorCode, httpStatusCode = toAccountClosedErrCode, http.StatusConflict
+		case AccountNotFound:
+			errorCode, httpStatusCode = accountNotFoundErrCode, http.StatusNotFound
+		case PaymentNotFound:
+			errorCode, httpStatusCode = paymentNotFoundErrCode, http.StatusNotFound
+		case account.AlreadyExistsErr:
+			errorCode, httpStatusCode = accountAlreadyExistsErrCode, http.StatusConflict
+		case account.NonZeroBalanceErr:
+			errorCode, httpStatusCode = nonZeroBalanceErrCode, http.StatusConflict
 		case FromAccountNotFound:
 			errorCode, httpStatusCode = fromAccountNotFoundErrCode, http.StatusNotFound
 		case ToAccountNotFound:
 			errorCode, httpStatusCode = toAccountNotFoundErrCode, http.StatusNotFound
+		case payment.FromAccountFrozenErr:
+			errorCode, httpStatusCode = fromAccountFrozenErrCode, http.StatusConflict
+		case payment.FromAccountSuspendedErr:
+			errorCode, httpStatusCode = fromAccountSuspendedErrCode, http.StatusConflict
+		case payment.ToAccountSuspendedErr:
+			errorCode, httpStatusCode = toAccountSuspendedErrCode, http.StatusConflict
+		case account.ClosedErr:
+			errorCode, httpStatusCode = accountClosedErrCode, http.StatusConflict
+		case UnauthenticatedErr:
+			errorCode, httpStatusCode = unauthenticatedErrCode, http.StatusUnauthorized
+		case AccountNotOwnedErr:
+			errorCode, httpStatusCode = accountNotOwnedErrCode, http.StatusForbidden
+		case ForbiddenErr:
+			errorCode, httpStatusCode = forbiddenErrCode, http.StatusForbidden
+		case IdempotencyKeyReusedErr:
+			errorCode, httpStatusCode = idempotencyKeyReusedErrCode, http.StatusConflict
+		case payment.RefundExceedsAmountErr:
+			errorCode, httpStatusCode = refundExceedsAmountErrCode, http.StatusConflict
+		case HoldNotFound:
+			errorCode, httpStatusCode = holdNotFoundErrCode, http.StatusNotFound
+		case hold.NotActiveErr:
+			errorCode, httpStatusCode = holdNotActiveErrCode, http.StatusConflict
+		case hold.ExpiredErr:
+			errorCode, httpStatusCode = holdExpiredErrCode, http.StatusConflict
+		case hold.CaptureExceedsAmountErr:
+			errorCode, httpStatusCode = captureExceedsHoldErrCode, http.StatusConflict
+		case ScheduledPaymentNotFound:
+			errorCode, httpStatusCode = scheduledPaymentNotFoundErrCode, http.StatusNotFound
+		case schedule.NotPendingErr:
+			errorCode, httpStatusCode = scheduledPaymentNotPendingErrCode, http.StatusConflict
+		case StandingOrderNotFound:
+			errorCode, httpStatusCode = standingOrderNotFoundErrCode, http.StatusNotFound
+		case standing.NotActiveErr:
+			errorCode, httpStatusCode = standingOrderNotActiveErrCode, http.StatusConflict
 		default:
 			errorCode, httpStatusCode = internalErrorErrCode, http.StatusInternalServerError
 		}
 	}
-	w.WriteHeader(httpStatusCode)
-	errorMessage := strings.ToUpper(err.Error()[:1]) + err.Error()[1:] + "."
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"error": map[string]interface{}{
-			"code":    errorCode,
-			"message": errorMessage,
-		},
-	})
+	return errorCode, httpStatusCode
 }
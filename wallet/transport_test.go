@@ -0,0 +1,32 @@
+package wallet
+
+import (
+	"github.com/georgysavva/generic-wallet/standing"
+	"github.com/georgysavva/generic-wallet/tracing"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestMakeHandler_InternalIdempotencyKey(t *testing.T) {
+	s := instantiateServiceForTests()
+	handler := MakeHandler(s, nil, RateLimits{}, tracing.NewTracer(nil), kitlog.NewNopLogger())
+	sendPayment := func(idempotencyKey string) int {
+		form := url.Values{"from_account": {"alice"}, "to_account": {"bob"}, "amount": {"10"}}
+		r := httptest.NewRequest("POST", "/wallet/v1/payments", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set(idempotencyKeyHeader, idempotencyKey)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusBadRequest, sendPayment("internal:scheduled_payment:1"))
+	order := &standing.Order{Id: "order"}
+	assert.Equal(t, http.StatusBadRequest, sendPayment(order.IdempotencyKey(1)))
+	assert.Equal(t, http.StatusCreated, sendPayment("scheduled_payment:1"))
+}